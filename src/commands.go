@@ -0,0 +1,266 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"http-bomber/compare"
+	"http-bomber/elasticsearch"
+	"http-bomber/kibana"
+	"http-bomber/sqlite"
+)
+
+// Subcommands which are run instead of a test when given as the first argument.
+// Each command gets the remaining arguments and returns the exit code.
+var commands = map[string]func(args []string) int{
+	"compare":      compareCommand,
+	"flush-spool":  flushSpoolCommand,
+	"ingest":       ingestCommand,
+	"kibana-setup": kibanaSetupCommand,
+	"query":        queryCommand,
+}
+
+// Find the subcommand given as the first argument
+func subcommand() (func(args []string) int, bool) {
+	if len(os.Args) < 2 {
+		return nil, false
+	}
+	cmd, found := commands[os.Args[1]]
+	return cmd, found
+}
+
+// Compare two saved runs and fail on regressions
+func compareCommand(args []string) int {
+	var config compare.Config
+	var format string
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: http-bomber compare [options] <baseline files> <candidate files>")
+		fmt.Fprintln(fs.Output(), "Files are glob patterns, e.g. \"/tmp/baseline.json-*\" for the files written by -elastic-export-to-file")
+		fs.PrintDefaults()
+	}
+	tolerance := fs.Float64("tolerance", 10, "Allowed change of latency percentiles and throughput in percent")
+	errorRateTolerance := fs.Float64("error-rate-tolerance", 1, "Allowed increase of the error rate in percentage points")
+	fs.Float64Var(&config.Alpha, "alpha", 0.05, "Significance level of the latency test (Mann-Whitney U)")
+	fs.StringVar(&format, "format", "table", "Output format <table|json>")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return exitToolError
+	}
+	config.Tolerance = *tolerance / 100
+	config.ErrorRateTolerance = *errorRateTolerance / 100
+
+	baseline, err := compare.LoadFiles(fs.Arg(0))
+	if err != nil {
+		logger.Critical(fmt.Sprint("Could not load baseline: ", err))
+		return exitToolError
+	}
+	candidate, err := compare.LoadFiles(fs.Arg(1))
+	if err != nil {
+		logger.Critical(fmt.Sprint("Could not load candidate: ", err))
+		return exitToolError
+	}
+
+	comparisons := compare.Compare(&config, baseline, candidate)
+	if format == "json" {
+		err = compare.WriteJSON(os.Stdout, comparisons)
+	} else {
+		err = compare.WriteTable(os.Stdout, comparisons)
+	}
+	if err != nil {
+		logger.Critical(fmt.Sprint("Could not write comparison: ", err))
+		return exitToolError
+	}
+	if compare.Regressed(comparisons) {
+		logger.Error("Regression detected")
+		return exitThresholdFailure
+	}
+	return exitOK
+}
+
+// Run a built-in report against a SQLite result store
+func queryCommand(args []string) int {
+	var filter sqlite.Filter
+	var options sqlite.Options
+	var path, format string
+	names := make([]string, 0, len(sqlite.Reports))
+	for name := range sqlite.Reports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: http-bomber query [options] <%s>\n", strings.Join(names, "|"))
+		fmt.Fprintln(fs.Output(), "Reports on results stored with -sqlite-path")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&path, "db", "http-bomber.db", "SQLite database written by -sqlite-path")
+	fs.StringVar(&filter.Run, "run", "latest", "Run ID, latest or all")
+	fs.StringVar(&filter.URL, "url", "", "Only URLs containing this text")
+	fs.DurationVar(&options.Bucket, "bucket", 10*time.Second, "Time bucket of the errors report")
+	fs.IntVar(&options.Limit, "limit", 10, "Number of requests in the slowest report")
+	fs.StringVar(&format, "format", "table", "Output format <table|json>")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitToolError
+	}
+	query, found := sqlite.Reports[fs.Arg(0)]
+	if !found {
+		fs.Usage()
+		return exitToolError
+	}
+	if _, err := os.Stat(path); err != nil {
+		logger.Critical(fmt.Sprint("Could not open database: ", err))
+		return exitToolError
+	}
+
+	db, err := sqlite.Open(path)
+	if err != nil {
+		logger.Critical(fmt.Sprint("Could not open database: ", err))
+		return exitToolError
+	}
+	defer db.Close()
+	report, err := query(db, &filter, &options)
+	if err != nil {
+		logger.Critical(fmt.Sprint("Query failed: ", err))
+		return exitToolError
+	}
+	if format == "json" {
+		err = report.WriteJSON(os.Stdout)
+	} else {
+		err = report.WriteTable(os.Stdout)
+	}
+	if err != nil {
+		logger.Critical(fmt.Sprint("Could not write report: ", err))
+		return exitToolError
+	}
+	return exitOK
+}
+
+// Send the documents left in an Elasticsearch spool by an earlier run
+func flushSpoolCommand(args []string) int {
+	var mod elasticsearch.Module
+	var wg sync.WaitGroup
+	fs := flag.NewFlagSet("flush-spool", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: http-bomber flush-spool -elastic-spool-dir <dir> [options]")
+		fmt.Fprintln(fs.Output(), "Sends the documents spooled by -elastic-spool-dir, with the connection options of -elastic-export")
+		fs.PrintDefaults()
+	}
+	mod.RegisterFlags(fs)
+	debug := fs.Bool("debug", false, "This flag turns debugging on.")
+	fs.Parse(args)
+	if mod.Config.SpoolDir == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return exitToolError
+	}
+	mod.Init(&wg, &logger, *debug)
+	if err := mod.FlushSpool(); err != nil {
+		logger.Error(fmt.Sprint("Could not flush the spool: ", err))
+		return exitToolError
+	}
+	return exitOK
+}
+
+// Send bulk files written by -elastic-export-to-file to Elasticsearch
+func ingestCommand(args []string) int {
+	var mod elasticsearch.Module
+	var wg sync.WaitGroup
+	fs := flag.NewFlagSet("ingest", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: http-bomber ingest [options] <files>")
+		fmt.Fprintln(fs.Output(), "Files are glob patterns of bulk files (gzip compressed files are supported), e.g. \"/tmp/results.json-*\" for the files written by -elastic-export-to-file")
+		fs.PrintDefaults()
+	}
+	mod.RegisterFlags(fs)
+	index := fs.String("index", "", "Write the documents into this index (or data stream, see -elastic-index-mode) instead of the one in the files")
+	progress := fs.Duration("progress", 5*time.Second, "Interval of progress messages")
+	debug := fs.Bool("debug", false, "This flag turns debugging on.")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitToolError
+	}
+	var paths []string
+	for _, pattern := range fs.Args() {
+		matches, err := filepath.Glob(pattern)
+		if err != nil || len(matches) == 0 {
+			logger.Critical(fmt.Sprintf("No files found matching %s", pattern))
+			return exitToolError
+		}
+		paths = append(paths, matches...)
+	}
+	mod.Init(&wg, &logger, *debug)
+	if err := mod.Ingest(paths, *index, *progress); err != nil {
+		logger.Error(fmt.Sprint("Ingest failed: ", err))
+		return exitToolError
+	}
+	return exitOK
+}
+
+// Import the bundled data views, visualizations and dashboards into Kibana
+func kibanaSetupCommand(args []string) int {
+	var auth elasticsearch.Config
+	var client kibana.Client
+	var index, summaryIndex string
+	var overwrite bool
+	fs := flag.NewFlagSet("kibana-setup", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: http-bomber kibana-setup [options]")
+		fmt.Fprintf(fs.Output(), "Imports dashboards for the exported results into Kibana %s or later\n", kibana.MinVersion)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&client.URL, "url", "http://localhost:5601", "Kibana URL")
+	fs.StringVar(&client.Space, "space", "", "Kibana space (default space if not set)")
+	fs.StringVar(&index, "index", "testdata", "Index (or data stream) of the results, as in -elastic-index")
+	fs.StringVar(&summaryIndex, "summary-index", "", "Index of the run summaries, as in -elastic-summary-index (no run trends if not set)")
+	fs.BoolVar(&overwrite, "overwrite", true, "Replace objects imported before")
+	fs.StringVar(&auth.Username, "username", "", "Kibana username (basic auth)")
+	fs.StringVar(&auth.Password, "password", "", "Kibana password (basic auth)")
+	fs.StringVar(&auth.APIKey, "api-key", "", "Elasticsearch API key, encoded or as <id>:<api_key>")
+	fs.StringVar(&auth.CAFile, "ca-file", "", "PEM file of a CA to trust for HTTPS connections to Kibana")
+	fs.DurationVar(&auth.Timeout, "timeout", 30*time.Second, "Timeout of Kibana requests")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return exitToolError
+	}
+
+	var err error
+	if client.HTTP, err = elasticsearch.NewClient(&auth); err != nil {
+		logger.Critical(fmt.Sprint("Could not create client: ", err))
+		return exitToolError
+	}
+	version, err := client.CheckVersion()
+	if err != nil {
+		logger.Critical(fmt.Sprint("Could not check the Kibana version: ", err))
+		return exitToolError
+	}
+	logger.Info(fmt.Sprintf("Importing saved objects into Kibana %s at %s", version, client.URL))
+
+	// the pattern matches a single index, daily indices and data streams alike
+	objects, err := kibana.SavedObjects(index+"*", summaryIndex)
+	if err != nil {
+		logger.Critical(fmt.Sprint("Could not prepare the saved objects: ", err))
+		return exitToolError
+	}
+	result, err := client.Import(objects, overwrite)
+	if err != nil {
+		logger.Critical(fmt.Sprint("Import failed: ", err))
+		return exitToolError
+	}
+	logger.Info(fmt.Sprintf("Imported %d saved objects", result.SuccessCount))
+	if err := result.Err(); err != nil {
+		logger.Error(err.Error())
+		return exitToolError
+	}
+	return exitOK
+}
@@ -0,0 +1,337 @@
+package compare
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"http-bomber/httptest"
+	"http-bomber/stats"
+)
+
+// Config holds the regression criteria
+type Config struct {
+	// Tolerance is the allowed relative change (0.1 = 10%) of latency percentiles and throughput
+	Tolerance float64
+	// ErrorRateTolerance is the allowed absolute increase of the error rate (0.01 = 1 percentage point)
+	ErrorRateTolerance float64
+	// Alpha is the significance level of the latency test
+	Alpha float64
+}
+
+// Delta holds the change of one metric between two runs
+type Delta struct {
+	Metric    string  `json:"metric"`
+	Baseline  float64 `json:"baseline"`
+	Candidate float64 `json:"candidate"`
+	// Change is relative, except for the error rate (percentage points). It is nil
+	// (null) if the baseline is 0 and the candidate is not, as there is no relative change.
+	Change     *float64 `json:"change"`
+	Regression bool     `json:"regression"`
+}
+
+// Comparison holds the comparison of one URL
+type Comparison struct {
+	URL string `json:"url"`
+	// Baseline or Candidate is nil if the URL is missing from that run
+	Baseline  *stats.Summary `json:"-"`
+	Candidate *stats.Summary `json:"-"`
+	Deltas    []*Delta       `json:"deltas"`
+	// PValue of the one-sided Mann-Whitney U test "candidate latencies are larger than baseline latencies"
+	PValue     float64 `json:"p_value"`
+	Regression bool    `json:"regression"`
+	Message    string  `json:"message,omitempty"`
+}
+
+// LoadFiles reads results from files matching the given glob patterns
+func LoadFiles(patterns ...string) ([]*httptest.Result, error) {
+	var results []*httptest.Result
+	for _, pattern := range patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("no files match %s", pattern)
+		}
+		for _, path := range paths {
+			fileResults, err := LoadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			results = append(results, fileResults...)
+		}
+	}
+	return results, nil
+}
+
+// LoadFile reads results from a file with one JSON document per line, such as the
+// Elasticsearch bulk files written by -elastic-export-to-file. Bulk action lines are skipped.
+// Gzip compressed files are detected automatically.
+func LoadFile(path string) ([]*httptest.Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	reader := bufio.NewReader(f)
+	var r io.Reader = reader
+	if magic, err := reader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var results []*httptest.Result
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		result := &httptest.Result{}
+		if err := json.Unmarshal(scanner.Bytes(), result); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		// action lines do not have an URL
+		if result.URL == "" {
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, scanner.Err()
+}
+
+// groupByURL splits results into summaries and latency samples per URL
+func groupByURL(results []*httptest.Result) (map[string]*stats.Summary, map[string][]float64) {
+	byURL := make(map[string][]*httptest.Result)
+	for _, r := range results {
+		byURL[r.URL] = append(byURL[r.URL], r)
+	}
+	summaries := make(map[string]*stats.Summary)
+	samples := make(map[string][]float64)
+	for url, resultSet := range byURL {
+		summaries[url] = stats.Summarize(url, resultSet)
+		for _, r := range resultSet {
+			if r.Error == "" {
+				samples[url] = append(samples[url], float64(r.ReqRoundTrip))
+			}
+		}
+	}
+	return summaries, samples
+}
+
+// Compare compares a candidate run to a baseline run URL by URL
+func Compare(config *Config, baseline []*httptest.Result, candidate []*httptest.Result) []*Comparison {
+	baseSummaries, baseSamples := groupByURL(baseline)
+	candSummaries, candSamples := groupByURL(candidate)
+
+	urls := make(map[string]bool)
+	for url := range baseSummaries {
+		urls[url] = true
+	}
+	for url := range candSummaries {
+		urls[url] = true
+	}
+	sortedURLs := make([]string, 0, len(urls))
+	for url := range urls {
+		sortedURLs = append(sortedURLs, url)
+	}
+	sort.Strings(sortedURLs)
+
+	var comparisons []*Comparison
+	for _, url := range sortedURLs {
+		c := &Comparison{URL: url, Baseline: baseSummaries[url], Candidate: candSummaries[url], PValue: 1}
+		comparisons = append(comparisons, c)
+		if c.Baseline == nil {
+			c.Message = "not in baseline"
+			continue
+		}
+		if c.Candidate == nil {
+			// the candidate didn't test the URL at all (or failed to), which is worse
+			// than any change of its figures
+			c.Message = "not in candidate"
+			c.Regression = true
+			continue
+		}
+		c.PValue = MannWhitneyU(baseSamples[url], candSamples[url])
+		significant := c.PValue < config.Alpha
+
+		b, n := c.Baseline.Latency, c.Candidate.Latency
+		for _, d := range []*Delta{
+			{Metric: "p50", Baseline: float64(b.P50), Candidate: float64(n.P50)},
+			{Metric: "p90", Baseline: float64(b.P90), Candidate: float64(n.P90)},
+			{Metric: "p95", Baseline: float64(b.P95), Candidate: float64(n.P95)},
+			{Metric: "p99", Baseline: float64(b.P99), Candidate: float64(n.P99)},
+		} {
+			d.Change = relativeChange(d.Baseline, d.Candidate)
+			// latency regressions need to be statistically significant to rule out noise,
+			// latency growing from 0 grew more than any tolerance
+			d.Regression = significant && (d.Change == nil || *d.Change > config.Tolerance)
+			c.Deltas = append(c.Deltas, d)
+		}
+
+		rps := &Delta{Metric: "rps", Baseline: c.Baseline.RPS, Candidate: c.Candidate.RPS}
+		rps.Change = relativeChange(rps.Baseline, rps.Candidate)
+		// throughput growing from 0 is no regression
+		rps.Regression = rps.Change != nil && *rps.Change < -config.Tolerance
+		c.Deltas = append(c.Deltas, rps)
+
+		errorRate := &Delta{Metric: "error_rate", Baseline: c.Baseline.ErrorRate, Candidate: c.Candidate.ErrorRate}
+		change := errorRate.Candidate - errorRate.Baseline
+		errorRate.Change = &change
+		errorRate.Regression = change > config.ErrorRateTolerance
+		c.Deltas = append(c.Deltas, errorRate)
+
+		for _, d := range c.Deltas {
+			if d.Regression {
+				c.Regression = true
+			}
+		}
+	}
+	return comparisons
+}
+
+// relativeChange returns nil if the baseline is 0 and the candidate is not
+func relativeChange(baseline float64, candidate float64) *float64 {
+	var change float64
+	if baseline == 0 {
+		if candidate != 0 {
+			return nil
+		}
+	} else {
+		change = (candidate - baseline) / baseline
+	}
+	return &change
+}
+
+// MannWhitneyU returns the p-value of the one-sided Mann-Whitney U test for
+// "values of b tend to be larger than values of a" using the normal approximation
+// with tie correction. Returns 1 if either sample is empty.
+func MannWhitneyU(a []float64, b []float64) float64 {
+	n1, n2 := float64(len(a)), float64(len(b))
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	type sample struct {
+		value   float64
+		fromB   bool
+		ranking float64
+	}
+	all := make([]sample, 0, len(a)+len(b))
+	for _, v := range a {
+		all = append(all, sample{value: v})
+	}
+	for _, v := range b {
+		all = append(all, sample{value: v, fromB: true})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].value < all[j].value })
+
+	// rank with ties getting the average rank, collect tie correction
+	var tieCorrection float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].value == all[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			all[k].ranking = rank
+		}
+		t := float64(j - i)
+		tieCorrection += t*t*t - t
+		i = j
+	}
+	var rankSumB float64
+	for _, s := range all {
+		if s.fromB {
+			rankSumB += s.ranking
+		}
+	}
+	n := n1 + n2
+	u := rankSumB - n2*(n2+1)/2
+	mean := n1 * n2 / 2
+	variance := n1 * n2 / 12 * ((n + 1) - tieCorrection/(n*(n-1)))
+	if variance <= 0 {
+		return 1
+	}
+	z := (u - mean) / math.Sqrt(variance)
+	return 0.5 * math.Erfc(z/math.Sqrt2)
+}
+
+// Regressed returns true if any URL regressed
+func Regressed(comparisons []*Comparison) bool {
+	for _, c := range comparisons {
+		if c.Regression {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteJSON writes the comparisons as indented JSON
+func WriteJSON(w io.Writer, comparisons []*Comparison) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(comparisons)
+}
+
+// WriteTable writes the comparisons as a human readable table
+func WriteTable(w io.Writer, comparisons []*Comparison) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "URL\tMETRIC\tBASELINE\tCANDIDATE\tCHANGE\t")
+	for _, c := range comparisons {
+		if c.Message != "" {
+			flag := ""
+			if c.Regression {
+				flag = "REGRESSION"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t\t\t\t%s\n", c.URL, c.Message, flag)
+			continue
+		}
+		for _, d := range c.Deltas {
+			flag := ""
+			if d.Regression {
+				flag = "REGRESSION"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", c.URL, d.Metric, formatValue(d.Metric, d.Baseline), formatValue(d.Metric, d.Candidate), formatChange(d), flag)
+		}
+		fmt.Fprintf(tw, "%s\tp-value\t\t%.4g\t\t\n", c.URL, c.PValue)
+	}
+	return tw.Flush()
+}
+
+func formatValue(metric string, v float64) string {
+	switch metric {
+	case "rps":
+		return fmt.Sprintf("%.2f", v)
+	case "error_rate":
+		return fmt.Sprintf("%.2f%%", v*100)
+	default:
+		return time.Duration(v).Round(time.Microsecond).String()
+	}
+}
+
+func formatChange(d *Delta) string {
+	switch {
+	case d.Change == nil:
+		return "from 0"
+	case d.Metric == "error_rate":
+		return fmt.Sprintf("%+.2fpp", *d.Change*100)
+	default:
+		return fmt.Sprintf("%+.1f%%", *d.Change*100)
+	}
+}
@@ -0,0 +1,183 @@
+package compare
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"http-bomber/httptest"
+)
+
+func results(url string, errors int, roundTrips ...time.Duration) []*httptest.Result {
+	var rs []*httptest.Result
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, rt := range roundTrips {
+		r := &httptest.Result{URL: url, RespStatusCode: 200, ReqRoundTrip: rt, ReqStartTime: start.Add(time.Duration(i) * time.Second)}
+		r.ReqEndTime = r.ReqStartTime.Add(rt)
+		r.Timestamp = r.ReqStartTime
+		// the first requests failed without a response
+		if i < errors {
+			r.RespStatusCode = 0
+			r.Error = "dial tcp: i/o timeout"
+			r.ErrorKind = "timeout"
+		}
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+func TestCompareErrorRateFromZero(t *testing.T) {
+	config := &Config{Tolerance: 0.1, ErrorRateTolerance: 0.01, Alpha: 0.05}
+	ms := time.Millisecond
+	baseline := results("http://a", 0, 10*ms, 11*ms, 12*ms, 10*ms)
+	candidate := results("http://a", 2, 10*ms, 11*ms, 12*ms, 10*ms)
+	comparisons := Compare(config, baseline, candidate)
+
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, comparisons); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	var decoded []struct {
+		Deltas []struct {
+			Metric string   `json:"metric"`
+			Change *float64 `json:"change"`
+		} `json:"deltas"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range decoded[0].Deltas {
+		if d.Metric == "error_rate" && (d.Change == nil || *d.Change != 0.5) {
+			t.Errorf("error rate change %v, want 0.5", d.Change)
+		}
+	}
+	if !comparisons[0].Regression {
+		t.Error("no regression, want one for an error rate from 0 to 50%")
+	}
+	if err := WriteTable(&buf, comparisons); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompareLatencyFromZero(t *testing.T) {
+	config := &Config{Tolerance: 0.1, ErrorRateTolerance: 0.01, Alpha: 0.05}
+	ms := time.Millisecond
+	// all requests of the baseline failed, so it has no latency
+	baseline := results("http://a", 4, 10*ms, 11*ms, 12*ms, 10*ms)
+	candidate := results("http://a", 0, 10*ms, 11*ms, 12*ms, 10*ms)
+	comparisons := Compare(config, baseline, candidate)
+
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, comparisons); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`"change": null`)) {
+		t.Errorf("no null change in %s", buf.String())
+	}
+}
+
+func TestRelativeChangeFromZero(t *testing.T) {
+	if c := relativeChange(0, 0); c == nil || *c != 0 {
+		t.Errorf("0 -> 0 = %v, want 0", c)
+	}
+	if c := relativeChange(0, 5); c != nil {
+		t.Errorf("0 -> 5 = %v, want nil", *c)
+	}
+	if c := relativeChange(4, 5); c == nil || *c != 0.25 {
+		t.Errorf("4 -> 5 = %v, want 0.25", c)
+	}
+}
+
+func TestCompareURLMissingFromCandidate(t *testing.T) {
+	config := &Config{Tolerance: 0.1, ErrorRateTolerance: 0.01, Alpha: 0.05}
+	ms := time.Millisecond
+	baseline := append(results("http://a", 0, 10*ms, 11*ms), results("http://b", 0, 10*ms, 11*ms)...)
+	candidate := append(results("http://a", 0, 10*ms, 11*ms), results("http://c", 0, 10*ms)...)
+	comparisons := Compare(config, baseline, candidate)
+
+	byURL := make(map[string]*Comparison)
+	for _, c := range comparisons {
+		byURL[c.URL] = c
+	}
+	if c := byURL["http://b"]; c == nil || !c.Regression || c.Message != "not in candidate" {
+		t.Errorf("missing URL compared as %+v, want a regression", c)
+	}
+	if c := byURL["http://c"]; c == nil || c.Regression {
+		t.Errorf("new URL compared as %+v, want no regression", c)
+	}
+	if !Regressed(comparisons) {
+		t.Error("the comparison did not regress")
+	}
+}
+
+func TestMannWhitneyU(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float64
+		// p-values of R's wilcox.test(b, a, alternative = "greater", exact = FALSE, correct = FALSE)
+		want float64
+	}{
+		// U = 9
+		{"b larger", []float64{1, 2, 3}, []float64{4, 5, 6}, 0.0247673},
+		// U = 0
+		{"b smaller", []float64{4, 5, 6}, []float64{1, 2, 3}, 0.9752327},
+		// U = 13 with tie correction for 2 and 3
+		{"ties", []float64{1, 2, 2, 3}, []float64{2, 3, 3, 4}, 0.0645775},
+		// U = 12.5 (all pairs tied or balanced)
+		{"same values", []float64{1, 2, 3, 4, 5}, []float64{1, 2, 3, 4, 5}, 0.5},
+		// U = 29.5, different sample sizes
+		{"overlapping", []float64{10, 11, 12, 13, 14, 15, 16, 17}, []float64{12, 14, 16, 18, 20}, 0.0812934},
+		// U = 1, smallest possible samples
+		{"one each", []float64{5}, []float64{7}, 0.1586553},
+		// no variance at all
+		{"all tied", []float64{3, 3}, []float64{3, 3, 3}, 1},
+		{"empty a", nil, []float64{1, 2}, 1},
+		{"empty b", []float64{1, 2}, nil, 1},
+	}
+	for _, tt := range tests {
+		if got := MannWhitneyU(tt.a, tt.b); math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("%s: p = %.7f, want %.7f", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCompareShiftedLatency(t *testing.T) {
+	config := &Config{Tolerance: 0.1, ErrorRateTolerance: 0.01, Alpha: 0.05}
+	var base, shifted, noisy []time.Duration
+	for i := 0; i < 30; i++ {
+		// 10ms to 12.9ms
+		rt := 10*time.Millisecond + time.Duration(i)*100*time.Microsecond
+		base = append(base, rt)
+		// 50% slower
+		shifted = append(shifted, rt*3/2)
+		// the same values in another order
+		noisy = append(noisy, 10*time.Millisecond+time.Duration((i*7)%30)*100*time.Microsecond)
+	}
+	tests := []struct {
+		name       string
+		candidate  []time.Duration
+		regression bool
+	}{
+		{"shifted", shifted, true},
+		{"noise", noisy, false},
+	}
+	for _, tt := range tests {
+		comparisons := Compare(config, results("http://a", 0, base...), results("http://a", 0, tt.candidate...))
+		c := comparisons[0]
+		if c.Regression != tt.regression {
+			t.Errorf("%s: regression %v (p = %v), want %v", tt.name, c.Regression, c.PValue, tt.regression)
+		}
+		if tt.regression {
+			if c.PValue >= config.Alpha {
+				t.Errorf("%s: p = %v, want below %v", tt.name, c.PValue, config.Alpha)
+			}
+			for _, d := range c.Deltas {
+				if d.Metric == "p50" && (!d.Regression || d.Change == nil || *d.Change < 0.45 || *d.Change > 0.55) {
+					t.Errorf("%s: p50 delta %+v, want a regression of about 50%%", tt.name, d)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module http-bomber/compare
+
+go 1.16
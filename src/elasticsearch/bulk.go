@@ -0,0 +1,225 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"http-bomber/logging"
+)
+
+// BulkIndexer sends documents to the _bulk API in batches bounded by the number of
+// documents and the size of the request body. Items which Elasticsearch rejects
+// temporarily (429, 5xx) are retried with backoff, others are dropped. Items which
+// still fail after the retries are handed to Spool, if set, or dropped.
+type BulkIndexer struct {
+	Client *http.Client
+	URL    string
+	// Action is the bulk action, index or create (default index)
+	Action string
+	// Pipeline is the ingest pipeline the documents go through (if set)
+	Pipeline string
+	// MaxDocs and MaxBytes bound a batch, whichever is reached first
+	MaxDocs  int
+	MaxBytes int
+	// Retries of rejected items (and failed requests), Backoff is doubled after each retry
+	Retries int
+	Backoff time.Duration
+	// Spool keeps items which could not be sent, to be sent later
+	Spool  func(items [][]byte) error
+	Logger *logging.Logger
+	Debug  bool
+
+	// items of the current batch, each an action line and a document line
+	items [][]byte
+	size  int
+	body  bytes.Buffer
+
+	// Indexed, Dropped and Spooled count the documents, LastError holds the reason of the last drop
+	Indexed   int
+	Dropped   int
+	Spooled   int
+	LastError string
+}
+
+// bulkResponse is the part of the _bulk response needed to find rejected items
+type bulkResponse struct {
+	Errors bool                         `json:"errors"`
+	Items  []map[string]bulkItemOutcome `json:"items"`
+}
+
+type bulkItemOutcome struct {
+	Status int `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+// Add queues a document (JSON) for an index and sends the batch when it is full
+func (b *BulkIndexer) Add(index string, doc []byte) {
+	action := b.Action
+	if action == "" {
+		action = "index"
+	}
+	item := make([]byte, 0, len(doc)+len(index)+32)
+	item = append(item, `{"`...)
+	item = append(item, action...)
+	item = append(item, `":{"_index":`...)
+	name, _ := json.Marshal(index)
+	item = append(item, name...)
+	item = append(item, "}}\n"...)
+	item = append(item, doc...)
+	item = append(item, '\n')
+	b.add(item)
+}
+
+// add queues an item, an action line and a document line
+func (b *BulkIndexer) add(item []byte) {
+	if len(b.items) > 0 && b.size+len(item) > b.MaxBytes {
+		b.Flush()
+	}
+	b.items = append(b.items, item)
+	b.size += len(item)
+	if len(b.items) >= b.MaxDocs {
+		b.Flush()
+	}
+}
+
+// Flush sends the current batch, retrying rejected items
+func (b *BulkIndexer) Flush() {
+	items := b.items
+	b.items = nil
+	b.size = 0
+	backoff := b.Backoff
+	var err error
+	for attempt := 0; len(items) > 0; attempt++ {
+		if attempt > 0 {
+			if attempt > b.Retries {
+				b.spool(items, err)
+				return
+			}
+			if b.Debug {
+				b.Logger.Debug(fmt.Sprintf("Retrying %d documents in %s (%s)", len(items), backoff, err))
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		items, err = b.send(items)
+	}
+}
+
+// send makes one bulk request and returns the items which may be retried and why.
+// Items which failed for good are counted as dropped.
+func (b *BulkIndexer) send(items [][]byte) ([][]byte, error) {
+	b.body.Reset()
+	for _, item := range items {
+		b.body.Write(item)
+	}
+	path := "/_bulk"
+	if b.Pipeline != "" {
+		path += "?pipeline=" + url.QueryEscape(b.Pipeline)
+	}
+	req, err := http.NewRequest("POST", b.URL+path, bytes.NewReader(b.body.Bytes()))
+	if err != nil {
+		b.drop(len(items), err)
+		return nil, nil
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return items, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return items, err
+	}
+	if resp.StatusCode/100 != 2 {
+		err := fmt.Errorf("status %s: %s", resp.Status, truncate(string(data), 200))
+		if retryable(resp.StatusCode) {
+			return items, err
+		}
+		b.drop(len(items), err)
+		return nil, nil
+	}
+
+	var outcome bulkResponse
+	if err := json.Unmarshal(data, &outcome); err != nil {
+		b.drop(len(items), fmt.Errorf("invalid bulk response: %v", err))
+		return nil, nil
+	}
+	if !outcome.Errors {
+		b.Indexed += len(items)
+		return nil, nil
+	}
+	if len(outcome.Items) != len(items) {
+		b.drop(len(items), fmt.Errorf("bulk response has %d items, expected %d", len(outcome.Items), len(items)))
+		return nil, nil
+	}
+	var retry [][]byte
+	var retryErr error
+	for i, result := range outcome.Items {
+		for _, item := range result {
+			err := fmt.Errorf("status %d", item.Status)
+			if item.Error != nil {
+				err = fmt.Errorf("%s: %s", item.Error.Type, item.Error.Reason)
+			}
+			switch {
+			case item.Status/100 == 2:
+				b.Indexed++
+			case retryable(item.Status):
+				retry = append(retry, items[i])
+				retryErr = err
+			default:
+				b.drop(1, err)
+			}
+		}
+	}
+	return retry, retryErr
+}
+
+// spool hands items over to the spool, or drops them if there is none
+func (b *BulkIndexer) spool(items [][]byte, err error) {
+	if b.Spool == nil {
+		b.drop(len(items), err)
+		return
+	}
+	if spoolErr := b.Spool(items); spoolErr != nil {
+		b.drop(len(items), fmt.Errorf("%v (spool failed: %v)", err, spoolErr))
+		return
+	}
+	b.Spooled += len(items)
+	if b.Debug {
+		b.Logger.Debug(fmt.Sprintf("Spooled %d documents: %v", len(items), err))
+	}
+}
+
+// drop counts documents which are given up
+func (b *BulkIndexer) drop(n int, err error) {
+	b.Dropped += n
+	if err != nil {
+		b.LastError = err.Error()
+	}
+	if b.Debug {
+		b.Logger.Debug(fmt.Sprintf("Dropped %d documents: %s", n, b.LastError))
+	}
+}
+
+// retryable tells if a status means Elasticsearch is overloaded or temporarily unavailable
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+func truncate(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) > n {
+		return s[:n] + "..."
+	}
+	return s
+}
@@ -0,0 +1,247 @@
+package elasticsearch
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBulk is a _bulk endpoint which answers each request as the respond function says:
+// a status of the whole request, and if that is 200 a status per document
+type fakeBulk struct {
+	mu       sync.Mutex
+	requests [][]string
+	times    []time.Time
+	paths    []string
+	respond  func(request int, docs []string) (int, []int)
+}
+
+func (f *fakeBulk) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var docs []string
+	scanner := bufio.NewScanner(r.Body)
+	for i := 0; scanner.Scan(); i++ {
+		// odd lines are the documents, after their action lines
+		if i%2 == 1 {
+			docs = append(docs, scanner.Text())
+		}
+	}
+	f.mu.Lock()
+	n := len(f.requests)
+	f.requests = append(f.requests, docs)
+	f.times = append(f.times, time.Now())
+	f.paths = append(f.paths, r.URL.RequestURI())
+	f.mu.Unlock()
+
+	status, items := f.respond(n, docs)
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		fmt.Fprint(w, `{"error":"unavailable"}`)
+		return
+	}
+	response := bulkResponse{}
+	for _, s := range items {
+		outcome := bulkItemOutcome{Status: s}
+		if s/100 != 2 {
+			outcome.Error = &struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			}{Type: fmt.Sprintf("error_%d", s), Reason: "rejected"}
+			response.Errors = true
+		}
+		response.Items = append(response.Items, map[string]bulkItemOutcome{"index": outcome})
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func newTestIndexer(t *testing.T, respond func(int, []string) (int, []int)) (*BulkIndexer, *fakeBulk) {
+	fake := &fakeBulk{respond: respond}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return &BulkIndexer{
+		Client:   server.Client(),
+		URL:      server.URL,
+		MaxDocs:  100,
+		MaxBytes: 1 << 20,
+		Retries:  3,
+		Backoff:  10 * time.Millisecond,
+	}, fake
+}
+
+func addDocs(b *BulkIndexer, ids ...string) {
+	for _, id := range ids {
+		b.Add("testdata", []byte(fmt.Sprintf(`{"id":%q}`, id)))
+	}
+}
+
+// statusOf returns the status of the document with the given ID
+func statusOf(docs []string, statuses map[string]int) []int {
+	var items []int
+	for _, doc := range docs {
+		var d struct{ ID string }
+		json.Unmarshal([]byte(doc), &d)
+		items = append(items, statuses[d.ID])
+	}
+	return items
+}
+
+func TestBulkPartialFailures(t *testing.T) {
+	b, fake := newTestIndexer(t, func(request int, docs []string) (int, []int) {
+		if request == 0 {
+			return http.StatusOK, statusOf(docs, map[string]int{"ok": 201, "bad": 400, "busy": 429, "down": 503})
+		}
+		return http.StatusOK, statusOf(docs, map[string]int{"busy": 201, "down": 201})
+	})
+	addDocs(b, "ok", "bad", "busy", "down")
+	b.Flush()
+
+	if b.Indexed != 3 || b.Dropped != 1 || b.Spooled != 0 {
+		t.Errorf("indexed %d, dropped %d, spooled %d, want 3, 1, 0", b.Indexed, b.Dropped, b.Spooled)
+	}
+	if !strings.Contains(b.LastError, "error_400") {
+		t.Errorf("last error %q, want the reason of the dropped document", b.LastError)
+	}
+	if len(fake.requests) != 2 {
+		t.Fatalf("%d requests, want 2", len(fake.requests))
+	}
+	// only the documents rejected temporarily are sent again
+	if retried := strings.Join(fake.requests[1], ","); retried != `{"id":"busy"},{"id":"down"}` {
+		t.Errorf("retried %s, want busy and down", retried)
+	}
+}
+
+func TestBulkRetriesWithBackoff(t *testing.T) {
+	b, fake := newTestIndexer(t, func(request int, docs []string) (int, []int) {
+		switch request {
+		case 0:
+			return http.StatusTooManyRequests, nil
+		case 1:
+			return http.StatusBadGateway, nil
+		}
+		return http.StatusOK, statusOf(docs, map[string]int{"a": 201, "b": 201})
+	})
+	b.Backoff = 20 * time.Millisecond
+	addDocs(b, "a", "b")
+	b.Flush()
+
+	if b.Indexed != 2 || b.Dropped != 0 {
+		t.Errorf("indexed %d, dropped %d, want 2, 0", b.Indexed, b.Dropped)
+	}
+	if len(fake.times) != 3 {
+		t.Fatalf("%d requests, want 3", len(fake.times))
+	}
+	// the backoff is doubled after each retry
+	for i, want := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond} {
+		if waited := fake.times[i+1].Sub(fake.times[i]); waited < want {
+			t.Errorf("retry %d after %s, want at least %s", i+1, waited, want)
+		}
+	}
+}
+
+func TestBulkGivesUpAfterRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		itemLevel bool
+	}{
+		{"request 429", http.StatusTooManyRequests, false},
+		{"request 503", http.StatusServiceUnavailable, false},
+		{"item 429", http.StatusTooManyRequests, true},
+		{"item 500", http.StatusInternalServerError, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, fake := newTestIndexer(t, func(request int, docs []string) (int, []int) {
+				if !tt.itemLevel {
+					return tt.status, nil
+				}
+				return http.StatusOK, statusOf(docs, map[string]int{"a": tt.status, "b": tt.status})
+			})
+			b.Retries = 2
+			addDocs(b, "a", "b")
+			b.Flush()
+
+			if len(fake.requests) != 3 {
+				t.Errorf("%d requests, want 3 (one and two retries)", len(fake.requests))
+			}
+			if b.Indexed != 0 || b.Dropped != 2 {
+				t.Errorf("indexed %d, dropped %d, want 0, 2", b.Indexed, b.Dropped)
+			}
+			if !strings.Contains(b.LastError, fmt.Sprint(tt.status)) {
+				t.Errorf("last error %q, want status %d", b.LastError, tt.status)
+			}
+		})
+	}
+}
+
+func TestBulkSpoolsAfterRetries(t *testing.T) {
+	b, _ := newTestIndexer(t, func(int, []string) (int, []int) {
+		return http.StatusServiceUnavailable, nil
+	})
+	b.Retries = 1
+	var spooled [][]byte
+	b.Spool = func(items [][]byte) error {
+		spooled = append(spooled, items...)
+		return nil
+	}
+	addDocs(b, "a", "b", "c")
+	b.Flush()
+
+	if b.Spooled != 3 || b.Dropped != 0 || len(spooled) != 3 {
+		t.Errorf("spooled %d (%d items), dropped %d, want 3, 0", b.Spooled, len(spooled), b.Dropped)
+	}
+}
+
+func TestBulkDropsOtherErrorsWithoutRetry(t *testing.T) {
+	b, fake := newTestIndexer(t, func(int, []string) (int, []int) {
+		return http.StatusBadRequest, nil
+	})
+	addDocs(b, "a", "b")
+	b.Flush()
+
+	if len(fake.requests) != 1 || b.Dropped != 2 {
+		t.Errorf("%d requests, dropped %d, want 1, 2", len(fake.requests), b.Dropped)
+	}
+}
+
+func TestBulkBatches(t *testing.T) {
+	b, fake := newTestIndexer(t, func(request int, docs []string) (int, []int) {
+		items := make([]int, len(docs))
+		for i := range items {
+			items[i] = 201
+		}
+		return http.StatusOK, items
+	})
+	b.MaxDocs = 2
+	b.Pipeline = "geo ip"
+	addDocs(b, "a", "b", "c", "d", "e")
+	b.Flush()
+
+	if b.Indexed != 5 {
+		t.Errorf("indexed %d, want 5", b.Indexed)
+	}
+	var sizes []int
+	for _, r := range fake.requests {
+		sizes = append(sizes, len(r))
+	}
+	if fmt.Sprint(sizes) != "[2 2 1]" {
+		t.Errorf("batches of %v documents, want [2 2 1]", sizes)
+	}
+	if fake.paths[0] != "/_bulk?pipeline=geo+ip" {
+		t.Errorf("path %s, want the pipeline as a parameter", fake.paths[0])
+	}
+
+	// a batch is sent before it would exceed the byte bound
+	b, fake = newTestIndexer(t, fake.respond)
+	b.MaxBytes = 80
+	addDocs(b, "a", "b", "c")
+	b.Flush()
+	if len(fake.requests) != 3 || b.Indexed != 3 {
+		t.Errorf("%d requests, indexed %d, want 3, 3", len(fake.requests), b.Indexed)
+	}
+}
@@ -0,0 +1,93 @@
+package elasticsearch
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// NewClient creates a pooled http client which authenticates every request to
+// Elasticsearch and trusts the CA of the config, if any
+func NewClient(config *Config) (*http.Client, error) {
+	// Create connection pool to add performance
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = 100
+	t.MaxConnsPerHost = 100
+	t.MaxIdleConnsPerHost = 100
+	if config.CAFile != "" {
+		pem, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CAFile)
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	if config.APIKey != "" && config.Username != "" {
+		return nil, fmt.Errorf("use either an API key or a username, not both")
+	}
+	return &http.Client{
+		Timeout:   config.Timeout,
+		Transport: &authTransport{base: t, config: config},
+	}, nil
+}
+
+// authTransport adds the credentials of the config to each request
+type authTransport struct {
+	base   http.RoundTripper
+	config *Config
+}
+
+// RoundTrip ...
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.config.APIKey == "" && t.config.Username == "" {
+		return t.base.RoundTrip(req)
+	}
+	// A RoundTripper must not modify the request
+	req = req.Clone(req.Context())
+	if t.config.APIKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+encodeAPIKey(t.config.APIKey))
+	} else {
+		req.SetBasicAuth(t.config.Username, t.config.Password)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// encodeAPIKey accepts the encoded key as shown by Kibana or the "id:api_key" pair
+func encodeAPIKey(key string) string {
+	if strings.Contains(key, ":") {
+		return base64.StdEncoding.EncodeToString([]byte(key))
+	}
+	return key
+}
+
+// CloudURL returns the Elasticsearch URL of an Elastic Cloud ID
+// ("<name>:<base64 of host$elasticsearch id$kibana id>")
+func CloudURL(cloudID string) (string, error) {
+	encoded := cloudID
+	if i := strings.LastIndex(cloudID, ":"); i >= 0 {
+		encoded = cloudID[i+1:]
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid cloud ID: %v", err)
+	}
+	parts := strings.Split(string(decoded), "$")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("invalid cloud ID: missing host or Elasticsearch ID")
+	}
+	host, port := parts[0], "443"
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		host, port = host[:i], host[i+1:]
+	}
+	return fmt.Sprintf("https://%s.%s:%s", parts[1], host, port), nil
+}
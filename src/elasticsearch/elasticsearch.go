@@ -1,8 +1,11 @@
 package elasticsearch
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -12,6 +15,7 @@ import (
 	"sync"
 	"time"
 
+	"http-bomber/exporter"
 	"http-bomber/httptest"
 	"http-bomber/logging"
 )
@@ -23,13 +27,238 @@ type Config struct {
 	Export         bool
 	ExportToFile   bool
 	ExportFilePath string
+	// Credentials, either basic auth or an API key
+	Username string
+	Password string
+	APIKey   string
+	// CloudID replaces the URL with the one of an Elastic Cloud deployment
+	CloudID string
+	// CAFile is a PEM file of a CA to trust in addition to the system CAs
+	CAFile  string
+	Timeout time.Duration
+	// Flavor is one of auto, elasticsearch or opensearch
+	Flavor string
+	// Template installs an index template with explicit mappings
+	Template bool
+	// IndexMode is one of index, daily or datastream
+	IndexMode string
+	// ILMPolicy is the name of a lifecycle policy installed with the template (if set)
+	ILMPolicy       string
+	ILMRolloverAge  string
+	ILMRolloverSize string
+	ILMRetention    string
+	// Bulk batches are bounded by documents and bytes, rejected documents are retried
+	BulkDocs    int
+	BulkBytes   int
+	BulkRetries int
+	BulkBackoff time.Duration
+	// FlushInterval turns on indexing while the tests run, a bulk request is sent at
+	// this interval or when BulkDocs results are queued
+	FlushInterval time.Duration
+	// SpoolDir keeps documents which could not be sent, they are retried until the
+	// run has been over for SpoolDeadline
+	SpoolDir      string
+	SpoolDeadline time.Duration
+	// SummaryIndex gets a summary document per URL and run (if set)
+	SummaryIndex string
+	// GeoIPPipeline is the name of an ingest pipeline with a GeoIP lookup of the
+	// destination IP, installed and used for indexing (if set)
+	GeoIPPipeline string
 }
 
 // Module ...
 type Module struct {
+	Config    Config
 	WaitGroup *sync.WaitGroup
 	Logger    *logging.Logger
 	Debug     bool
+	client    *http.Client
+	dist      *Distribution
+	mu        sync.Mutex
+	errs      []string
+	indexed   int
+	dropped   int
+	spooled   int
+	// live export
+	live     *BulkIndexer
+	pending  []document
+	kick     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	setupErr error
+}
+
+func init() {
+	exporter.Register(&Module{})
+}
+
+// Name ...
+func (mod *Module) Name() string {
+	return "es"
+}
+
+// RegisterFlags ...
+func (mod *Module) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&mod.Config.URL, "elastic-url", "http://localhost:9200", "Elastic search URL")
+	fs.StringVar(&mod.Config.IndexName, "elastic-index", "testdata", "Elasticsearch index name")
+	fs.BoolVar(&mod.Config.Export, "elastic-export", false, "Export data to elasticsearch")
+	fs.BoolVar(&mod.Config.ExportToFile, "elastic-export-to-file", false, "Export data to file in elasticsearch format")
+	fs.StringVar(&mod.Config.ExportFilePath, "elastic-export-filepath", "/tmp/http-bomber-results.json", "Specify filepath for Elasticsearch export")
+	fs.StringVar(&mod.Config.Username, "elastic-username", "", "Elasticsearch username (basic auth)")
+	fs.StringVar(&mod.Config.Password, "elastic-password", "", "Elasticsearch password (basic auth)")
+	fs.StringVar(&mod.Config.APIKey, "elastic-api-key", "", "Elasticsearch API key, encoded or as <id>:<api_key>")
+	fs.StringVar(&mod.Config.CloudID, "elastic-cloud-id", "", "Elastic Cloud ID, used instead of -elastic-url")
+	fs.StringVar(&mod.Config.CAFile, "elastic-ca-file", "", "PEM file of a CA to trust for HTTPS connections to Elasticsearch")
+	fs.DurationVar(&mod.Config.Timeout, "elastic-timeout", 5*time.Second, "Timeout of Elasticsearch requests")
+	fs.StringVar(&mod.Config.Flavor, "elastic-flavor", FlavorAuto, "Distribution of the cluster, detected from its root endpoint by default <auto|elasticsearch|opensearch>")
+	fs.BoolVar(&mod.Config.Template, "elastic-template", true, "Install an index template with explicit mappings of all fields")
+	fs.StringVar(&mod.Config.IndexMode, "elastic-index-mode", ModeIndex, "Write into one index, daily indices (<index>-yyyy.MM.dd) or a data stream <index|daily|datastream>")
+	fs.StringVar(&mod.Config.ILMPolicy, "elastic-ilm-policy", "", "Install an ILM policy with this name and apply it to the index template")
+	fs.StringVar(&mod.Config.ILMRolloverAge, "elastic-ilm-rollover-age", "30d", "Roll a data stream over after this age")
+	fs.StringVar(&mod.Config.ILMRolloverSize, "elastic-ilm-rollover-size", "50gb", "Roll a data stream over when a primary shard reaches this size")
+	fs.StringVar(&mod.Config.ILMRetention, "elastic-ilm-retention", "", "Delete indices this long after rollover (or creation), e.g. 90d")
+	fs.IntVar(&mod.Config.BulkDocs, "elastic-bulk-docs", 1000, "Maximum number of documents per Elasticsearch bulk request")
+	fs.IntVar(&mod.Config.BulkBytes, "elastic-bulk-bytes", 5*1024*1024, "Maximum size of an Elasticsearch bulk request in bytes")
+	fs.IntVar(&mod.Config.BulkRetries, "elastic-bulk-retries", 3, "Retries of documents rejected by Elasticsearch")
+	fs.DurationVar(&mod.Config.BulkBackoff, "elastic-bulk-backoff", time.Second, "Wait before the first retry of rejected documents (doubled after each retry)")
+	fs.DurationVar(&mod.Config.FlushInterval, "elastic-flush-interval", 0, "Index results while the tests run, at this interval (0 indexes them after the run)")
+	fs.StringVar(&mod.Config.SpoolDir, "elastic-spool-dir", "", "Keep documents which could not be sent to Elasticsearch in this directory and retry them")
+	fs.StringVar(&mod.Config.SummaryIndex, "elastic-summary-index", "", "Index a summary document per URL and run into this index")
+	fs.StringVar(&mod.Config.GeoIPPipeline, "elastic-geoip-pipeline", "", "Install an ingest pipeline with this name which looks up the destination IP in the GeoIP database, and index through it")
+	fs.DurationVar(&mod.Config.SpoolDeadline, "elastic-spool-deadline", 5*time.Minute, "How long to retry spooled documents after the run")
+}
+
+// Enabled ...
+func (mod *Module) Enabled() bool {
+	return mod.Config.Export || mod.Config.ExportToFile
+}
+
+// Enable turns on exporting to Elasticsearch
+func (mod *Module) Enable() {
+	mod.Config.Export = true
+}
+
+// Export exports the results of a run as per module config
+func (mod *Module) Export(run *exporter.Run) error {
+	var err error
+	if mod.live != nil {
+		err = mod.finishLive()
+		if mod.Config.ExportToFile {
+			// the results are already indexed, only the file is left
+			config := mod.Config
+			config.Export = false
+			err = joinErrors(err, mod.Start(&config, run.Results))
+		}
+	} else {
+		if err := mod.validate(); err != nil {
+			return err
+		}
+		if err := mod.connect(&mod.Config); err != nil {
+			return err
+		}
+		var setupErr error
+		if mod.Config.Export {
+			// the results are indexed anyway, with dynamic mappings
+			if setupErr = mod.Setup(&mod.Config, run.FieldTypes); setupErr != nil {
+				mod.Logger.Error(fmt.Sprint("Elasticsearch setup failed: ", setupErr))
+			}
+		}
+		err = joinErrors(setupErr, mod.Start(&mod.Config, run.Results))
+	}
+	if mod.Config.Export && mod.Config.SummaryIndex != "" {
+		err = joinErrors(err, mod.ExportSummaries(&mod.Config, run))
+	}
+	return err
+}
+
+// validate checks the options which have a fixed set of values
+func (mod *Module) validate() error {
+	switch mod.Config.Flavor {
+	case FlavorAuto, FlavorElasticsearch, FlavorOpenSearch:
+	default:
+		return fmt.Errorf("invalid -elastic-flavor %s (use auto, elasticsearch or opensearch)", mod.Config.Flavor)
+	}
+	switch mod.Config.IndexMode {
+	case ModeIndex, ModeDaily, ModeDataStream:
+		return nil
+	default:
+		return fmt.Errorf("invalid -elastic-index-mode %s (use index, daily or datastream)", mod.Config.IndexMode)
+	}
+}
+
+// joinErrors returns the errors which are not nil, joined
+func joinErrors(errs ...error) error {
+	var msgs []string
+	for _, err := range errs {
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+// connect creates the client and resolves the URL of a cloud ID
+func (mod *Module) connect(config *Config) error {
+	if config.CloudID != "" {
+		url, err := CloudURL(config.CloudID)
+		if err != nil {
+			return err
+		}
+		config.URL = url
+	}
+	client, err := NewClient(config)
+	if err != nil {
+		return err
+	}
+	mod.client = client
+	return nil
+}
+
+// fieldMapping builds a mapping from dotted field paths and data types
+func fieldMapping(fieldTypes map[string]string) map[string]interface{} {
+	root := make(map[string]interface{})
+	addFields(root, fieldTypes)
+	return map[string]interface{}{"properties": root}
+}
+
+// newBulkIndexer creates a bulk indexer with the bulk options of the config
+func (mod *Module) newBulkIndexer(config *Config) *BulkIndexer {
+	bulk := &BulkIndexer{
+		Client:   mod.client,
+		URL:      config.URL,
+		Action:   config.bulkAction(),
+		Pipeline: config.GeoIPPipeline,
+		MaxDocs:  config.BulkDocs,
+		MaxBytes: config.BulkBytes,
+		Retries:  config.BulkRetries,
+		Backoff:  config.BulkBackoff,
+		Logger:   mod.Logger,
+		Debug:    mod.Debug,
+	}
+	if config.SpoolDir != "" {
+		spool := &Spool{Dir: config.SpoolDir}
+		bulk.Spool = spool.Write
+	}
+	return bulk
+}
+
+// logCounts logs what happened to the documents of a run
+func (mod *Module) logCounts(config *Config, indexed int, dropped int, spooled int) {
+	msg := fmt.Sprintf("Indexed %d documents into %s, dropped %d", indexed, config.IndexName, dropped)
+	if config.SpoolDir != "" {
+		msg += fmt.Sprintf(", spooled %d", spooled)
+	}
+	mod.Logger.Info(msg)
+}
+
+// record an export error, safe to call from goroutines
+func (mod *Module) fail(msg string) {
+	mod.mu.Lock()
+	defer mod.mu.Unlock()
+	mod.errs = append(mod.errs, msg)
 }
 
 // Init ...
@@ -39,12 +268,17 @@ func (mod *Module) Init(wg *sync.WaitGroup, logger *logging.Logger, debug bool)
 	mod.Debug = debug
 }
 
-// Start ...
-func (mod *Module) Start(config *Config, results [][]*httptest.Result) {
+// Start exports the resultsets, one goroutine per url/endpoint
+func (mod *Module) Start(config *Config, results [][]*httptest.Result) error {
+	mod.errs = nil
+	mod.indexed, mod.dropped, mod.spooled = 0, 0, 0
 	if config.Export || config.ExportToFile {
 		mod.Logger.Info("Starting Elastic Exporter")
 		// Start goroutines for each url/endpoint
 		for i := 0; i < len(results); i++ {
+			if len(results[i]) == 0 {
+				continue
+			}
 			if mod.Debug {
 				mod.Logger.Debug(fmt.Sprintf("Exporting data for url %s", results[i][0].URL))
 			}
@@ -52,81 +286,83 @@ func (mod *Module) Start(config *Config, results [][]*httptest.Result) {
 			go mod.ExportData(config, results[i])
 		}
 		mod.WaitGroup.Wait()
+		if config.Export {
+			mod.logCounts(config, mod.indexed, mod.dropped, mod.spooled)
+			if config.SpoolDir != "" {
+				if err := mod.flushSpool(config, time.Now().Add(config.SpoolDeadline)); err != nil {
+					mod.fail(err.Error())
+				}
+			}
+		}
 		mod.Logger.Info("Exporting complete")
 	}
+	if len(mod.errs) > 0 {
+		return errors.New(strings.Join(mod.errs, "; "))
+	}
+	return nil
 }
 
 // ExportData exports data to either elasticsearch or file or both
 func (mod *Module) ExportData(config *Config, resultSet []*httptest.Result) {
+	// GOroutine done
+	defer mod.WaitGroup.Done()
 
-	var requestData string
+	var bulk *BulkIndexer
+	if config.Export {
+		bulk = mod.newBulkIndexer(config)
+	}
+	var file *bufio.Writer
+	if config.ExportToFile {
+		randomFileName := fmt.Sprintf("%s-%v", config.ExportFilePath, rand.Int())
+		resultFile, err := os.OpenFile(randomFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			mod.Logger.Info("Cannot write output to a file.")
+			mod.fail(fmt.Sprint("cannot write output to a file: ", err))
+		} else {
+			defer resultFile.Close()
+			file = bufio.NewWriter(resultFile)
+		}
+	}
 
-	// Make data and write to file
 	for _, v := range resultSet {
 		data, err := json.Marshal(v)
 		if err != nil {
 			if mod.Debug {
 				mod.Logger.Debug("Failed to process JSON")
 			}
+			mod.fail(fmt.Sprint("failed to process JSON: ", err))
 			return
 		}
-
-		requestData += fmt.Sprintf("{ \"index\" : { \"_index\" : \"%s\" } }\n", config.IndexName)
-		requestData += string(data)
-		requestData += "\n"
-	}
-
-	if config.Export {
-		// Create connection pool to add performance
-		t := http.DefaultTransport.(*http.Transport).Clone()
-		t.MaxIdleConns = 100
-		t.MaxConnsPerHost = 100
-		t.MaxIdleConnsPerHost = 100
-		// create new http client (TODO: Make timeout configurable)
-		client := http.Client{
-			Timeout:   5 * time.Second,
-			Transport: t,
-		}
-		// Make a request to elasticsearch
-		resp, err := client.Post(fmt.Sprintf("%s/_bulk?pretty", config.URL), "application/x-ndjson", bytes.NewBuffer([]byte(requestData)))
-		if err != nil {
-			// Log error and append to failed requests, move on to the next row
-			if mod.Debug {
-				mod.Logger.Debug(fmt.Sprint("Failed to send request to Elasticsearch: ", err))
-			}
-			// Take a little rest since we had an error
-			time.Sleep(1 * time.Second)
+		if bulk != nil {
+			bulk.Add(config.Index(v), data)
 		}
-
-		// Close response body
-		defer resp.Body.Close()
-		if strings.HasPrefix(resp.Status, "20") == false {
-			if mod.Debug {
-				mod.Logger.Debug(fmt.Sprintf("Failed to send data to Elasticsearch (Status: %s)", resp.Status))
-			}
+		if file != nil {
+			index, _ := json.Marshal(config.Index(v))
+			fmt.Fprintf(file, "{ \"%s\" : { \"_index\" : %s } }\n%s\n", config.bulkAction(), index, data)
 		}
 	}
 
-	if config.ExportToFile {
-		randomFileName := fmt.Sprintf("%s-%v", config.ExportFilePath, rand.Int())
-		resultFile, err := os.OpenFile(randomFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
-		if err != nil {
-			mod.Logger.Info("Cannot write output to a file.")
+	if bulk != nil {
+		bulk.Flush()
+		mod.mu.Lock()
+		mod.indexed += bulk.Indexed
+		mod.dropped += bulk.Dropped
+		mod.spooled += bulk.Spooled
+		mod.mu.Unlock()
+		if bulk.Dropped > 0 {
+			mod.fail(fmt.Sprintf("dropped %d of %d documents of %s (last error: %s)",
+				bulk.Dropped, len(resultSet), resultSet[0].URL, bulk.LastError))
+		}
+	}
+	if file != nil {
+		if err := file.Flush(); err != nil {
+			mod.fail(fmt.Sprint("cannot write output to a file: ", err))
 		}
-		defer resultFile.Close()
-		resultFile.WriteString(requestData)
 	}
-
-	// GOroutine done
-	mod.WaitGroup.Done()
 }
 
 // CreateIndex creates an empty index to elasticsearch
 func (mod *Module) CreateIndex(config *Config) {
-	// create new http client (TODO: Make timeout configurable)
-	client := http.Client{
-		Timeout: 5 * time.Second,
-	}
 	indexURL := fmt.Sprintf("%s/%s", config.URL, config.IndexName)
 	req, err := http.NewRequest("PUT", indexURL, nil)
 	if err != nil {
@@ -135,7 +371,7 @@ func (mod *Module) CreateIndex(config *Config) {
 		}
 		return
 	}
-	resp, err := client.Do(req)
+	resp, err := mod.client.Do(req)
 	if err != nil {
 		if mod.Debug {
 			mod.Logger.Debug(fmt.Sprint("Failed to create index:", err))
@@ -159,11 +395,6 @@ func (mod *Module) CreateIndex(config *Config) {
 
 // CreateIndexWithMapping creates an empty index with mapping
 func (mod *Module) CreateIndexWithMapping(config *Config, mapping *string) {
-
-	// create new http client (TODO: Make timeout configurable)
-	client := http.Client{
-		Timeout: 5 * time.Second,
-	}
 	mappingEndpointURL := fmt.Sprintf("%s/%s/_mapping", config.URL, config.IndexName)
 	mappingBytes := []byte(*mapping)
 	req, err := http.NewRequest("PUT", mappingEndpointURL, bytes.NewBuffer([]byte(mappingBytes)))
@@ -174,7 +405,7 @@ func (mod *Module) CreateIndexWithMapping(config *Config, mapping *string) {
 		return
 	}
 	req.Header.Add("Content-Type", "application/json")
-	resp, err := client.Do(req)
+	resp, err := mod.client.Do(req)
 	if err != nil {
 		if mod.Debug {
 			mod.Logger.Debug(fmt.Sprint("Failed to create mapping:", err))
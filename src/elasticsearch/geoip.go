@@ -0,0 +1,72 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// GeoIPLocation is the field of the geo point looked up by the pipeline, the one the
+// ipstack module fills, so that the same maps work with either
+const GeoIPLocation = "modules.ipstack.LatitudeLongitude"
+
+// GeoIPPipeline returns an ingest pipeline which looks up the destination IP in the GeoIP
+// database of the cluster. The location is copied to GeoIPLocation unless ipstack has
+// already set it. Results without enricher data have "modules": null, which the geoip
+// processor can't add its target field to, so it is replaced by an empty object first.
+// The pipeline has no _meta, which older versions reject.
+func GeoIPPipeline() map[string]interface{} {
+	return map[string]interface{}{
+		"description": "GeoIP lookup of the destination IP, created by http-bomber",
+		"processors": []interface{}{
+			map[string]interface{}{
+				"script": map[string]interface{}{
+					"if":     "ctx.modules == null",
+					"source": "ctx.modules = [:]",
+				},
+			},
+			map[string]interface{}{
+				"geoip": map[string]interface{}{
+					"field":          "destination_ip",
+					"target_field":   "modules.geoip",
+					"properties":     []string{"continent_name", "country_iso_code", "country_name", "region_name", "city_name", "location"},
+					"ignore_missing": true,
+					// results without a connection have an empty destination IP
+					"ignore_failure": true,
+				},
+			},
+			map[string]interface{}{
+				"set": map[string]interface{}{
+					"if":       "ctx.modules?.geoip?.location != null",
+					"field":    GeoIPLocation,
+					"value":    "{{modules.geoip.location.lat}},{{modules.geoip.location.lon}}",
+					"override": false,
+				},
+			},
+		},
+	}
+}
+
+// geoIPFieldTypes adds the geo points of the pipeline to the field types of the enrichers
+func geoIPFieldTypes(fieldTypes map[string]string) map[string]string {
+	types := map[string]string{
+		"modules.geoip.location": "geo_point",
+		GeoIPLocation:            "geo_point",
+	}
+	for field, dataType := range fieldTypes {
+		types[field] = dataType
+	}
+	return types
+}
+
+// putGeoIPPipeline installs the GeoIP pipeline. If that fails, the results are indexed
+// without it, as documents sent through a missing pipeline are rejected.
+func (mod *Module) putGeoIPPipeline(config *Config) error {
+	if err := mod.put(config, "/_ingest/pipeline/"+url.PathEscape(config.GeoIPPipeline), GeoIPPipeline()); err != nil {
+		config.GeoIPPipeline = ""
+		return fmt.Errorf("failed to install ingest pipeline, indexing without it: %v", err)
+	}
+	if mod.Debug {
+		mod.Logger.Debug(fmt.Sprintf("Installed ingest pipeline %s", config.GeoIPPipeline))
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"http-bomber/httptest"
+)
+
+// setField sets a dotted field path as an ingest processor does: missing objects on the
+// path are added, but a null parent fails
+func setField(doc map[string]interface{}, path string, value interface{}) error {
+	parts := strings.Split(path, ".")
+	node := doc
+	for _, part := range parts[:len(parts)-1] {
+		child, found := node[part]
+		if !found {
+			child = make(map[string]interface{})
+			node[part] = child
+		}
+		if child == nil {
+			return fmt.Errorf("cannot set [%s] with null parent", parts[len(parts)-1])
+		}
+		node = child.(map[string]interface{})
+	}
+	node[parts[len(parts)-1]] = value
+	return nil
+}
+
+// ingest runs the processors of the GeoIP pipeline on a document, with a lookup of
+// every destination IP to the same location. Only the scripts and conditions of the
+// pipeline are understood.
+func ingest(t *testing.T, pipeline map[string]interface{}, doc map[string]interface{}) error {
+	for _, p := range pipeline["processors"].([]interface{}) {
+		for kind, options := range p.(map[string]interface{}) {
+			options := options.(map[string]interface{})
+			switch kind {
+			case "script":
+				if options["if"] != "ctx.modules == null" || options["source"] != "ctx.modules = [:]" {
+					t.Fatalf("unknown script %v", options)
+				}
+				if modules, found := doc["modules"]; found && modules == nil {
+					doc["modules"] = make(map[string]interface{})
+				}
+			case "geoip":
+				location := map[string]interface{}{"lat": 48.2, "lon": 16.37}
+				if err := setField(doc, options["target_field"].(string), map[string]interface{}{"location": location}); err != nil {
+					return fmt.Errorf("geoip: %v", err)
+				}
+			case "set":
+				if options["if"] != "ctx.modules?.geoip?.location != null" || options["override"] != false {
+					t.Fatalf("unknown set processor %v", options)
+				}
+				modules, _ := doc["modules"].(map[string]interface{})
+				geoip, _ := modules["geoip"].(map[string]interface{})
+				if geoip["location"] == nil {
+					continue
+				}
+				if ipstack, _ := modules["ipstack"].(map[string]interface{}); ipstack != nil && ipstack["LatitudeLongitude"] != nil {
+					continue
+				}
+				if err := setField(doc, options["field"].(string), "48.2,16.37"); err != nil {
+					return fmt.Errorf("set: %v", err)
+				}
+			default:
+				t.Fatalf("unknown processor %s", kind)
+			}
+		}
+	}
+	return nil
+}
+
+func TestGeoIPPipelineNullModules(t *testing.T) {
+	// results are indexed as they are encoded, without enrichers with "modules": null
+	data, err := json.Marshal(&httptest.Result{URL: "http://a", DestinationIP: "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"modules":null`) {
+		t.Fatalf("result encoded without a null modules field: %s", data)
+	}
+
+	tests := []struct {
+		name     string
+		doc      string
+		location string
+	}{
+		{"null modules", string(data), "48.2,16.37"},
+		{"without modules", `{"destination_ip":"192.0.2.1"}`, "48.2,16.37"},
+		{"ipstack location kept", `{"destination_ip":"192.0.2.1","modules":{"ipstack":{"LatitudeLongitude":"1,2"}}}`, "1,2"},
+	}
+	for _, tt := range tests {
+		var doc map[string]interface{}
+		if err := json.Unmarshal([]byte(tt.doc), &doc); err != nil {
+			t.Fatal(err)
+		}
+		if err := ingest(t, GeoIPPipeline(), doc); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		modules, _ := doc["modules"].(map[string]interface{})
+		if geoip, _ := modules["geoip"].(map[string]interface{}); geoip["location"] == nil {
+			t.Errorf("%s: no GeoIP location in %v", tt.name, doc)
+		}
+		if ipstack, _ := modules["ipstack"].(map[string]interface{}); ipstack["LatitudeLongitude"] != tt.location {
+			t.Errorf("%s: location %v, want %s", tt.name, ipstack["LatitudeLongitude"], tt.location)
+		}
+	}
+}
+
+func TestGeoIPPipelineWithoutScript(t *testing.T) {
+	// the geoip processor alone fails on null modules
+	pipeline := GeoIPPipeline()
+	pipeline["processors"] = pipeline["processors"].([]interface{})[1:]
+	doc := map[string]interface{}{"destination_ip": "192.0.2.1", "modules": nil}
+	if err := ingest(t, pipeline, doc); err == nil || !strings.Contains(err.Error(), "null parent") {
+		t.Errorf("error %v, want a null parent", err)
+	}
+}
@@ -0,0 +1,159 @@
+package elasticsearch
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"http-bomber/httptest"
+)
+
+// countingReader counts the bytes read, for showing progress
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Ingest sends bulk files (optionally gzip compressed), e.g. written by -elastic-export-to-file,
+// to Elasticsearch. With an index given, the documents are written into that index (or
+// data stream, as per the index mode) instead of the one in the files. Progress is logged
+// at the progress interval.
+func (mod *Module) Ingest(paths []string, index string, progress time.Duration) error {
+	if err := mod.validate(); err != nil {
+		return err
+	}
+	config := mod.Config
+	if err := mod.connect(&config); err != nil {
+		return err
+	}
+	var setupErr error
+	if index != "" {
+		config.IndexName = index
+		if setupErr = mod.Setup(&config, nil); setupErr != nil {
+			mod.Logger.Error(fmt.Sprint("Elasticsearch setup failed: ", setupErr))
+		}
+	} else if config.GeoIPPipeline != "" {
+		if setupErr = mod.putGeoIPPipeline(&config); setupErr != nil {
+			mod.Logger.Error(fmt.Sprint("Elasticsearch setup failed: ", setupErr))
+		}
+	}
+
+	bulk := mod.newBulkIndexer(&config)
+	for _, path := range paths {
+		if err := mod.ingestFile(&config, bulk, path, index != "", progress); err != nil {
+			return joinErrors(setupErr, fmt.Errorf("%s: %v", path, err))
+		}
+	}
+	bulk.Flush()
+	mod.logCounts(&config, bulk.Indexed, bulk.Dropped, bulk.Spooled)
+
+	var errs []error
+	if config.SpoolDir != "" {
+		errs = append(errs, mod.flushSpool(&config, time.Now().Add(config.SpoolDeadline)))
+	}
+	if bulk.Dropped > 0 {
+		errs = append(errs, fmt.Errorf("dropped %d of %d documents (last error: %s)",
+			bulk.Dropped, bulk.Indexed+bulk.Dropped+bulk.Spooled, bulk.LastError))
+	}
+	return joinErrors(append([]error{setupErr}, errs...)...)
+}
+
+// ingestFile queues the items of a file
+func (mod *Module) ingestFile(config *Config, bulk *BulkIndexer, path string, retarget bool, progress time.Duration) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	counter := &countingReader{r: f}
+	reader := bufio.NewReader(counter)
+	var r io.Reader = reader
+	if magic, err := reader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	docs := 0
+	report := func() {
+		percent := 100.0
+		if info.Size() > 0 {
+			percent = float64(counter.n) / float64(info.Size()) * 100
+		}
+		mod.Logger.Info(fmt.Sprintf("%s: %d documents (%.0f%%), %d indexed, %d dropped in total",
+			path, docs, percent, bulk.Indexed, bulk.Dropped))
+	}
+	last := time.Now()
+	err = ReadItems(r, func(item []byte) error {
+		if retarget {
+			var err error
+			if item, err = config.retarget(item); err != nil {
+				return err
+			}
+		}
+		bulk.add(item)
+		docs++
+		if progress > 0 && time.Since(last) >= progress {
+			report()
+			last = time.Now()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	report()
+	return nil
+}
+
+// retarget rewrites the action of a bulk item for the index of the config
+func (config *Config) retarget(item []byte) ([]byte, error) {
+	i := bytes.IndexByte(item, '\n')
+	action, doc := item[:i], item[i+1:]
+	var parsed map[string]map[string]interface{}
+	if err := json.Unmarshal(action, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid bulk action %s", truncate(string(action), 100))
+	}
+	for name, meta := range parsed {
+		if name == "delete" {
+			meta["_index"] = config.IndexName
+			break
+		}
+		if meta == nil {
+			meta = make(map[string]interface{})
+		}
+		// daily indices are named after the date of the result
+		var result httptest.Result
+		if config.IndexMode == ModeDaily {
+			if err := json.Unmarshal(doc, &result); err != nil {
+				return nil, fmt.Errorf("invalid document %s", truncate(string(doc), 100))
+			}
+		}
+		meta["_index"] = config.Index(&result)
+		parsed = map[string]map[string]interface{}{config.bulkAction(): meta}
+		break
+	}
+	line, err := json.Marshal(parsed)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(line, '\n'), doc...), nil
+}
@@ -0,0 +1,124 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"http-bomber/exporter"
+	"http-bomber/httptest"
+)
+
+// document is a result waiting to be indexed
+type document struct {
+	index string
+	data  []byte
+}
+
+// Begin starts indexing results while the tests run, if a flush interval is set
+func (mod *Module) Begin(run *exporter.Run) error {
+	if !mod.Config.Export || mod.Config.FlushInterval <= 0 {
+		return nil
+	}
+	if err := mod.validate(); err != nil {
+		return err
+	}
+	if err := mod.connect(&mod.Config); err != nil {
+		return err
+	}
+	// the results are indexed anyway, with dynamic mappings
+	if err := mod.Setup(&mod.Config, run.FieldTypes); err != nil {
+		mod.Logger.Error(fmt.Sprint("Elasticsearch setup failed: ", err))
+		mod.setupErr = err
+	}
+	mod.Logger.Info(fmt.Sprintf("Indexing results into %s every %s", mod.Config.IndexName, mod.Config.FlushInterval))
+
+	mod.live = mod.newBulkIndexer(&mod.Config)
+	mod.kick = make(chan struct{}, 1)
+	mod.stop = make(chan struct{})
+	mod.done = make(chan struct{})
+	go func() {
+		defer close(mod.done)
+		ticker := time.NewTicker(mod.Config.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				mod.flushLive()
+			case <-mod.kick:
+				mod.flushLive()
+			case <-mod.stop:
+				mod.flushLive()
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// RequestStarted ...
+func (mod *Module) RequestStarted(url string) {}
+
+// RequestDone queues a result for the next flush. The result is encoded right away,
+// so that it doesn't change (e.g. by enrichers) before it is indexed.
+func (mod *Module) RequestDone(url string, result *httptest.Result) {
+	if mod.live == nil || result == nil {
+		return
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		mod.fail(fmt.Sprint("failed to process JSON: ", err))
+		return
+	}
+	mod.mu.Lock()
+	mod.pending = append(mod.pending, document{mod.Config.Index(result), data})
+	full := len(mod.pending) >= mod.Config.BulkDocs
+	mod.mu.Unlock()
+	if full {
+		select {
+		case mod.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// flushLive indexes the queued results. Only called by the flush goroutine, so the
+// tests never wait for Elasticsearch.
+func (mod *Module) flushLive() {
+	mod.mu.Lock()
+	pending := mod.pending
+	mod.pending = nil
+	mod.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	for _, doc := range pending {
+		mod.live.Add(doc.index, doc.data)
+	}
+	mod.live.Flush()
+	if mod.Debug {
+		mod.Logger.Debug(fmt.Sprintf("Flushed %d documents (%d indexed, %d dropped so far)", len(pending), mod.live.Indexed, mod.live.Dropped))
+	}
+}
+
+// finishLive stops the flush goroutine after a final flush and reports the counts
+func (mod *Module) finishLive() error {
+	close(mod.stop)
+	<-mod.done
+	mod.logCounts(&mod.Config, mod.live.Indexed, mod.live.Dropped, mod.live.Spooled)
+	var errs []error
+	if mod.Config.SpoolDir != "" {
+		errs = append(errs, mod.flushSpool(&mod.Config, time.Now().Add(mod.Config.SpoolDeadline)))
+	}
+	mod.mu.Lock()
+	for _, msg := range mod.errs {
+		errs = append(errs, errors.New(msg))
+	}
+	mod.mu.Unlock()
+	if mod.live.Dropped > 0 {
+		errs = append(errs, fmt.Errorf("dropped %d of %d documents (last error: %s)",
+			mod.live.Dropped, mod.live.Indexed+mod.live.Dropped, mod.live.LastError))
+	}
+	return joinErrors(append([]error{mod.setupErr}, errs...)...)
+}
@@ -0,0 +1,223 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Flavors of the cluster, auto detects the flavor from the root endpoint
+const (
+	FlavorAuto          = "auto"
+	FlavorElasticsearch = "elasticsearch"
+	FlavorOpenSearch    = "opensearch"
+)
+
+// Distribution is the flavor and version of the cluster. OpenSearch (a fork of
+// Elasticsearch 7.10) has ISM instead of ILM and no flattened field type.
+type Distribution struct {
+	Flavor  string
+	Version [3]int
+}
+
+// OpenSearch ...
+func (d Distribution) OpenSearch() bool {
+	return d.Flavor == FlavorOpenSearch
+}
+
+// atLeast tells if the version is the given one or newer
+func (d Distribution) atLeast(major, minor int) bool {
+	return d.Version[0] > major || d.Version[0] == major && d.Version[1] >= minor
+}
+
+// flattened is the mapping of objects with arbitrary keys (e.g. headers), which should
+// not get a field per key. OpenSearch 2.7 added flat_object, older versions only keep
+// the object in the source.
+func (d Distribution) flattened() map[string]interface{} {
+	switch {
+	case !d.OpenSearch():
+		return map[string]interface{}{"type": "flattened"}
+	case d.atLeast(2, 7):
+		return map[string]interface{}{"type": "flat_object"}
+	default:
+		return map[string]interface{}{"type": "object", "enabled": false}
+	}
+}
+
+// parseVersion parses a version number such as 8.12.0 or 2.11.0-SNAPSHOT
+func parseVersion(s string) ([3]int, error) {
+	var v [3]int
+	parts := strings.Split(strings.SplitN(s, "-", 2)[0], ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// distribution returns the flavor and version of the cluster, from its root endpoint.
+// If the root endpoint can't be read (e.g. for lack of the monitor privilege), the
+// version is left unknown and the flavor is the configured one, or Elasticsearch.
+func (mod *Module) distribution(config *Config) (Distribution, error) {
+	if mod.dist != nil {
+		return *mod.dist, nil
+	}
+	dist, err := mod.rootDistribution(config)
+	if err != nil {
+		if config.Flavor == FlavorAuto {
+			mod.Logger.Warning(fmt.Sprintf("Failed to detect the distribution of %s, assuming Elasticsearch (set -elastic-flavor): %v", config.URL, err))
+		} else if mod.Debug {
+			mod.Logger.Debug(fmt.Sprint("Failed to read the version of the cluster: ", err))
+		}
+		dist = Distribution{Flavor: FlavorElasticsearch}
+	}
+	if config.Flavor != FlavorAuto {
+		dist.Flavor = config.Flavor
+	}
+	if dist.OpenSearch() && (config.APIKey != "" || config.CloudID != "") {
+		return Distribution{}, fmt.Errorf("OpenSearch supports neither API keys nor cloud IDs, use basic auth")
+	}
+	if mod.Debug {
+		mod.Logger.Debug(fmt.Sprintf("Cluster is %s %d.%d.%d", dist.Flavor, dist.Version[0], dist.Version[1], dist.Version[2]))
+	}
+	mod.dist = &dist
+	return dist, nil
+}
+
+func (mod *Module) rootDistribution(config *Config) (Distribution, error) {
+	req, err := http.NewRequest("GET", config.URL+"/", nil)
+	if err != nil {
+		return Distribution{}, err
+	}
+	resp, err := mod.client.Do(req)
+	if err != nil {
+		return Distribution{}, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Distribution{}, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return Distribution{}, fmt.Errorf("status %s: %s", resp.Status, truncate(string(body), 300))
+	}
+	var root struct {
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+	if err := json.Unmarshal(body, &root); err != nil {
+		return Distribution{}, fmt.Errorf("invalid response: %v", err)
+	}
+	// Elasticsearch doesn't name its distribution
+	dist := Distribution{Flavor: FlavorElasticsearch}
+	if root.Version.Distribution == FlavorOpenSearch {
+		dist.Flavor = FlavorOpenSearch
+	}
+	dist.Version, err = parseVersion(root.Version.Number)
+	return dist, err
+}
+
+// StateManagementPolicy returns an ISM policy (the OpenSearch counterpart of ILM) with
+// the same rollover and retention as LifecyclePolicy. It is applied to new indices of
+// the template by its ISM template.
+func StateManagementPolicy(config *Config, dist Distribution) (map[string]interface{}, error) {
+	var states []interface{}
+	hot := map[string]interface{}{
+		"name":        "hot",
+		"actions":     []interface{}{},
+		"transitions": []interface{}{},
+	}
+	states = append(states, hot)
+	rollover := make(map[string]interface{})
+	if config.ILMRolloverAge != "" {
+		rollover["min_index_age"] = config.ILMRolloverAge
+	}
+	if config.ILMRolloverSize != "" {
+		if dist.atLeast(2, 4) {
+			rollover["min_primary_shard_size"] = config.ILMRolloverSize
+		} else {
+			rollover["min_size"] = config.ILMRolloverSize
+		}
+	}
+	// only data streams can roll over, indices of the other modes are deleted as a whole
+	if config.IndexMode == ModeDataStream && len(rollover) > 0 {
+		hot["actions"] = []interface{}{map[string]interface{}{"rollover": rollover}}
+	}
+	if config.ILMRetention != "" {
+		age := "min_index_age"
+		if config.IndexMode == ModeDataStream && len(rollover) > 0 {
+			age = "min_rollover_age"
+		}
+		hot["transitions"] = []interface{}{map[string]interface{}{
+			"state_name": "delete",
+			"conditions": map[string]interface{}{age: config.ILMRetention},
+		}}
+		states = append(states, map[string]interface{}{
+			"name":        "delete",
+			"actions":     []interface{}{map[string]interface{}{"delete": map[string]interface{}{}}},
+			"transitions": []interface{}{},
+		})
+	}
+	if len(states) == 1 && len(hot["actions"].([]interface{})) == 0 {
+		return nil, fmt.Errorf("ISM policy %s has neither a rollover nor a retention", config.ILMPolicy)
+	}
+	pattern := config.IndexName
+	if config.IndexMode == ModeDaily {
+		pattern += "-*"
+	}
+	return map[string]interface{}{
+		"policy": map[string]interface{}{
+			"description":   "Created by http-bomber",
+			"default_state": "hot",
+			"states":        states,
+			"ism_template": []interface{}{map[string]interface{}{
+				"index_patterns": []string{pattern},
+				"priority":       200,
+			}},
+		},
+	}, nil
+}
+
+// putStateManagementPolicy creates or updates an ISM policy. Updates need the sequence
+// number and primary term of the current policy.
+func (mod *Module) putStateManagementPolicy(config *Config, policy map[string]interface{}) error {
+	path := "/_plugins/_ism/policies/" + url.PathEscape(config.ILMPolicy)
+	req, err := http.NewRequest("GET", config.URL+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := mod.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusOK {
+		var current struct {
+			SeqNo       int64 `json:"_seq_no"`
+			PrimaryTerm int64 `json:"_primary_term"`
+		}
+		if err := json.Unmarshal(body, &current); err != nil {
+			return fmt.Errorf("invalid ISM policy response: %v", err)
+		}
+		path += fmt.Sprintf("?if_seq_no=%d&if_primary_term=%d", current.SeqNo, current.PrimaryTerm)
+	} else if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("status %s: %s", resp.Status, truncate(string(body), 300))
+	}
+	return mod.put(config, path, policy)
+}
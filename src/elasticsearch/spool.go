@@ -0,0 +1,196 @@
+package elasticsearch
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Spool keeps bulk items which could not be sent in files of a directory, in the
+// bulk format. Each file is a batch which is removed once it has been sent.
+type Spool struct {
+	Dir string
+}
+
+// longest wait between two attempts of sending the spool
+const maxSpoolBackoff = time.Minute
+
+// Write stores items in a new spool file. The file is renamed into place when it is
+// complete, so that a crash never leaves half a batch behind.
+func (s *Spool) Write(items [][]byte) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.ndjson", time.Now().UnixNano(), hex.EncodeToString(suffix))
+	return writeFile(filepath.Join(s.Dir, name), items)
+}
+
+// Files returns the spool files, oldest first
+func (s *Spool) Files() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(s.Dir, "*.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func writeFile(path string, items [][]byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, item := range items {
+		w.Write(item)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ReadItems reads bulk items (an action line and, except for deletes, a document
+// line) and passes each one to fn
+func ReadItems(r io.Reader, fn func(item []byte) error) error {
+	reader := bufio.NewReader(r)
+	for {
+		action, err := readLine(reader)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(action)) == 0 {
+			continue
+		}
+		var parsed map[string]json.RawMessage
+		if err := json.Unmarshal(action, &parsed); err != nil || len(parsed) != 1 {
+			return fmt.Errorf("invalid bulk action %s", truncate(string(action), 100))
+		}
+		item := action
+		if _, isDelete := parsed["delete"]; !isDelete {
+			doc, err := readLine(reader)
+			if err == io.EOF {
+				return fmt.Errorf("bulk action without document %s", truncate(string(action), 100))
+			} else if err != nil {
+				return err
+			}
+			item = append(item, doc...)
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+}
+
+// readLine reads a line including the newline, which is added to the last line if missing
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	line, err := reader.ReadBytes('\n')
+	if err == io.EOF && len(line) > 0 {
+		return append(line, '\n'), nil
+	}
+	return line, err
+}
+
+// FlushSpool sends the spooled documents, retrying with backoff until the spool
+// deadline. Documents which still can't be sent are left in the spool.
+func (mod *Module) FlushSpool() error {
+	if err := mod.connect(&mod.Config); err != nil {
+		return err
+	}
+	return mod.flushSpool(&mod.Config, time.Now().Add(mod.Config.SpoolDeadline))
+}
+
+func (mod *Module) flushSpool(config *Config, deadline time.Time) error {
+	spool := &Spool{Dir: config.SpoolDir}
+	backoff := config.BulkBackoff
+	sent, dropped := 0, 0
+	for {
+		indexed, failed, left, err := mod.sendSpool(config, spool)
+		sent += indexed
+		dropped += failed
+		if err != nil {
+			return err
+		}
+		if left == 0 {
+			if sent > 0 || dropped > 0 {
+				mod.Logger.Info(fmt.Sprintf("Indexed %d spooled documents, dropped %d", sent, dropped))
+			}
+			if dropped > 0 {
+				return fmt.Errorf("dropped %d spooled documents", dropped)
+			}
+			return nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("%d documents left in the spool %s (indexed %d, dropped %d), send them with http-bomber flush-spool",
+				left, config.SpoolDir, sent, dropped)
+		}
+		mod.Logger.Info(fmt.Sprintf("%d documents left in the spool, retrying in %s", left, backoff))
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxSpoolBackoff {
+			backoff = maxSpoolBackoff
+		}
+	}
+}
+
+// sendSpool makes one attempt of sending each spool file. Sent files are removed,
+// the items which failed again are written back.
+func (mod *Module) sendSpool(config *Config, spool *Spool) (indexed int, dropped int, left int, err error) {
+	files, err := spool.Files()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	for _, path := range files {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return indexed, dropped, left, err
+		}
+		var rest [][]byte
+		bulk := mod.newBulkIndexer(config)
+		bulk.Retries = 0
+		bulk.Spool = func(items [][]byte) error {
+			rest = append(rest, items...)
+			return nil
+		}
+		if err := ReadItems(bytes.NewReader(data), func(item []byte) error {
+			bulk.add(item)
+			return nil
+		}); err != nil {
+			return indexed, dropped, left, fmt.Errorf("%s: %v", path, err)
+		}
+		bulk.Flush()
+		indexed += bulk.Indexed
+		dropped += bulk.Dropped
+		left += len(rest)
+		if len(rest) == 0 {
+			err = os.Remove(path)
+		} else {
+			err = writeFile(path, rest)
+		}
+		if err != nil {
+			return indexed, dropped, left, err
+		}
+	}
+	return indexed, dropped, left, nil
+}
@@ -0,0 +1,218 @@
+package elasticsearch
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"http-bomber/exporter"
+	"http-bomber/stats"
+	"http-bomber/thresholds"
+)
+
+// RunSummary is the summary document of one URL of a run
+type RunSummary struct {
+	Timestamp   time.Time          `json:"@timestamp"`
+	RunID       string             `json:"run_id"`
+	Version     string             `json:"version"`
+	URL         string             `json:"url"`
+	Start       time.Time          `json:"start"`
+	End         time.Time          `json:"end"`
+	Duration    time.Duration      `json:"duration"`
+	Requests    int                `json:"requests"`
+	Errors      int                `json:"errors"`
+	ErrorRate   float64            `json:"error_rate"`
+	RPS         float64            `json:"rps"`
+	StatusCodes map[string]int     `json:"status_codes"`
+	ErrorKinds  map[string]int     `json:"error_kinds"`
+	Latency     stats.Latency      `json:"latency"`
+	Passed      bool               `json:"passed"`
+	Thresholds  []ThresholdOutcome `json:"thresholds"`
+	Config      map[string]string  `json:"config"`
+}
+
+// ThresholdOutcome is a threshold of the URL (or of all URLs) and its outcome
+type ThresholdOutcome struct {
+	Expression string  `json:"expression"`
+	Metric     string  `json:"metric"`
+	Operator   string  `json:"operator"`
+	Value      float64 `json:"value"`
+	Actual     float64 `json:"actual"`
+	Passed     bool    `json:"passed"`
+	Message    string  `json:"message"`
+}
+
+// Summaries returns the summary documents of a run, one per URL. Passed tells if the
+// whole run passed its thresholds.
+func Summaries(run *exporter.Run) []*RunSummary {
+	if run.Report == nil {
+		return nil
+	}
+	// anyone who can read the index sees the config, so options which may carry
+	// credentials are left out rather than masked
+	config := make(map[string]string)
+	for _, s := range run.Config {
+		if !exporter.Secret(s.Name) {
+			config[s.Name] = s.Value
+		}
+	}
+	passed := !thresholds.Failed(run.Outcomes)
+	var summaries []*RunSummary
+	for _, s := range run.Report.URLs {
+		summary := &RunSummary{
+			Timestamp:   run.Start,
+			RunID:       run.ID,
+			Version:     run.Version,
+			URL:         s.URL,
+			Start:       s.Start,
+			End:         s.End,
+			Duration:    s.End.Sub(s.Start),
+			Requests:    s.Requests,
+			Errors:      s.Errors,
+			ErrorRate:   s.ErrorRate,
+			RPS:         s.RPS,
+			StatusCodes: make(map[string]int),
+			ErrorKinds:  s.ErrorKinds,
+			Latency:     s.Latency,
+			Passed:      passed,
+			Thresholds:  []ThresholdOutcome{},
+			Config:      config,
+		}
+		for code, count := range s.StatusCodes {
+			summary.StatusCodes[strconv.Itoa(code)] = count
+		}
+		for _, o := range run.Outcomes {
+			if o.URL != "" && o.URL != s.URL {
+				continue
+			}
+			summary.Thresholds = append(summary.Thresholds, ThresholdOutcome{
+				Expression: o.Threshold.Expression,
+				Metric:     o.Threshold.Metric,
+				Operator:   o.Threshold.Operator,
+				Value:      o.Threshold.Value,
+				Actual:     o.Actual,
+				Passed:     o.Passed,
+				Message:    o.Message,
+			})
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// SummaryMappings returns the explicit mappings of the summary documents
+func SummaryMappings(dist Distribution) map[string]interface{} {
+	keyword := map[string]interface{}{"type": "keyword"}
+	counts := map[string]interface{}{"type": "object"}
+	return map[string]interface{}{
+		"_meta": map[string]interface{}{"created_by": "http-bomber"},
+		// status codes and error kinds are mapped as they come
+		"dynamic_templates": []interface{}{
+			map[string]interface{}{
+				"status_codes": map[string]interface{}{
+					"path_match": "status_codes.*",
+					"mapping":    map[string]interface{}{"type": "long"},
+				},
+			},
+			map[string]interface{}{
+				"error_kinds": map[string]interface{}{
+					"path_match": "error_kinds.*",
+					"mapping":    map[string]interface{}{"type": "long"},
+				},
+			},
+		},
+		"properties": map[string]interface{}{
+			"@timestamp":   map[string]interface{}{"type": "date"},
+			"run_id":       keyword,
+			"version":      keyword,
+			"url":          keyword,
+			"start":        map[string]interface{}{"type": "date"},
+			"end":          map[string]interface{}{"type": "date"},
+			"duration":     nanos,
+			"requests":     map[string]interface{}{"type": "long"},
+			"errors":       map[string]interface{}{"type": "long"},
+			"error_rate":   map[string]interface{}{"type": "double"},
+			"rps":          map[string]interface{}{"type": "double"},
+			"status_codes": counts,
+			"error_kinds":  counts,
+			"latency": map[string]interface{}{
+				"properties": map[string]interface{}{
+					"min":   nanos,
+					"mean":  nanos,
+					"p50":   nanos,
+					"p90":   nanos,
+					"p95":   nanos,
+					"p99":   nanos,
+					"p99_9": nanos,
+					"max":   nanos,
+				},
+			},
+			"passed": map[string]interface{}{"type": "boolean"},
+			"thresholds": map[string]interface{}{
+				"properties": map[string]interface{}{
+					"expression": keyword,
+					"metric":     keyword,
+					"operator":   keyword,
+					"value":      map[string]interface{}{"type": "double"},
+					"actual":     map[string]interface{}{"type": "double"},
+					"passed":     map[string]interface{}{"type": "boolean"},
+					"message":    map[string]interface{}{"type": "text"},
+				},
+			},
+			"config": dist.flattened(),
+		},
+	}
+}
+
+// ExportSummaries indexes the summary documents of a run. The document ID is made of the
+// run ID and the URL, so that exporting a run again replaces its summaries.
+func (mod *Module) ExportSummaries(config *Config, run *exporter.Run) error {
+	summaryConfig := *config
+	summaryConfig.IndexName = config.SummaryIndex
+	summaryConfig.IndexMode = ModeIndex
+	summaryConfig.ILMPolicy = ""
+	summaryConfig.GeoIPPipeline = ""
+	var setupErr error
+	if config.Template {
+		var dist Distribution
+		if dist, setupErr = mod.distribution(config); setupErr == nil {
+			setupErr = mod.put(&summaryConfig, "/_index_template/"+config.SummaryIndex, map[string]interface{}{
+				"index_patterns": []string{config.SummaryIndex},
+				"template":       map[string]interface{}{"mappings": SummaryMappings(dist)},
+				"priority":       200,
+				"_meta":          map[string]interface{}{"created_by": "http-bomber"},
+			})
+		}
+		if setupErr != nil {
+			setupErr = fmt.Errorf("failed to install summary index template: %v", setupErr)
+			mod.Logger.Error(fmt.Sprint("Elasticsearch setup failed: ", setupErr))
+		} else {
+			mapping, _ := json.Marshal(SummaryMappings(dist))
+			mappingString := string(mapping)
+			mod.CreateIndex(&summaryConfig)
+			mod.CreateIndexWithMapping(&summaryConfig, &mappingString)
+		}
+	}
+
+	bulk := mod.newBulkIndexer(&summaryConfig)
+	index, _ := json.Marshal(config.SummaryIndex)
+	for _, summary := range Summaries(run) {
+		data, err := json.Marshal(summary)
+		if err != nil {
+			return joinErrors(setupErr, err)
+		}
+		hash := sha1.Sum([]byte(summary.URL))
+		id, _ := json.Marshal(run.ID + "-" + hex.EncodeToString(hash[:8]))
+		item := []byte(fmt.Sprintf("{\"index\":{\"_index\":%s,\"_id\":%s}}\n%s\n", index, id, data))
+		bulk.add(item)
+	}
+	bulk.Flush()
+	mod.Logger.Info(fmt.Sprintf("Indexed %d run summaries into %s", bulk.Indexed, config.SummaryIndex))
+	if bulk.Dropped > 0 {
+		return joinErrors(setupErr, fmt.Errorf("dropped %d run summaries (last error: %s)", bulk.Dropped, bulk.LastError))
+	}
+	return setupErr
+}
@@ -0,0 +1,257 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"http-bomber/httptest"
+)
+
+// Index modes: one index, one index per day (<index>-2006.01.02) or a data stream
+const (
+	ModeIndex      = "index"
+	ModeDaily      = "daily"
+	ModeDataStream = "datastream"
+)
+
+// nanos is the mapping of durations, which are exported in nanoseconds
+var nanos = map[string]interface{}{"type": "long", "meta": map[string]interface{}{"unit": "nanos"}}
+
+// Mappings returns the explicit mappings of all result fields, and of the fields
+// added by enrichers. Headers are flattened so that they don't add a field per header.
+func Mappings(fieldTypes map[string]string, dist Distribution) map[string]interface{} {
+	properties := map[string]interface{}{
+		"@timestamp":       map[string]interface{}{"type": "date"},
+		"url":              map[string]interface{}{"type": "keyword"},
+		"req_headers":      dist.flattened(),
+		"resp_headers":     dist.flattened(),
+		"destination_ip":   map[string]interface{}{"type": "ip", "ignore_malformed": true},
+		"destination_port": map[string]interface{}{"type": "integer"},
+		"resp_status_code": map[string]interface{}{"type": "short"},
+		"resp_proto":       map[string]interface{}{"type": "keyword"},
+		"resp_body_size":   map[string]interface{}{"type": "long"},
+		"req_start_time":   map[string]interface{}{"type": "date_nanos"},
+		"req_end_time":     map[string]interface{}{"type": "date_nanos"},
+		"req_round_trip":   nanos,
+		"error": map[string]interface{}{
+			"type":   "text",
+			"fields": map[string]interface{}{"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 1024}},
+		},
+		"error_kind":  map[string]interface{}{"type": "keyword"},
+		"conn_reused": map[string]interface{}{"type": "boolean"},
+		"phases": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"dns":      nanos,
+				"connect":  nanos,
+				"tls":      nanos,
+				"wait":     nanos,
+				"transfer": nanos,
+			},
+		},
+		"trace_id": map[string]interface{}{"type": "keyword"},
+		"span_id":  map[string]interface{}{"type": "keyword"},
+		"modules":  map[string]interface{}{"type": "object", "properties": map[string]interface{}{}},
+	}
+	addFields(properties, fieldTypes)
+	return map[string]interface{}{
+		"_meta":      map[string]interface{}{"created_by": "http-bomber"},
+		"properties": properties,
+	}
+}
+
+// Index returns the index (or data stream) a result is written to
+func (config *Config) Index(r *httptest.Result) string {
+	if config.IndexMode == ModeDaily {
+		return config.IndexName + "-" + r.Timestamp.UTC().Format("2006.01.02")
+	}
+	return config.IndexName
+}
+
+// bulkAction is the bulk action of the index mode, data streams only accept create
+func (config *Config) bulkAction() string {
+	if config.IndexMode == ModeDataStream {
+		return "create"
+	}
+	return "index"
+}
+
+// IndexTemplate returns a composable index template for the index mode. On OpenSearch,
+// the ISM policy refers to the template's indices instead.
+func IndexTemplate(config *Config, fieldTypes map[string]string, dist Distribution) map[string]interface{} {
+	template := map[string]interface{}{
+		"mappings": Mappings(fieldTypes, dist),
+	}
+	if config.ILMPolicy != "" && !dist.OpenSearch() {
+		template["settings"] = map[string]interface{}{"index.lifecycle.name": config.ILMPolicy}
+	}
+	body := map[string]interface{}{
+		"index_patterns": []string{config.IndexName},
+		"template":       template,
+		// above the built-in templates (e.g. logs-*-*), which have priority 100
+		"priority": 200,
+		"_meta":    map[string]interface{}{"created_by": "http-bomber"},
+	}
+	switch config.IndexMode {
+	case ModeDaily:
+		body["index_patterns"] = []string{config.IndexName + "-*"}
+	case ModeDataStream:
+		body["data_stream"] = map[string]interface{}{}
+	}
+	return body
+}
+
+// LifecyclePolicy returns an ILM policy which rolls data streams over and deletes
+// indices after the retention period
+func LifecyclePolicy(config *Config) (map[string]interface{}, error) {
+	phases := make(map[string]interface{})
+	rollover := make(map[string]interface{})
+	if config.ILMRolloverAge != "" {
+		rollover["max_age"] = config.ILMRolloverAge
+	}
+	if config.ILMRolloverSize != "" {
+		rollover["max_primary_shard_size"] = config.ILMRolloverSize
+	}
+	// only data streams can roll over, indices of the other modes are deleted as a whole
+	if config.IndexMode == ModeDataStream && len(rollover) > 0 {
+		phases["hot"] = map[string]interface{}{
+			"actions": map[string]interface{}{"rollover": rollover},
+		}
+	}
+	if config.ILMRetention != "" {
+		phases["delete"] = map[string]interface{}{
+			"min_age": config.ILMRetention,
+			"actions": map[string]interface{}{"delete": map[string]interface{}{}},
+		}
+	}
+	if len(phases) == 0 {
+		return nil, fmt.Errorf("ILM policy %s has neither a rollover nor a retention", config.ILMPolicy)
+	}
+	return map[string]interface{}{
+		"policy": map[string]interface{}{
+			"phases": phases,
+			"_meta":  map[string]interface{}{"created_by": "http-bomber"},
+		},
+	}, nil
+}
+
+// Setup installs the GeoIP pipeline (if set), the ILM (or ISM) policy and the index
+// template, and for a single index creates it and updates its mapping (an existing
+// index doesn't get the template)
+func (mod *Module) Setup(config *Config, fieldTypes map[string]string) error {
+	var pipelineErr error
+	if config.GeoIPPipeline != "" {
+		if pipelineErr = mod.putGeoIPPipeline(config); pipelineErr == nil {
+			fieldTypes = geoIPFieldTypes(fieldTypes)
+		}
+	}
+	return joinErrors(pipelineErr, mod.setupTemplate(config, fieldTypes))
+}
+
+func (mod *Module) setupTemplate(config *Config, fieldTypes map[string]string) error {
+	if !config.Template {
+		// Fields added by enrichers may need an explicit mapping (e.g. geo_point)
+		if len(fieldTypes) > 0 && config.IndexMode == ModeIndex {
+			mod.CreateIndex(config)
+			return mod.putMapping(config, fieldMapping(fieldTypes))
+		}
+		return nil
+	}
+
+	dist, err := mod.distribution(config)
+	if err != nil {
+		return err
+	}
+	if config.ILMPolicy != "" && dist.OpenSearch() {
+		policy, err := StateManagementPolicy(config, dist)
+		if err != nil {
+			return err
+		}
+		if err := mod.putStateManagementPolicy(config, policy); err != nil {
+			return fmt.Errorf("failed to install ISM policy: %v", err)
+		}
+	} else if config.ILMPolicy != "" {
+		policy, err := LifecyclePolicy(config)
+		if err != nil {
+			return err
+		}
+		if err := mod.put(config, "/_ilm/policy/"+config.ILMPolicy, policy); err != nil {
+			return fmt.Errorf("failed to install ILM policy: %v", err)
+		}
+	}
+	if err := mod.put(config, "/_index_template/"+config.IndexName, IndexTemplate(config, fieldTypes, dist)); err != nil {
+		return fmt.Errorf("failed to install index template: %v", err)
+	}
+	if mod.Debug {
+		mod.Logger.Debug(fmt.Sprintf("Installed index template %s", config.IndexName))
+	}
+	if config.IndexMode == ModeIndex {
+		mod.CreateIndex(config)
+		err := mod.putMapping(config, Mappings(fieldTypes, dist))
+		if err != nil && len(fieldTypes) > 0 {
+			// An existing index rejects the whole mapping if it has mapped any field
+			// differently, the fields added by enrichers (e.g. geo_point) are put on their own
+			mod.Logger.Warning(fmt.Sprintf("%v, updating only the mapping of the enricher fields", err))
+			err = mod.putMapping(config, fieldMapping(fieldTypes))
+		}
+		return err
+	}
+	return nil
+}
+
+// putMapping updates the mapping of a single index
+func (mod *Module) putMapping(config *Config, mapping map[string]interface{}) error {
+	if err := mod.put(config, "/"+config.IndexName+"/_mapping", mapping); err != nil {
+		return fmt.Errorf("failed to update the mapping of index %s: %v", config.IndexName, err)
+	}
+	if mod.Debug {
+		mod.Logger.Debug(fmt.Sprintf("Updated the mapping of index %s", config.IndexName))
+	}
+	return nil
+}
+
+// put sends a JSON document to an API of Elasticsearch (or OpenSearch)
+func (mod *Module) put(config *Config, path string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", config.URL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := mod.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("status %s: %s", resp.Status, truncate(string(respBody), 300))
+	}
+	return nil
+}
+
+// addFields adds dotted field paths and their data types to mapping properties
+func addFields(root map[string]interface{}, fieldTypes map[string]string) {
+	for field, dataType := range fieldTypes {
+		properties := root
+		parts := strings.Split(field, ".")
+		for _, part := range parts[:len(parts)-1] {
+			child, found := properties[part].(map[string]interface{})
+			if !found {
+				child = make(map[string]interface{})
+				properties[part] = child
+			}
+			if _, found := child["properties"]; !found {
+				child["properties"] = make(map[string]interface{})
+			}
+			properties = child["properties"].(map[string]interface{})
+		}
+		properties[parts[len(parts)-1]] = map[string]interface{}{"type": dataType}
+	}
+}
@@ -0,0 +1,140 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"http-bomber/logging"
+)
+
+// fakeCluster records the PUT requests and answers them with the status the respond function
+// returns for the path and body
+type fakeCluster struct {
+	mu       sync.Mutex
+	requests []string
+	bodies   []map[string]interface{}
+	respond  func(path string, body map[string]interface{}) int
+}
+
+func (f *fakeCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+	f.mu.Lock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	f.bodies = append(f.bodies, body)
+	f.mu.Unlock()
+	status := http.StatusOK
+	if f.respond != nil {
+		status = f.respond(r.URL.Path, body)
+	}
+	w.WriteHeader(status)
+	if status != http.StatusOK {
+		w.Write([]byte(`{"error":{"type":"illegal_argument_exception"}}`))
+		return
+	}
+	w.Write([]byte(`{"acknowledged":true}`))
+}
+
+// newTestModule returns a module using a fake Elasticsearch 8 cluster, and the log
+func newTestModule(t *testing.T, respond func(string, map[string]interface{}) int) (*Module, *fakeCluster, *strings.Builder) {
+	fake := &fakeCluster{respond: respond}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	log := &strings.Builder{}
+	logger := &logging.Logger{}
+	logger.Init(log)
+	mod := &Module{
+		Config: Config{URL: server.URL, IndexName: "http-bomber", IndexMode: ModeIndex},
+		Logger: logger,
+		client: server.Client(),
+		dist:   &Distribution{Flavor: FlavorElasticsearch, Version: [3]int{8, 12, 0}},
+	}
+	return mod, fake, log
+}
+
+// properties returns the mapping of a dotted field path in a mapping body
+func properties(body map[string]interface{}, field string) interface{} {
+	var node interface{} = body
+	for _, part := range strings.Split(field, ".") {
+		m, _ := node.(map[string]interface{})
+		props, _ := m["properties"].(map[string]interface{})
+		node = props[part]
+	}
+	return node
+}
+
+func TestSetupIndexMapping(t *testing.T) {
+	enrichers := map[string]string{GeoIPLocation: "geo_point"}
+	// the existing index has mapped a header field dynamically
+	rejectFull := func(path string, body map[string]interface{}) int {
+		if path == "/http-bomber/_mapping" && properties(body, "req_headers") != nil {
+			return http.StatusBadRequest
+		}
+		return http.StatusOK
+	}
+	tests := []struct {
+		name       string
+		template   bool
+		fieldTypes map[string]string
+		respond    func(string, map[string]interface{}) int
+		mappings   int
+		wantErr    string
+		warning    bool
+	}{
+		{name: "full mapping", template: true, fieldTypes: enrichers, mappings: 1},
+		{name: "enricher fields after a rejected full mapping", template: true, fieldTypes: enrichers, respond: rejectFull, mappings: 2, warning: true},
+		{name: "rejected without enricher fields", template: true, respond: rejectFull, mappings: 1, wantErr: "failed to update the mapping of index http-bomber: status 400"},
+		{name: "both rejected", template: true, fieldTypes: enrichers, mappings: 2, warning: true,
+			respond: func(path string, body map[string]interface{}) int {
+				if path == "/http-bomber/_mapping" {
+					return http.StatusBadRequest
+				}
+				return http.StatusOK
+			}, wantErr: "failed to update the mapping of index http-bomber"},
+		{name: "enricher fields without template", fieldTypes: enrichers, mappings: 1},
+		{name: "enricher fields without template rejected", fieldTypes: enrichers, mappings: 1, wantErr: "status 400",
+			respond: func(path string, body map[string]interface{}) int {
+				if path == "/http-bomber/_mapping" {
+					return http.StatusBadRequest
+				}
+				return http.StatusOK
+			}},
+		{name: "nothing to map without template"},
+	}
+	for _, tt := range tests {
+		mod, fake, log := newTestModule(t, tt.respond)
+		mod.Config.Template = tt.template
+		err := mod.Setup(&mod.Config, tt.fieldTypes)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+		}
+		var mappings []map[string]interface{}
+		for i, r := range fake.requests {
+			if r == "PUT /http-bomber/_mapping" {
+				mappings = append(mappings, fake.bodies[i])
+			}
+		}
+		if len(mappings) != tt.mappings {
+			t.Fatalf("%s: %d mapping updates in %v, want %d", tt.name, len(mappings), fake.requests, tt.mappings)
+		}
+		if tt.mappings > 0 {
+			if len(tt.fieldTypes) > 0 && properties(mappings[len(mappings)-1], GeoIPLocation) == nil {
+				t.Errorf("%s: no geo_point mapping in the last update %v", tt.name, mappings[len(mappings)-1])
+			}
+			// the index is created before its mapping is updated
+			if fake.requests[0] == "PUT /http-bomber/_mapping" {
+				t.Errorf("%s: requests %v", tt.name, fake.requests)
+			}
+		}
+		if tt.mappings == 2 && properties(mappings[1], "req_headers") != nil {
+			t.Errorf("%s: the fallback mapping has more than the enricher fields: %v", tt.name, mappings[1])
+		}
+		if warned := strings.Contains(log.String(), "WARN: "); warned != tt.warning {
+			t.Errorf("%s: warning logged %v, want %v in %s", tt.name, warned, tt.warning, log.String())
+		}
+	}
+}
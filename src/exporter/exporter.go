@@ -0,0 +1,302 @@
+package exporter
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"http-bomber/httptest"
+	"http-bomber/logging"
+	"http-bomber/stats"
+	"http-bomber/thresholds"
+)
+
+// Setting is one option of the run configuration
+type Setting struct {
+	Name  string
+	Value string
+}
+
+// Masked is shown instead of the values of secret options
+const Masked = "***"
+
+// Secret tells if an option may carry credentials, by its name: keys, passwords, tokens,
+// headers (e.g. Authorization) and URLs (e.g. of webhooks, which hold a token)
+func Secret(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"apikey", "api-key", "password", "token", "secret"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	for _, s := range []string{"-key", "headers", "-url"} {
+		if strings.HasSuffix(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Mask returns the settings with the values of secret options masked
+func Mask(settings []Setting) []Setting {
+	masked := make([]Setting, len(settings))
+	for i, s := range settings {
+		if s.Value != "" && Secret(s.Name) {
+			s.Value = Masked
+		}
+		masked[i] = s
+	}
+	return masked
+}
+
+// Settings returns the options of a flag set, secrets masked
+func Settings(fs *flag.FlagSet) []Setting {
+	var settings []Setting
+	fs.VisitAll(func(f *flag.Flag) {
+		settings = append(settings, Setting{Name: f.Name, Value: f.Value.String()})
+	})
+	return Mask(settings)
+}
+
+// Run holds everything known about a finished run
+type Run struct {
+	ID string
+	// Version of HTTP Bomber
+	Version string
+	Start   time.Time
+	End     time.Time
+	// Settings of each test (one per URL)
+	Settings []httptest.Settings
+	// Config holds the command line options of the run, secrets masked
+	Config []Setting
+	// Results holds one resultset per URL
+	Results  [][]*httptest.Result
+	Report   *stats.Report
+	Outcomes []*thresholds.Outcome
+	// FieldTypes maps result fields added by enrichers to a data type, e.g. "modules.ipstack.LatitudeLongitude": "geo_point"
+	FieldTypes map[string]string
+}
+
+// Module is the common part of exporters and enrichers
+type Module interface {
+	// Name is used for turning the module on with -export or -enrich
+	Name() string
+	// RegisterFlags adds the module's own flags
+	RegisterFlags(fs *flag.FlagSet)
+	// Enabled tells if the module is turned on by its own flags
+	Enabled() bool
+	// Enable turns the module on when it is given by name
+	Enable()
+	Init(wg *sync.WaitGroup, logger *logging.Logger, debug bool)
+}
+
+// Exporter sends the results of a run somewhere
+type Exporter interface {
+	Module
+	Export(run *Run) error
+}
+
+// Enricher adds data to the results (into Result.Modules) before they are exported
+type Enricher interface {
+	Module
+	Enrich(run *Run) error
+}
+
+// Streamer is implemented by exporters which want each result while the run is going on.
+// Begin is called before the tests start, results are passed to the httptest.Observer methods
+// and Export is called at the end of the run as usual.
+type Streamer interface {
+	Exporter
+	httptest.Observer
+	Begin(run *Run) error
+}
+
+// FieldTyper is implemented by enrichers which add fields that need an explicit data type
+type FieldTyper interface {
+	FieldTypes() map[string]string
+}
+
+// TestConfigurer is implemented by modules which need to change the tests before they start,
+// e.g. to turn on trace context propagation
+type TestConfigurer interface {
+	ConfigureTest(test *httptest.Test)
+}
+
+// registry of all available modules, in registration order
+var exporters []Exporter
+var enrichers []Enricher
+
+// Register adds an exporter or an enricher to the registry. Modules register themselves in init().
+func Register(m Module) {
+	switch module := m.(type) {
+	case Exporter:
+		exporters = append(exporters, module)
+	case Enricher:
+		enrichers = append(enrichers, module)
+	default:
+		panic(fmt.Sprintf("exporter: module %s is neither an exporter nor an enricher", m.Name()))
+	}
+}
+
+// RegisterFlags lets every registered module add its own flags
+func RegisterFlags(fs *flag.FlagSet) {
+	for _, e := range enrichers {
+		e.RegisterFlags(fs)
+	}
+	for _, e := range exporters {
+		e.RegisterFlags(fs)
+	}
+}
+
+// EnableExporters turns on exporters by name (comma separated)
+func EnableExporters(names string) error {
+	modules := make([]Module, len(exporters))
+	for i, e := range exporters {
+		modules[i] = e
+	}
+	return enable(names, modules)
+}
+
+// EnableEnrichers turns on enrichers by name (comma separated)
+func EnableEnrichers(names string) error {
+	modules := make([]Module, len(enrichers))
+	for i, e := range enrichers {
+		modules[i] = e
+	}
+	return enable(names, modules)
+}
+
+func enable(names string, modules []Module) error {
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, m := range modules {
+			if m.Name() == name {
+				m.Enable()
+				found = true
+			}
+		}
+		if !found {
+			available := make([]string, 0, len(modules))
+			for _, m := range modules {
+				available = append(available, m.Name())
+			}
+			sort.Strings(available)
+			return fmt.Errorf("unknown module %q (available: %s)", name, strings.Join(available, ", "))
+		}
+	}
+	return nil
+}
+
+// Exporters returns the enabled exporters
+func Exporters() []Exporter {
+	var enabled []Exporter
+	for _, e := range exporters {
+		if e.Enabled() {
+			enabled = append(enabled, e)
+		}
+	}
+	return enabled
+}
+
+// Streamers returns the enabled exporters which stream results
+func Streamers() []Streamer {
+	var enabled []Streamer
+	for _, e := range Exporters() {
+		if s, ok := e.(Streamer); ok {
+			enabled = append(enabled, s)
+		}
+	}
+	return enabled
+}
+
+// Enrichers returns the enabled enrichers
+func Enrichers() []Enricher {
+	var enabled []Enricher
+	for _, e := range enrichers {
+		if e.Enabled() {
+			enabled = append(enabled, e)
+		}
+	}
+	return enabled
+}
+
+// Init initializes the enabled modules
+func Init(wg *sync.WaitGroup, logger *logging.Logger, debug bool) {
+	for _, e := range Enrichers() {
+		e.Init(wg, logger, debug)
+	}
+	for _, e := range Exporters() {
+		e.Init(wg, logger, debug)
+	}
+}
+
+// ConfigureTest lets the enabled modules change a test before it starts
+func ConfigureTest(test *httptest.Test) {
+	for _, e := range Enrichers() {
+		if c, ok := e.(TestConfigurer); ok {
+			c.ConfigureTest(test)
+		}
+	}
+	for _, e := range Exporters() {
+		if c, ok := e.(TestConfigurer); ok {
+			c.ConfigureTest(test)
+		}
+	}
+}
+
+// Begin prepares the enabled streamers before the tests start. The field types of the
+// enabled enrichers are set first, so that streamers can map the fields before they are added.
+func Begin(run *Run) error {
+	addFieldTypes(run)
+	for _, s := range Streamers() {
+		if err := s.Begin(run); err != nil {
+			return fmt.Errorf("%s: %v", s.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Process runs the enabled enrichers and then the enabled exporters. A failing module
+// does not stop the others, all errors are returned.
+func Process(run *Run, logger *logging.Logger) error {
+	var errs []string
+	addFieldTypes(run)
+	for _, e := range Enrichers() {
+		if err := e.Enrich(run); err != nil {
+			logger.Error(fmt.Sprintf("Enricher %s failed: %v", e.Name(), err))
+			errs = append(errs, fmt.Sprintf("%s: %v", e.Name(), err))
+		}
+	}
+	for _, e := range Exporters() {
+		if err := e.Export(run); err != nil {
+			logger.Error(fmt.Sprintf("Exporter %s failed: %v", e.Name(), err))
+			errs = append(errs, fmt.Sprintf("%s: %v", e.Name(), err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// addFieldTypes adds the field types of the enabled enrichers to the run
+func addFieldTypes(run *Run) {
+	if run.FieldTypes == nil {
+		run.FieldTypes = make(map[string]string)
+	}
+	for _, e := range Enrichers() {
+		if typer, ok := e.(FieldTyper); ok {
+			for field, dataType := range typer.FieldTypes() {
+				run.FieldTypes[field] = dataType
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package exporter
+
+import (
+	"flag"
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"testing"
+
+	"http-bomber/httptest"
+	"http-bomber/logging"
+)
+
+func TestSettingsMasksSecrets(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	values := map[string]string{
+		"url":                  "http://example.org",
+		"duration":             "10",
+		"elastic-api-key":      "id:key",
+		"elastic-password":     "secret",
+		"ipstack-apikey":       "1234",
+		"headers":              "Authorization:Bearer abc",
+		"otlp-headers":         "x-api-key=abc",
+		"remote-write-headers": "Authorization=Basic abc",
+		"webhook-headers":      "X-Token=abc",
+		"webhook-url":          "https://hooks.slack.com/services/T0/B0/abc",
+		"influx-token":         "abc",
+		"elastic-username":     "elastic",
+		"sqlite-path":          "",
+	}
+	for name := range values {
+		fs.String(name, "", "")
+	}
+	fs.String("elastic-ca-file", "", "")
+	for name, value := range values {
+		if err := fs.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]string{
+		"url":                  "http://example.org",
+		"duration":             "10",
+		"elastic-api-key":      Masked,
+		"elastic-password":     Masked,
+		"ipstack-apikey":       Masked,
+		"headers":              Masked,
+		"otlp-headers":         Masked,
+		"remote-write-headers": Masked,
+		"webhook-headers":      Masked,
+		"webhook-url":          Masked,
+		"influx-token":         Masked,
+		"elastic-username":     "elastic",
+		"sqlite-path":          "",
+		"elastic-ca-file":      "",
+	}
+	settings := Settings(fs)
+	if len(settings) != len(want) {
+		t.Fatalf("got %d settings, want %d", len(settings), len(want))
+	}
+	for _, s := range settings {
+		if s.Value != want[s.Name] {
+			t.Errorf("%s = %q, want %q", s.Name, s.Value, want[s.Name])
+		}
+	}
+}
+
+func TestElasticAPIKeyMasked(t *testing.T) {
+	for _, s := range Mask([]Setting{{Name: "elastic-api-key", Value: "id:key"}}) {
+		if s.Value != "***" {
+			t.Errorf("elastic-api-key = %q, want ***", s.Value)
+		}
+	}
+}
+
+func TestMaskKeepsInput(t *testing.T) {
+	settings := []Setting{{Name: "webhook-url", Value: "https://example.org/hook"}}
+	Mask(settings)
+	if settings[0].Value != "https://example.org/hook" {
+		t.Errorf("Mask changed its input: %q", settings[0].Value)
+	}
+}
+
+// fakeModule is a module turned on with -export or -enrich
+type fakeModule struct {
+	name    string
+	enabled bool
+}
+
+func (m *fakeModule) Name() string                                    { return m.name }
+func (m *fakeModule) RegisterFlags(fs *flag.FlagSet)                  {}
+func (m *fakeModule) Enabled() bool                                   { return m.enabled }
+func (m *fakeModule) Enable()                                         { m.enabled = true }
+func (m *fakeModule) Init(*sync.WaitGroup, *logging.Logger, bool)     {}
+func (m *fakeModule) RequestStarted(url string)                       {}
+func (m *fakeModule) RequestDone(url string, result *httptest.Result) {}
+
+// fakeEnricher adds a geo point
+type fakeEnricher struct{ fakeModule }
+
+func (e *fakeEnricher) Enrich(run *Run) error { return nil }
+func (e *fakeEnricher) FieldTypes() map[string]string {
+	return map[string]string{"modules." + e.name + ".location": "geo_point"}
+}
+
+// fakeStreamer keeps the field types it got in Begin and Export
+type fakeStreamer struct {
+	fakeModule
+	begin, export map[string]string
+}
+
+func (s *fakeStreamer) Begin(run *Run) error {
+	s.begin = copyTypes(run.FieldTypes)
+	return nil
+}
+
+func (s *fakeStreamer) Export(run *Run) error {
+	s.export = copyTypes(run.FieldTypes)
+	return nil
+}
+
+func copyTypes(types map[string]string) map[string]string {
+	copied := make(map[string]string)
+	for field, dataType := range types {
+		copied[field] = dataType
+	}
+	return copied
+}
+
+func TestFieldTypesBeforeBegin(t *testing.T) {
+	streamer := &fakeStreamer{fakeModule: fakeModule{name: "fake-streamer"}}
+	Register(&fakeEnricher{fakeModule{name: "fake-enabled"}})
+	Register(&fakeEnricher{fakeModule{name: "fake-disabled"}})
+	Register(streamer)
+	if err := EnableEnrichers("fake-enabled"); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableExporters("fake-streamer"); err != nil {
+		t.Fatal(err)
+	}
+
+	run := &Run{}
+	if err := Begin(run); err != nil {
+		t.Fatal(err)
+	}
+	logger := &logging.Logger{}
+	logger.Init(ioutil.Discard)
+	if err := Process(run, logger); err != nil {
+		t.Fatal(err)
+	}
+	// only the fields of the enabled enrichers, already when the streamer begins
+	want := map[string]string{"modules.fake-enabled.location": "geo_point"}
+	if !reflect.DeepEqual(streamer.begin, want) {
+		t.Errorf("field types %v in Begin, want %v", streamer.begin, want)
+	}
+	if !reflect.DeepEqual(streamer.export, want) {
+		t.Errorf("field types %v in Export, want %v", streamer.export, want)
+	}
+}
@@ -0,0 +1,3 @@
+module http-bomber/exporter
+
+go 1.16
@@ -0,0 +1,262 @@
+package fileexport
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"http-bomber/exporter"
+	"http-bomber/httptest"
+	"http-bomber/logging"
+)
+
+// Config holds configuration for one file exporter
+type Config struct {
+	Path string
+	Gzip bool
+}
+
+// Module ...
+type Module struct {
+	Config    Config
+	WaitGroup *sync.WaitGroup
+	Logger    *logging.Logger
+	Debug     bool
+	// format is either "csv" or "jsonl"
+	format string
+}
+
+func init() {
+	exporter.Register(&Module{format: "csv"})
+	exporter.Register(&Module{format: "jsonl"})
+}
+
+// Name ...
+func (mod *Module) Name() string {
+	return mod.format
+}
+
+// RegisterFlags ...
+func (mod *Module) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&mod.Config.Path, mod.format+"-path", "", fmt.Sprintf("Export results into a %s file", strings.ToUpper(mod.format)))
+	fs.BoolVar(&mod.Config.Gzip, mod.format+"-gzip", false, fmt.Sprintf("Gzip compress the %s file", strings.ToUpper(mod.format)))
+}
+
+// Enabled ...
+func (mod *Module) Enabled() bool {
+	return mod.Config.Path != ""
+}
+
+// Enable ...
+func (mod *Module) Enable() {
+	if mod.Config.Path == "" {
+		mod.Config.Path = "http-bomber-results." + mod.format
+		if mod.Config.Gzip {
+			mod.Config.Path += ".gz"
+		}
+	}
+}
+
+// Init ...
+func (mod *Module) Init(wg *sync.WaitGroup, logger *logging.Logger, debug bool) {
+	mod.WaitGroup = wg
+	mod.Logger = logger
+	mod.Debug = debug
+}
+
+// Export writes all results of a run into one file
+func (mod *Module) Export(run *exporter.Run) error {
+	mod.Logger.Info(fmt.Sprintf("Exporting results to %s", mod.Config.Path))
+	f, err := os.Create(mod.Config.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	buffered := bufio.NewWriter(f)
+	var w io.Writer = buffered
+	var gz *gzip.Writer
+	if mod.Config.Gzip {
+		gz = gzip.NewWriter(buffered)
+		w = gz
+	}
+
+	if mod.format == "csv" {
+		err = WriteCSV(w, run.Results)
+	} else {
+		err = WriteJSONL(w, run.Results)
+	}
+	if err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// WriteJSONL writes one JSON document per result and line
+func WriteJSONL(w io.Writer, results [][]*httptest.Result) error {
+	encoder := json.NewEncoder(w)
+	for _, resultSet := range results {
+		for _, r := range resultSet {
+			if err := encoder.Encode(r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// columns of the Result struct in declared order, other (header and module) columns
+// follow sorted by name
+var baseColumns = structColumns("", reflect.TypeOf(httptest.Result{}))
+
+// structColumns returns the JSON names of the plain fields of a struct in declared order,
+// nested structs (such as the phases) are flattened into dotted names
+func structColumns(prefix string, t reflect.Type) []string {
+	var columns []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" || field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		name = prefix + name
+		switch field.Type.Kind() {
+		case reflect.Map, reflect.Slice:
+			// keys are only known from the data
+			continue
+		case reflect.Struct:
+			if field.Type != reflect.TypeOf(time.Time{}) {
+				columns = append(columns, structColumns(name+".", field.Type)...)
+				continue
+			}
+		}
+		columns = append(columns, name)
+	}
+	return columns
+}
+
+// WriteCSV writes one row per result. Nested data (headers, module data) is flattened
+// into columns with dotted names, e.g. "resp_headers.Content-Type" or "modules.ipstack.City".
+func WriteCSV(w io.Writer, results [][]*httptest.Result) error {
+	var rows []map[string]string
+	columns := make(map[string]bool)
+	for _, resultSet := range results {
+		for _, r := range resultSet {
+			row, err := flattenResult(r)
+			if err != nil {
+				return err
+			}
+			for k := range row {
+				columns[k] = true
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	header := append([]string{}, baseColumns...)
+	var extra []string
+	for k := range columns {
+		if !contains(baseColumns, k) {
+			extra = append(extra, k)
+		}
+	}
+	sort.Strings(extra)
+	header = append(header, extra...)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	record := make([]string, len(header))
+	for _, row := range rows {
+		for i, column := range header {
+			record[i] = row[column]
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// flattenResult turns a result into column name/value pairs through its JSON representation
+func flattenResult(r *httptest.Result) (map[string]string, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	var doc map[string]interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	row := make(map[string]string)
+	flatten("", doc, row)
+	return row, nil
+}
+
+func flatten(prefix string, value interface{}, row map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			flatten(key, child, row)
+		}
+	case []interface{}:
+		// lists of plain values (such as header values) go into one column
+		parts := make([]string, 0, len(v))
+		plain := true
+		for _, child := range v {
+			switch child.(type) {
+			case map[string]interface{}, []interface{}:
+				plain = false
+			}
+			parts = append(parts, fmt.Sprint(child))
+		}
+		if plain {
+			row[prefix] = strings.Join(parts, "; ")
+			return
+		}
+		for i, child := range v {
+			flatten(fmt.Sprintf("%s.%d", prefix, i), child, row)
+		}
+	case nil:
+		// no column for empty values such as results without module data
+	default:
+		row[prefix] = fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,180 @@
+package fileexport
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"http-bomber/exporter"
+	"http-bomber/httptest"
+	"http-bomber/logging"
+)
+
+func testResults() [][]*httptest.Result {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ok := &httptest.Result{
+		Timestamp:       start.Add(20 * time.Millisecond),
+		URL:             "http://example.org/a?b=c,d",
+		ReqHeaders:      http.Header{"User-Agent": {"http-bomber"}},
+		RespHeaders:     http.Header{"Content-Type": {"text/html"}, "Set-Cookie": {"a=1", "b=2"}},
+		DestinationIP:   "192.0.2.1",
+		DestinationPort: 443,
+		RespStatusCode:  200,
+		RespProto:       "HTTP/2.0",
+		RespBodySize:    1234,
+		ReqStartTime:    start,
+		ReqEndTime:      start.Add(20 * time.Millisecond),
+		ReqRoundTrip:    20 * time.Millisecond,
+		ConnReused:      true,
+		Phases:          httptest.Phases{Wait: 15 * time.Millisecond, Transfer: 5 * time.Millisecond},
+		TraceID:         "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:          "00f067aa0ba902b7",
+		Modules:         map[string]interface{}{"ipstack": map[string]interface{}{"City": "Vienna"}},
+	}
+	failed := &httptest.Result{
+		Timestamp:    start.Add(time.Second),
+		URL:          "http://example.org/b",
+		ReqStartTime: start.Add(time.Second),
+		Error:        "dial tcp: i/o timeout",
+		ErrorKind:    "timeout",
+		Phases:       httptest.Phases{DNS: time.Millisecond},
+	}
+	return [][]*httptest.Result{{ok}, {failed}}
+}
+
+func TestCSVColumns(t *testing.T) {
+	want := []string{
+		"@timestamp", "url", "destination_ip", "destination_port", "resp_status_code", "resp_proto", "resp_body_size",
+		"req_start_time", "req_end_time", "req_round_trip", "error", "error_kind", "conn_reused",
+		"phases.dns", "phases.connect", "phases.tls", "phases.wait", "phases.transfer", "trace_id", "span_id",
+	}
+	if !reflect.DeepEqual(baseColumns, want) {
+		t.Errorf("base columns %v, want %v", baseColumns, want)
+	}
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, testResults()); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("%d records, want a header and 2 rows", len(records))
+	}
+	header := records[0]
+	// the base columns first, then the others sorted by name
+	wantHeader := append(append([]string{}, baseColumns...),
+		"modules.ipstack.City", "req_headers.User-Agent", "resp_headers.Content-Type", "resp_headers.Set-Cookie")
+	if !reflect.DeepEqual(header, wantHeader) {
+		t.Errorf("header %v, want %v", header, wantHeader)
+	}
+	rows := make([]map[string]string, 0, 2)
+	for _, record := range records[1:] {
+		row := make(map[string]string)
+		for i, column := range header {
+			row[column] = record[i]
+		}
+		rows = append(rows, row)
+	}
+	for column, want := range map[string]string{
+		"@timestamp":                "2024-01-01T00:00:00.02Z",
+		"url":                       "http://example.org/a?b=c,d",
+		"destination_port":          "443",
+		"resp_proto":                "HTTP/2.0",
+		"resp_body_size":            "1234",
+		"req_round_trip":            "20000000",
+		"conn_reused":               "true",
+		"phases.wait":               "15000000",
+		"phases.dns":                "0",
+		"trace_id":                  "4bf92f3577b34da6a3ce929d0e0e4736",
+		"error":                     "",
+		"modules.ipstack.City":      "Vienna",
+		"resp_headers.Set-Cookie":   "a=1; b=2",
+		"resp_headers.Content-Type": "text/html",
+	} {
+		if got := rows[0][column]; got != want {
+			t.Errorf("%s = %q, want %q", column, got, want)
+		}
+	}
+	for column, want := range map[string]string{
+		"url":                  "http://example.org/b",
+		"resp_status_code":     "0",
+		"error":                "dial tcp: i/o timeout",
+		"error_kind":           "timeout",
+		"phases.dns":           "1000000",
+		"modules.ipstack.City": "",
+	} {
+		if got := rows[1][column]; got != want {
+			t.Errorf("failed request %s = %q, want %q", column, got, want)
+		}
+	}
+}
+
+func TestJSONLRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	results := testResults()
+	if err := WriteJSONL(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(&buf)
+	var decoded []*httptest.Result
+	for scanner.Scan() {
+		r := &httptest.Result{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			t.Fatalf("invalid line %s: %v", scanner.Text(), err)
+		}
+		decoded = append(decoded, r)
+	}
+	if len(decoded) != 2 {
+		t.Fatalf("%d lines, want 2", len(decoded))
+	}
+	for i, want := range []*httptest.Result{results[0][0], results[1][0]} {
+		if !reflect.DeepEqual(decoded[i], want) {
+			t.Errorf("line %d decoded as %+v, want %+v", i+1, decoded[i], want)
+		}
+	}
+}
+
+func TestExportGzip(t *testing.T) {
+	logger := &logging.Logger{}
+	logger.Init(ioutil.Discard)
+	for _, format := range []string{"csv", "jsonl"} {
+		path := filepath.Join(t.TempDir(), "results."+format+".gz")
+		mod := &Module{format: format, Config: Config{Path: path, Gzip: true}}
+		mod.Init(&sync.WaitGroup{}, logger, false)
+		if err := mod.Export(&exporter.Run{Results: testResults()}); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("%s: not gzip compressed: %v", format, err)
+		}
+		data, err := ioutil.ReadAll(gz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "http://example.org/b") {
+			t.Errorf("%s: results missing in %s", format, data)
+		}
+	}
+}
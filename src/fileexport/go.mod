@@ -0,0 +1,3 @@
+module http-bomber/fileexport
+
+go 1.16
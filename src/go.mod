@@ -4,10 +4,43 @@ require "http-bomber/httptest" v0.0.0
 require "http-bomber/logging" v0.0.0
 require "http-bomber/elasticsearch" v0.0.0
 require "http-bomber/ipstack" v0.0.0
+require "http-bomber/stats" v0.0.0
+require "http-bomber/thresholds" v0.0.0
+require "http-bomber/live" v0.0.0
+require "http-bomber/htmlreport" v0.0.0
+require "http-bomber/compare" v0.0.0
+require "http-bomber/exporter" v0.0.0
+require "http-bomber/fileexport" v0.0.0
+require "http-bomber/prometheus" v0.0.0
+require "http-bomber/protobuf" v0.0.0
+require "http-bomber/influxdb" v0.0.0
+require "http-bomber/otlp" v0.0.0
+require "http-bomber/statsd" v0.0.0
+require "http-bomber/webhook" v0.0.0
+require "http-bomber/har" v0.0.0
+require "http-bomber/sqlite" v0.0.0
+require "http-bomber/kibana" v0.0.0
+require "modernc.org/sqlite" v1.20.4
 
 
 replace http-bomber/httptest => ./httptest
 replace http-bomber/logging => ./logging
 replace http-bomber/elasticsearch => ./elasticsearch
 replace http-bomber/ipstack => ./ipstack
+replace http-bomber/stats => ./stats
+replace http-bomber/thresholds => ./thresholds
+replace http-bomber/live => ./live
+replace http-bomber/htmlreport => ./htmlreport
+replace http-bomber/compare => ./compare
+replace http-bomber/exporter => ./exporter
+replace http-bomber/fileexport => ./fileexport
+replace http-bomber/prometheus => ./prometheus
+replace http-bomber/protobuf => ./protobuf
+replace http-bomber/influxdb => ./influxdb
+replace http-bomber/otlp => ./otlp
+replace http-bomber/statsd => ./statsd
+replace http-bomber/webhook => ./webhook
+replace http-bomber/har => ./har
+replace http-bomber/sqlite => ./sqlite
+replace http-bomber/kibana => ./kibana
 go 1.16
@@ -0,0 +1,3 @@
+module http-bomber/har
+
+go 1.16
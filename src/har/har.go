@@ -0,0 +1,355 @@
+package har
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"http-bomber/exporter"
+	"http-bomber/httptest"
+	"http-bomber/logging"
+)
+
+// Config holds configuration for the HAR exporter
+type Config struct {
+	Path string
+	// SampleRate is the share of requests which are written
+	SampleRate float64
+	FailedOnly bool
+	Bodies     bool
+	// BodyLimit is the maximum number of bytes kept of each response body
+	BodyLimit int
+}
+
+// default path when enabled with -export har
+const defaultPath = "http-bomber-results.har"
+
+// Module ...
+type Module struct {
+	Config    Config
+	WaitGroup *sync.WaitGroup
+	Logger    *logging.Logger
+	Debug     bool
+	// requests picked for the HAR file while the run is going on
+	mu      sync.Mutex
+	results []*httptest.Result
+}
+
+// HAR 1.2 (http://www.softwareishard.com/blog/har-12-spec/)
+
+// Log is the root of a HAR file
+type Log struct {
+	Log struct {
+		Version string  `json:"version"`
+		Creator Creator `json:"creator"`
+		Entries []Entry `json:"entries"`
+		Comment string  `json:"comment,omitempty"`
+	} `json:"log"`
+}
+
+// Creator ...
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Entry is one request
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	// Time is the total time of the request in milliseconds
+	Time            float64  `json:"time"`
+	Request         Request  `json:"request"`
+	Response        Response `json:"response"`
+	Cache           struct{} `json:"cache"`
+	Timings         Timings  `json:"timings"`
+	ServerIPAddress string   `json:"serverIPAddress,omitempty"`
+	Connection      string   `json:"connection,omitempty"`
+	// Comment holds the error of failed requests
+	Comment string `json:"comment,omitempty"`
+}
+
+// Request ...
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+// Response ...
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+// Content is the response body
+type Content struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// NameValue is a header, cookie or query parameter
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Timings in milliseconds, -1 for phases which did not happen
+type Timings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+func init() {
+	exporter.Register(&Module{})
+}
+
+// Name ...
+func (mod *Module) Name() string {
+	return "har"
+}
+
+// RegisterFlags ...
+func (mod *Module) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&mod.Config.Path, "har-path", "", "Export requests into a HAR file")
+	fs.Float64Var(&mod.Config.SampleRate, "har-sample-rate", 1, "Share of requests written to the HAR file (0 < rate <= 1)")
+	fs.BoolVar(&mod.Config.FailedOnly, "har-failed-only", false, "Write only failed requests and 5xx responses to the HAR file")
+	fs.BoolVar(&mod.Config.Bodies, "har-bodies", false, "Include response bodies in the HAR file")
+	fs.IntVar(&mod.Config.BodyLimit, "har-body-limit", 64*1024, "Maximum number of bytes of each response body in the HAR file")
+}
+
+// Enabled ...
+func (mod *Module) Enabled() bool {
+	return mod.Config.Path != ""
+}
+
+// Enable ...
+func (mod *Module) Enable() {
+	if mod.Config.Path == "" {
+		mod.Config.Path = defaultPath
+	}
+}
+
+// Init ...
+func (mod *Module) Init(wg *sync.WaitGroup, logger *logging.Logger, debug bool) {
+	mod.WaitGroup = wg
+	mod.Logger = logger
+	mod.Debug = debug
+}
+
+// ConfigureTest keeps the response bodies if they are included
+func (mod *Module) ConfigureTest(test *httptest.Test) {
+	if mod.Config.Bodies && mod.Config.BodyLimit > test.Settings.CaptureBody {
+		test.Settings.CaptureBody = mod.Config.BodyLimit
+	}
+}
+
+// Begin checks the sample rate before the tests start
+func (mod *Module) Begin(run *exporter.Run) error {
+	if mod.Config.SampleRate <= 0 || mod.Config.SampleRate > 1 {
+		return fmt.Errorf("invalid -har-sample-rate %v (use 0 < rate <= 1)", mod.Config.SampleRate)
+	}
+	return nil
+}
+
+// RequestStarted ...
+func (mod *Module) RequestStarted(url string) {}
+
+// RequestDone picks the requests written to the HAR file, the bodies of the other
+// requests are dropped right away so that only those of the picked ones are kept
+// until the end of the run
+func (mod *Module) RequestDone(url string, result *httptest.Result) {
+	if result == nil {
+		return
+	}
+	if (mod.Config.FailedOnly && !result.Failed()) ||
+		(mod.Config.SampleRate < 1 && rand.Float64() >= mod.Config.SampleRate) {
+		result.RespBody = nil
+		return
+	}
+	mod.mu.Lock()
+	mod.results = append(mod.results, result)
+	mod.mu.Unlock()
+}
+
+// Export writes the picked requests into the HAR file
+func (mod *Module) Export(run *exporter.Run) error {
+	mod.mu.Lock()
+	results := mod.results
+	mod.mu.Unlock()
+	// requests of all URLs in the order they were made
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].ReqStartTime.Before(results[j].ReqStartTime)
+	})
+
+	mod.Logger.Info(fmt.Sprintf("Exporting %d requests to %s", len(results), mod.Config.Path))
+	f, err := os.Create(mod.Config.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	if err := Write(w, run, results, mod.Config.Bodies); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Write writes results as a HAR log
+func Write(w io.Writer, run *exporter.Run, results []*httptest.Result, bodies bool) error {
+	var log Log
+	log.Log.Version = "1.2"
+	log.Log.Creator = Creator{Name: "http-bomber", Version: run.Version}
+	log.Log.Comment = "Run " + run.ID
+	log.Log.Entries = make([]Entry, 0, len(results))
+	for _, r := range results {
+		log.Log.Entries = append(log.Log.Entries, NewEntry(r, bodies))
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(log)
+}
+
+// NewEntry maps a result to a HAR entry
+func NewEntry(r *httptest.Result, bodies bool) Entry {
+	e := Entry{
+		StartedDateTime: r.ReqStartTime,
+		Time:            milliseconds(r.ReqRoundTrip),
+		Request: Request{
+			Method:      "GET",
+			URL:         r.URL,
+			HTTPVersion: r.RespProto,
+			Cookies:     []NameValue{},
+			Headers:     nameValues(r.ReqHeaders),
+			QueryString: []NameValue{},
+			HeadersSize: -1,
+		},
+		Response: Response{
+			Status:      r.RespStatusCode,
+			HTTPVersion: r.RespProto,
+			Cookies:     []NameValue{},
+			Headers:     nameValues(r.RespHeaders),
+			RedirectURL: r.RespHeaders.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    r.RespBodySize,
+			Content: Content{
+				Size:     r.RespBodySize,
+				MimeType: r.RespHeaders.Get("Content-Type"),
+			},
+		},
+		Timings:         timings(r),
+		ServerIPAddress: r.DestinationIP,
+	}
+	if u, err := url.Parse(r.URL); err == nil {
+		for name, values := range u.Query() {
+			for _, v := range values {
+				e.Request.QueryString = append(e.Request.QueryString, NameValue{name, v})
+			}
+		}
+		sort.Slice(e.Request.QueryString, func(i, j int) bool {
+			return e.Request.QueryString[i].Name < e.Request.QueryString[j].Name
+		})
+	}
+	if r.RespStatusCode > 0 {
+		e.Response.StatusText = http.StatusText(r.RespStatusCode)
+	}
+	if r.DestinationPort > 0 {
+		e.Connection = strconv.Itoa(r.DestinationPort)
+	}
+	if r.Error != "" {
+		e.Comment = r.Error
+		e.Response.BodySize = -1
+	}
+	if bodies && len(r.RespBody) > 0 {
+		if utf8.Valid(r.RespBody) {
+			e.Response.Content.Text = string(r.RespBody)
+		} else {
+			e.Response.Content.Text = base64.StdEncoding.EncodeToString(r.RespBody)
+			e.Response.Content.Encoding = "base64"
+		}
+		if len(r.RespBody) < r.RespBodySize {
+			e.Response.Content.Comment = fmt.Sprintf("truncated to %d bytes", len(r.RespBody))
+		}
+	}
+	return e
+}
+
+// timings maps the httptrace phases: connect includes the TLS handshake, wait is the
+// time to the first response byte and receive the transfer of the body. Send is the
+// remainder of the total time, so that the timings add up.
+func timings(r *httptest.Result) Timings {
+	t := Timings{
+		Blocked: -1,
+		DNS:     -1,
+		Connect: -1,
+		SSL:     -1,
+		Wait:    milliseconds(r.Phases.Wait),
+		Receive: milliseconds(r.Phases.Transfer),
+	}
+	if r.Phases.DNS > 0 {
+		t.DNS = milliseconds(r.Phases.DNS)
+	}
+	if r.Phases.Connect > 0 || r.Phases.TLS > 0 {
+		t.Connect = milliseconds(r.Phases.Connect + r.Phases.TLS)
+	}
+	if r.Phases.TLS > 0 {
+		t.SSL = milliseconds(r.Phases.TLS)
+	}
+	send := r.ReqRoundTrip - r.Phases.DNS - r.Phases.Connect - r.Phases.TLS - r.Phases.Wait - r.Phases.Transfer
+	if send > 0 {
+		t.Send = milliseconds(send)
+	}
+	return t
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// nameValues returns headers sorted by name
+func nameValues(header http.Header) []NameValue {
+	list := []NameValue{}
+	for name, values := range header {
+		for _, v := range values {
+			list = append(list, NameValue{name, v})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
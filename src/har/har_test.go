@@ -0,0 +1,263 @@
+package har
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"http-bomber/exporter"
+	"http-bomber/httptest"
+	"http-bomber/logging"
+)
+
+func newModule(t *testing.T, config Config) *Module {
+	logger := &logging.Logger{}
+	logger.Init(ioutil.Discard)
+	if config.Path == "" {
+		config.Path = filepath.Join(t.TempDir(), "results.har")
+	}
+	mod := &Module{Config: config}
+	mod.Init(&sync.WaitGroup{}, logger, false)
+	if err := mod.Begin(&exporter.Run{ID: "run-1"}); err != nil {
+		t.Fatal(err)
+	}
+	return mod
+}
+
+// read decodes the written HAR file without the Go types, as a HAR viewer would
+func read(t *testing.T, path string) map[string]interface{} {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	return doc["log"].(map[string]interface{})
+}
+
+// requireFields fails for each field of the list which the object does not have
+func requireFields(t *testing.T, what string, object interface{}, fields ...string) {
+	m, ok := object.(map[string]interface{})
+	if !ok {
+		t.Errorf("%s is %T, want an object", what, object)
+		return
+	}
+	for _, f := range fields {
+		if _, found := m[f]; !found {
+			t.Errorf("%s misses the required field %s", what, f)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	mod := newModule(t, Config{SampleRate: 1, Bodies: true, BodyLimit: 1024})
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	results := []*httptest.Result{
+		{
+			URL: "https://example.org/search?q=a&lang=en", ReqStartTime: start.Add(time.Second), ReqRoundTrip: 100 * time.Millisecond,
+			ReqHeaders: http.Header{"User-Agent": {"http-bomber"}}, RespHeaders: http.Header{"Content-Type": {"text/plain"}},
+			RespStatusCode: 200, RespProto: "HTTP/2.0", RespBodySize: 5, RespBody: []byte("hello"),
+			DestinationIP: "192.0.2.1", DestinationPort: 443,
+			Phases: httptest.Phases{DNS: 10 * time.Millisecond, Connect: 20 * time.Millisecond, TLS: 30 * time.Millisecond, Wait: 25 * time.Millisecond, Transfer: 5 * time.Millisecond},
+		},
+		// made before the first one
+		{URL: "https://example.org/", ReqStartTime: start, Error: "dial tcp: i/o timeout", ErrorKind: "timeout"},
+	}
+	for _, r := range results {
+		mod.RequestStarted(r.URL)
+		mod.RequestDone(r.URL, r)
+	}
+	mod.RequestStarted("https://example.org/")
+	mod.RequestDone("https://example.org/", nil)
+	if err := mod.Export(&exporter.Run{ID: "run-1", Version: "1.2.3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	log := read(t, mod.Config.Path)
+	requireFields(t, "log", log, "version", "creator", "entries")
+	if log["version"] != "1.2" {
+		t.Errorf("version %v, want 1.2", log["version"])
+	}
+	requireFields(t, "creator", log["creator"], "name", "version")
+	if creator := log["creator"].(map[string]interface{}); creator["name"] != "http-bomber" || creator["version"] != "1.2.3" {
+		t.Errorf("creator %v", creator)
+	}
+	entries := log["entries"].([]interface{})
+	if len(entries) != 2 {
+		t.Fatalf("%d entries, want 2", len(entries))
+	}
+	for i, entry := range entries {
+		what := fmt.Sprintf("entry %d", i)
+		requireFields(t, what, entry, "startedDateTime", "time", "request", "response", "cache", "timings")
+		e := entry.(map[string]interface{})
+		requireFields(t, what+" request", e["request"], "method", "url", "httpVersion", "cookies", "headers", "queryString", "headersSize", "bodySize")
+		requireFields(t, what+" response", e["response"], "status", "statusText", "httpVersion", "cookies", "headers", "content", "redirectURL", "headersSize", "bodySize")
+		requireFields(t, what+" content", e["response"].(map[string]interface{})["content"], "size", "mimeType")
+		requireFields(t, what+" timings", e["timings"], "send", "wait", "receive")
+	}
+
+	// in the order the requests were made
+	var decoded Log
+	data, _ := ioutil.ReadFile(mod.Config.Path)
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	failed, done := decoded.Log.Entries[0], decoded.Log.Entries[1]
+	if failed.Request.URL != "https://example.org/" || failed.Comment != "dial tcp: i/o timeout" || failed.Response.Status != 0 || failed.Response.BodySize != -1 {
+		t.Errorf("failed request entry %+v", failed)
+	}
+	if done.Response.Status != 200 || done.Response.StatusText != "OK" || done.Response.Content.Text != "hello" || done.Connection != "443" || done.ServerIPAddress != "192.0.2.1" {
+		t.Errorf("entry %+v", done)
+	}
+	if len(done.Request.QueryString) != 2 || done.Request.QueryString[0] != (NameValue{"lang", "en"}) || done.Request.QueryString[1] != (NameValue{"q", "a"}) {
+		t.Errorf("query string %v", done.Request.QueryString)
+	}
+	if decoded.Log.Comment != "Run run-1" {
+		t.Errorf("comment %q", decoded.Log.Comment)
+	}
+}
+
+func TestTimings(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name   string
+		result *httptest.Result
+		want   Timings
+	}{
+		{
+			name:   "new TLS connection",
+			result: &httptest.Result{ReqRoundTrip: 100 * ms, Phases: httptest.Phases{DNS: 10 * ms, Connect: 20 * ms, TLS: 30 * ms, Wait: 25 * ms, Transfer: 5 * ms}},
+			want:   Timings{Blocked: -1, DNS: 10, Connect: 50, SSL: 30, Send: 10, Wait: 25, Receive: 5},
+		},
+		{
+			name:   "reused connection",
+			result: &httptest.Result{ReqRoundTrip: 31 * ms, Phases: httptest.Phases{Wait: 30 * ms, Transfer: ms}},
+			want:   Timings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Send: 0, Wait: 30, Receive: 1},
+		},
+		{
+			name:   "plain HTTP",
+			result: &httptest.Result{ReqRoundTrip: 10 * ms, Phases: httptest.Phases{DNS: ms, Connect: 2 * ms, Wait: 5 * ms, Transfer: ms}},
+			want:   Timings{Blocked: -1, DNS: 1, Connect: 2, SSL: -1, Send: 1, Wait: 5, Receive: 1},
+		},
+	}
+	for _, tt := range tests {
+		got := timings(tt.result)
+		if got != tt.want {
+			t.Errorf("%s: timings %+v, want %+v", tt.name, got, tt.want)
+		}
+		// the timings add up to the total time (ssl is part of connect)
+		total := got.Send + got.Wait + got.Receive
+		for _, v := range []float64{got.Blocked, got.DNS, got.Connect} {
+			if v > 0 {
+				total += v
+			}
+		}
+		if total != milliseconds(tt.result.ReqRoundTrip) {
+			t.Errorf("%s: timings add up to %vms, want %vms", tt.name, total, milliseconds(tt.result.ReqRoundTrip))
+		}
+	}
+}
+
+func TestBodies(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     []byte
+		size     int
+		bodies   bool
+		text     string
+		encoding string
+		comment  string
+	}{
+		{"text", []byte("héllo"), 6, true, "héllo", "", ""},
+		{"binary", []byte{0xff, 0x00, 0x01}, 3, true, "/wAB", "base64", ""},
+		{"truncated", []byte("abc"), 10, true, "abc", "", "truncated to 3 bytes"},
+		{"without bodies", []byte("hello"), 5, false, "", "", ""},
+	}
+	for _, tt := range tests {
+		e := NewEntry(&httptest.Result{URL: "http://a", RespStatusCode: 200, RespBody: tt.body, RespBodySize: tt.size}, tt.bodies)
+		c := e.Response.Content
+		if c.Text != tt.text || c.Encoding != tt.encoding || c.Comment != tt.comment || c.Size != tt.size {
+			t.Errorf("%s: content %+v, want %q %q %q", tt.name, c, tt.text, tt.encoding, tt.comment)
+		}
+	}
+}
+
+func TestConfigureTest(t *testing.T) {
+	tests := []struct {
+		config Config
+		want   int
+	}{
+		{Config{Bodies: true, BodyLimit: 4096}, 4096},
+		{Config{Bodies: false, BodyLimit: 4096}, 0},
+	}
+	for _, tt := range tests {
+		test := &httptest.Test{}
+		mod := &Module{Config: tt.config}
+		mod.ConfigureTest(test)
+		if test.Settings.CaptureBody != tt.want {
+			t.Errorf("%+v: capturing %d bytes, want %d", tt.config, test.Settings.CaptureBody, tt.want)
+		}
+	}
+}
+
+func TestSampling(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     Config
+		requests   int
+		min, max   int
+		failedOnly bool
+	}{
+		{"all", Config{SampleRate: 1, Bodies: true}, 100, 100, 100, false},
+		{"failed only", Config{SampleRate: 1, Bodies: true, FailedOnly: true}, 100, 50, 50, true},
+		{"half", Config{SampleRate: 0.5, Bodies: true}, 1000, 400, 600, false},
+	}
+	for _, tt := range tests {
+		mod := newModule(t, tt.config)
+		var results []*httptest.Result
+		for i := 0; i < tt.requests; i++ {
+			r := &httptest.Result{URL: "http://a", RespStatusCode: 200, RespBody: []byte("body"), RespBodySize: 4}
+			// every second request failed
+			if i%2 == 1 {
+				r.RespStatusCode = 503
+			}
+			results = append(results, r)
+			mod.RequestDone(r.URL, r)
+		}
+		picked := len(mod.results)
+		if picked < tt.min || picked > tt.max {
+			t.Errorf("%s: %d requests picked, want %d to %d", tt.name, picked, tt.min, tt.max)
+		}
+		inHAR := make(map[*httptest.Result]bool)
+		for _, r := range mod.results {
+			inHAR[r] = true
+			if tt.failedOnly && !r.Failed() {
+				t.Errorf("%s: picked a request which did not fail", tt.name)
+			}
+		}
+		// only the bodies of the picked requests are kept
+		for _, r := range results {
+			if kept := r.RespBody != nil; kept != inHAR[r] {
+				t.Errorf("%s: body kept %v for a request picked %v", tt.name, kept, inHAR[r])
+				break
+			}
+		}
+	}
+}
+
+func TestBeginRejectsSampleRate(t *testing.T) {
+	for _, rate := range []float64{0, -0.5, 1.5} {
+		mod := &Module{Config: Config{SampleRate: rate}}
+		if err := mod.Begin(&exporter.Run{}); err == nil || !strings.Contains(err.Error(), "-har-sample-rate") {
+			t.Errorf("sample rate %v: error %v, want an invalid rate", rate, err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package htmlreport
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"math"
+	"strings"
+)
+
+// chart dimensions
+const chartWidth = 900
+const chartHeight = 280
+const marginLeft = 70
+const marginRight = 20
+const marginTop = 20
+const marginBottom = 40
+
+// colors used for series, cycled if there are more series
+var palette = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"}
+
+// point on a chart
+type point struct {
+	X float64
+	Y float64
+}
+
+// series is a named set of points
+type series struct {
+	Name   string
+	Points []point
+}
+
+// bar of a bar chart
+type bar struct {
+	Label string
+	Value float64
+}
+
+// scale maps a value range to pixels
+type scale struct {
+	min, max float64
+	from, to float64
+}
+
+func (s scale) pos(v float64) float64 {
+	if s.max == s.min {
+		return s.from
+	}
+	return s.from + (v-s.min)/(s.max-s.min)*(s.to-s.from)
+}
+
+// niceMax rounds the maximum of an axis up to a readable number
+func niceMax(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, step := range []float64{1, 2, 2.5, 5, 10} {
+		if step*magnitude >= v {
+			return step * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// formatNumber formats axis labels
+func formatNumber(v float64) string {
+	if v == math.Trunc(v) {
+		return fmt.Sprintf("%.0f", v)
+	}
+	return fmt.Sprintf("%.3g", v)
+}
+
+// axes draws the y grid lines and the axis labels
+func axes(b *strings.Builder, xs scale, ys scale, xLabel string, yLabel string, xFormat func(float64) string) {
+	for i := 0; i <= 4; i++ {
+		v := ys.min + (ys.max-ys.min)*float64(i)/4
+		y := ys.pos(v)
+		fmt.Fprintf(b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#e0e0e0"/>`, marginLeft, y, chartWidth-marginRight, y)
+		fmt.Fprintf(b, `<text x="%d" y="%.1f" text-anchor="end" font-size="11">%s</text>`, marginLeft-6, y+4, formatNumber(v))
+	}
+	if xFormat != nil {
+		for i := 0; i <= 5; i++ {
+			v := xs.min + (xs.max-xs.min)*float64(i)/5
+			fmt.Fprintf(b, `<text x="%.1f" y="%d" text-anchor="middle" font-size="11">%s</text>`, xs.pos(v), chartHeight-marginBottom+16, html.EscapeString(xFormat(v)))
+		}
+	}
+	fmt.Fprintf(b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#333"/>`, marginLeft, chartHeight-marginBottom, chartWidth-marginRight, chartHeight-marginBottom)
+	fmt.Fprintf(b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#333"/>`, marginLeft, marginTop, marginLeft, chartHeight-marginBottom)
+	fmt.Fprintf(b, `<text x="%d" y="%d" text-anchor="middle" font-size="12">%s</text>`, (chartWidth+marginLeft)/2, chartHeight-6, html.EscapeString(xLabel))
+	fmt.Fprintf(b, `<text x="14" y="%d" text-anchor="middle" font-size="12" transform="rotate(-90 14 %d)">%s</text>`, chartHeight/2, chartHeight/2, html.EscapeString(yLabel))
+}
+
+// legend draws the names of the series
+func legend(b *strings.Builder, names []string) {
+	x := marginLeft + 10
+	for i, name := range names {
+		color := palette[i%len(palette)]
+		fmt.Fprintf(b, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/>`, x, marginTop, color)
+		fmt.Fprintf(b, `<text x="%d" y="%d" font-size="11">%s</text>`, x+14, marginTop+9, html.EscapeString(name))
+		x += 24 + 7*len(name)
+	}
+}
+
+// lineChart renders series as an inline SVG line chart
+func lineChart(data []series, xLabel string, yLabel string, xFormat func(float64) string) template.HTML {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="100%%">`, chartWidth, chartHeight)
+	xs := scale{min: math.Inf(1), max: math.Inf(-1), from: marginLeft, to: chartWidth - marginRight}
+	ys := scale{from: chartHeight - marginBottom, to: marginTop + 16}
+	empty := true
+	for _, s := range data {
+		for _, p := range s.Points {
+			empty = false
+			xs.min = math.Min(xs.min, p.X)
+			xs.max = math.Max(xs.max, p.X)
+			ys.max = math.Max(ys.max, p.Y)
+		}
+	}
+	if empty {
+		b.WriteString(`<text x="50%" y="50%" text-anchor="middle">No data</text></svg>`)
+		return template.HTML(b.String())
+	}
+	ys.max = niceMax(ys.max)
+	axes(&b, xs, ys, xLabel, yLabel, xFormat)
+	names := make([]string, 0, len(data))
+	for i, s := range data {
+		names = append(names, s.Name)
+		coords := make([]string, 0, len(s.Points))
+		for _, p := range s.Points {
+			coords = append(coords, fmt.Sprintf("%.1f,%.1f", xs.pos(p.X), ys.pos(p.Y)))
+		}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="%s"><title>%s</title></polyline>`,
+			palette[i%len(palette)], strings.Join(coords, " "), html.EscapeString(s.Name))
+	}
+	legend(&b, names)
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// barChart renders bars as an inline SVG bar chart
+func barChart(bars []bar, xLabel string, yLabel string) template.HTML {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="100%%">`, chartWidth, chartHeight)
+	if len(bars) == 0 {
+		b.WriteString(`<text x="50%" y="50%" text-anchor="middle">No data</text></svg>`)
+		return template.HTML(b.String())
+	}
+	ys := scale{from: chartHeight - marginBottom, to: marginTop}
+	for _, v := range bars {
+		ys.max = math.Max(ys.max, v.Value)
+	}
+	ys.max = niceMax(ys.max)
+	xs := scale{min: 0, max: float64(len(bars)), from: marginLeft, to: chartWidth - marginRight}
+	axes(&b, xs, ys, xLabel, yLabel, nil)
+	width := (xs.to - xs.from) / float64(len(bars))
+	// only label some of the bars so that labels do not overlap
+	labelEvery := int(math.Ceil(float64(len(bars)) / 10))
+	for i, v := range bars {
+		x := xs.pos(float64(i))
+		y := ys.pos(v.Value)
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s: %s</title></rect>`,
+			x+1, y, math.Max(width-2, 1), ys.from-y, palette[0], html.EscapeString(v.Label), formatNumber(v.Value))
+		if i%labelEvery == 0 {
+			fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle" font-size="10">%s</text>`, x+width/2, chartHeight-marginBottom+14, html.EscapeString(v.Label))
+		}
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
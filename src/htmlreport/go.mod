@@ -0,0 +1,3 @@
+module http-bomber/htmlreport
+
+go 1.16
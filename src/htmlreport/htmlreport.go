@@ -0,0 +1,352 @@
+package htmlreport
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"http-bomber/exporter"
+	"http-bomber/httptest"
+	"http-bomber/logging"
+	"http-bomber/stats"
+)
+
+// Config holds configuration for the HTML report
+type Config struct {
+	Path string
+}
+
+// default path when enabled with -export html
+const defaultPath = "http-bomber-report.html"
+
+// Module ...
+type Module struct {
+	Config    Config
+	WaitGroup *sync.WaitGroup
+	Logger    *logging.Logger
+	Debug     bool
+}
+
+func init() {
+	exporter.Register(&Module{})
+}
+
+// Name ...
+func (mod *Module) Name() string {
+	return "html"
+}
+
+// RegisterFlags ...
+func (mod *Module) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&mod.Config.Path, "html-report", "", "Write a self-contained HTML report into a file")
+}
+
+// Enabled ...
+func (mod *Module) Enabled() bool {
+	return mod.Config.Path != ""
+}
+
+// Enable ...
+func (mod *Module) Enable() {
+	if mod.Config.Path == "" {
+		mod.Config.Path = defaultPath
+	}
+}
+
+// Init ...
+func (mod *Module) Init(wg *sync.WaitGroup, logger *logging.Logger, debug bool) {
+	mod.WaitGroup = wg
+	mod.Logger = logger
+	mod.Debug = debug
+}
+
+// ipRow holds statistics of one destination IP of an URL
+type ipRow struct {
+	URL     string
+	IP      string
+	Summary *stats.Summary
+}
+
+// page holds everything rendered into the template
+type page struct {
+	Generated    time.Time
+	Report       *stats.Report
+	Settings     []exporter.Setting
+	IPs          []ipRow
+	LatencyChart template.HTML
+	RPSChart     template.HTML
+	HistChart    template.HTML
+	StatusChart  template.HTML
+	IPChart      template.HTML
+}
+
+// Export generates the report of a run
+func (mod *Module) Export(run *exporter.Run) error {
+	mod.Logger.Info("Generating HTML report")
+	if err := mod.Generate(mod.Config.Path, run.Results, run.Report, run.Config); err != nil {
+		return fmt.Errorf("could not generate HTML report: %v", err)
+	}
+	mod.Logger.Info(fmt.Sprintf("HTML report written to %s", mod.Config.Path))
+	return nil
+}
+
+// Generate writes a self-contained HTML report into a file
+func (mod *Module) Generate(path string, results [][]*httptest.Result, report *stats.Report, settings []exporter.Setting) error {
+	p := page{
+		Generated: time.Now(),
+		Report:    report,
+		Settings:  settings,
+	}
+	start := report.Overall.Start
+	p.LatencyChart = lineChart(latencySeries(results, start), "Time since start (s)", "Latency (ms)", formatSeconds)
+	p.RPSChart = lineChart(rpsSeries(results, start), "Time since start (s)", "Requests per second", formatSeconds)
+	p.StatusChart = lineChart(statusSeries(results, start), "Time since start (s)", "Responses per second", formatSeconds)
+	p.HistChart = barChart(latencyBars(results, report.Overall), "Latency (ms)", "Requests")
+	p.IPs = ipRows(results)
+	var ipBars []bar
+	for _, row := range p.IPs {
+		ipBars = append(ipBars, bar{Label: row.IP, Value: float64(row.Summary.Requests)})
+	}
+	p.IPChart = barChart(ipBars, "Destination IP", "Requests")
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return reportTemplate.Execute(f, p)
+}
+
+func formatSeconds(v float64) string {
+	return strconv.FormatFloat(v, 'f', 0, 64)
+}
+
+// per second buckets of a resultset
+func perSecond(resultSet []*httptest.Result, start time.Time) map[int][]*httptest.Result {
+	buckets := make(map[int][]*httptest.Result)
+	for _, r := range resultSet {
+		second := int(r.ReqStartTime.Sub(start) / time.Second)
+		buckets[second] = append(buckets[second], r)
+	}
+	return buckets
+}
+
+// sorted keys of per second buckets
+func seconds(buckets map[int][]*httptest.Result) []int {
+	keys := make([]int, 0, len(buckets))
+	for k := range buckets {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// p50 and p95 latency per second for each URL
+func latencySeries(results [][]*httptest.Result, start time.Time) []series {
+	var data []series
+	for _, resultSet := range results {
+		if len(resultSet) == 0 {
+			continue
+		}
+		p50 := series{Name: resultSet[0].URL + " p50"}
+		p95 := series{Name: resultSet[0].URL + " p95"}
+		buckets := perSecond(resultSet, start)
+		for _, second := range seconds(buckets) {
+			s := stats.Summarize(resultSet[0].URL, buckets[second])
+			if s.Histogram.Count() == 0 {
+				continue
+			}
+			p50.Points = append(p50.Points, point{X: float64(second), Y: milliseconds(s.Latency.P50)})
+			p95.Points = append(p95.Points, point{X: float64(second), Y: milliseconds(s.Latency.P95)})
+		}
+		data = append(data, p50, p95)
+	}
+	return data
+}
+
+// requests per second for each URL
+func rpsSeries(results [][]*httptest.Result, start time.Time) []series {
+	var data []series
+	for _, resultSet := range results {
+		if len(resultSet) == 0 {
+			continue
+		}
+		s := series{Name: resultSet[0].URL}
+		buckets := perSecond(resultSet, start)
+		for _, second := range seconds(buckets) {
+			s.Points = append(s.Points, point{X: float64(second), Y: float64(len(buckets[second]))})
+		}
+		data = append(data, s)
+	}
+	return data
+}
+
+// responses per second for each status code (and failed requests) of all URLs
+func statusSeries(results [][]*httptest.Result, start time.Time) []series {
+	counts := make(map[string]map[int]int)
+	last := 0
+	for _, resultSet := range results {
+		for _, r := range resultSet {
+			key := strconv.Itoa(r.RespStatusCode)
+			if r.Error != "" {
+				key = "error"
+			}
+			if counts[key] == nil {
+				counts[key] = make(map[int]int)
+			}
+			second := int(r.ReqStartTime.Sub(start) / time.Second)
+			counts[key][second]++
+			if second > last {
+				last = second
+			}
+		}
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var data []series
+	for _, k := range keys {
+		s := series{Name: k}
+		for second := 0; second <= last; second++ {
+			s.Points = append(s.Points, point{X: float64(second), Y: float64(counts[k][second])})
+		}
+		data = append(data, s)
+	}
+	return data
+}
+
+// number of bins in the latency histogram
+const histogramBins = 40
+
+// latency distribution of all URLs, values over p99.9 are counted in the last bin
+func latencyBars(results [][]*httptest.Result, overall *stats.Summary) []bar {
+	upper := milliseconds(overall.Latency.P999)
+	if upper <= 0 {
+		return nil
+	}
+	width := upper / histogramBins
+	counts := make([]int, histogramBins)
+	for _, resultSet := range results {
+		for _, r := range resultSet {
+			if r.Error != "" {
+				continue
+			}
+			bin := int(milliseconds(r.ReqRoundTrip) / width)
+			if bin >= histogramBins {
+				bin = histogramBins - 1
+			}
+			counts[bin]++
+		}
+	}
+	bars := make([]bar, histogramBins)
+	for i, c := range counts {
+		bars[i] = bar{Label: strconv.FormatFloat(math.Round(float64(i)*width*100)/100, 'f', -1, 64), Value: float64(c)}
+	}
+	bars[histogramBins-1].Label = "≥" + bars[histogramBins-1].Label
+	return bars
+}
+
+// statistics per URL and destination IP
+func ipRows(results [][]*httptest.Result) []ipRow {
+	var rows []ipRow
+	for _, resultSet := range results {
+		byIP := make(map[string][]*httptest.Result)
+		for _, r := range resultSet {
+			ip := r.DestinationIP
+			if ip == "" {
+				ip = "(no connection)"
+			}
+			byIP[ip] = append(byIP[ip], r)
+		}
+		ips := make([]string, 0, len(byIP))
+		for ip := range byIP {
+			ips = append(ips, ip)
+		}
+		sort.Strings(ips)
+		for _, ip := range ips {
+			rows = append(rows, ipRow{URL: byIP[ip][0].URL, IP: ip, Summary: stats.Summarize(byIP[ip][0].URL, byIP[ip])})
+		}
+	}
+	return rows
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"ms": func(d time.Duration) string {
+		return strconv.FormatFloat(milliseconds(d), 'f', 2, 64)
+	},
+	"percent": func(v float64) string {
+		return strconv.FormatFloat(v*100, 'f', 2, 64)
+	},
+	"statusCodes": stats.FormatStatusCodes,
+	"errorKinds":  stats.FormatErrorKinds,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>HTTP Bomber report</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em auto; max-width: 1000px; color: #222; }
+h1 { font-size: 1.6em; }
+h2 { font-size: 1.2em; margin-top: 2em; border-bottom: 1px solid #ddd; padding-bottom: .2em; }
+table { border-collapse: collapse; width: 100%; font-size: .9em; }
+th, td { text-align: left; padding: .3em .6em; border-bottom: 1px solid #eee; }
+th { background: #f5f5f5; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+.muted { color: #777; }
+</style>
+</head>
+<body>
+<h1>HTTP Bomber report</h1>
+<p class="muted">Run {{.Report.Overall.Start.Format "2006-01-02 15:04:05 MST"}} &ndash; {{.Report.Overall.End.Format "15:04:05"}}, generated {{.Generated.Format "2006-01-02 15:04:05 MST"}}</p>
+
+<h2>Summary</h2>
+<table>
+<tr><th>URL</th><th>Requests</th><th>RPS</th><th>Errors</th><th>Min</th><th>Mean</th><th>p50</th><th>p90</th><th>p95</th><th>p99</th><th>p99.9</th><th>Max</th></tr>
+{{range .Report.URLs}}<tr><td>{{.URL}}</td><td class="num">{{.Requests}}</td><td class="num">{{printf "%.2f" .RPS}}</td><td class="num">{{.Errors}} ({{percent .ErrorRate}}%)</td><td class="num">{{ms .Latency.Min}}</td><td class="num">{{ms .Latency.Mean}}</td><td class="num">{{ms .Latency.P50}}</td><td class="num">{{ms .Latency.P90}}</td><td class="num">{{ms .Latency.P95}}</td><td class="num">{{ms .Latency.P99}}</td><td class="num">{{ms .Latency.P999}}</td><td class="num">{{ms .Latency.Max}}</td></tr>
+{{end}}{{with .Report.Overall}}<tr><th>All URLs</th><th class="num">{{.Requests}}</th><th class="num">{{printf "%.2f" .RPS}}</th><th class="num">{{.Errors}} ({{percent .ErrorRate}}%)</th><th class="num">{{ms .Latency.Min}}</th><th class="num">{{ms .Latency.Mean}}</th><th class="num">{{ms .Latency.P50}}</th><th class="num">{{ms .Latency.P90}}</th><th class="num">{{ms .Latency.P95}}</th><th class="num">{{ms .Latency.P99}}</th><th class="num">{{ms .Latency.P999}}</th><th class="num">{{ms .Latency.Max}}</th></tr>{{end}}
+</table>
+<p class="muted">Latencies in milliseconds.</p>
+<table>
+<tr><th>URL</th><th>Status codes</th><th>Errors</th></tr>
+{{range .Report.URLs}}<tr><td>{{.URL}}</td><td>{{statusCodes .StatusCodes}}</td><td>{{errorKinds .ErrorKinds}}</td></tr>
+{{end}}</table>
+
+<h2>Latency over time</h2>
+{{.LatencyChart}}
+
+<h2>Requests per second</h2>
+{{.RPSChart}}
+
+<h2>Latency distribution</h2>
+{{.HistChart}}
+
+<h2>Status codes over time</h2>
+{{.StatusChart}}
+
+<h2>Destination IPs</h2>
+{{.IPChart}}
+<table>
+<tr><th>URL</th><th>IP</th><th>Requests</th><th>Errors</th><th>Mean (ms)</th><th>p95 (ms)</th><th>Max (ms)</th></tr>
+{{range .IPs}}<tr><td>{{.URL}}</td><td>{{.IP}}</td><td class="num">{{.Summary.Requests}}</td><td class="num">{{.Summary.Errors}}</td><td class="num">{{ms .Summary.Latency.Mean}}</td><td class="num">{{ms .Summary.Latency.P95}}</td><td class="num">{{ms .Summary.Latency.Max}}</td></tr>
+{{end}}</table>
+
+<h2>Run configuration</h2>
+<table>
+<tr><th>Option</th><th>Value</th></tr>
+{{range .Settings}}<tr><td>-{{.Name}}</td><td>{{.Value}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
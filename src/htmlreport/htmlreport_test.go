@@ -0,0 +1,72 @@
+package htmlreport
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"http-bomber/exporter"
+	"http-bomber/httptest"
+	"http-bomber/stats"
+)
+
+func TestGenerate(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var results []*httptest.Result
+	for i := 0; i < 6; i++ {
+		r := &httptest.Result{
+			URL:            "http://example.org/<a>",
+			RespStatusCode: 200,
+			ReqRoundTrip:   time.Duration(i+1) * 10 * time.Millisecond,
+			ReqStartTime:   start.Add(time.Duration(i) * 500 * time.Millisecond),
+			DestinationIP:  "192.0.2.1",
+		}
+		switch i {
+		case 4:
+			r.RespStatusCode = 503
+		case 5:
+			r.RespStatusCode = 0
+			r.Error = "dial tcp: i/o timeout"
+			r.ErrorKind = "timeout"
+			r.DestinationIP = ""
+		}
+		r.ReqEndTime = r.ReqStartTime.Add(r.ReqRoundTrip)
+		results = append(results, r)
+	}
+	all := [][]*httptest.Result{results}
+	report := stats.Compute(all)
+	settings := []exporter.Setting{{Name: "url", Value: "http://example.org/<a>"}, {Name: "elastic-api-key", Value: exporter.Masked}}
+
+	path := filepath.Join(t.TempDir(), "report.html")
+	mod := &Module{}
+	if err := mod.Generate(path, all, report, settings); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(data)
+	for _, want := range []string{
+		"<!DOCTYPE html>",
+		// escaped by the template
+		"http://example.org/&lt;a&gt;",
+		"<td>200:4 503:1</td>",
+		"<td>http_5xx:1 timeout:1</td>",
+		"<td>192.0.2.1</td>",
+		"<td>-elastic-api-key</td><td>***</td>",
+		"<svg",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("report does not contain %q", want)
+		}
+	}
+	if strings.Contains(html, "<a>") {
+		t.Error("the URL is not escaped")
+	}
+	if !strings.HasSuffix(strings.TrimSpace(html), "</html>") {
+		t.Error("the report is incomplete")
+	}
+}
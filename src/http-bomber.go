@@ -2,24 +2,34 @@ package main
 
 // Imports
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
-	"http-bomber/elasticsearch"
+	"http-bomber/exporter"
 	"http-bomber/httptest"
-	"http-bomber/ipstack"
+	"http-bomber/live"
 	"http-bomber/logging"
+	"http-bomber/stats"
+	"http-bomber/thresholds"
 )
 
-// MODULE GLOBALS
-var elConfig elasticsearch.Config
-var ipstackConfig ipstack.Settings
+// Exit codes
+const (
+	exitOK        = 0
+	exitToolError = 1
+	// threshold failure or regression detected by the compare command
+	exitThresholdFailure = 3
+)
 
 // GLOBALS
 
@@ -50,6 +60,16 @@ var networkStack string = "tcp"
 var tlsVerify bool = false
 var followRedirects bool = false
 var forceAttemptHTTP2 bool = false
+var reportFormat string = "table"
+var reportFile string
+var thresholdSpec string
+var junitReportPath string
+var liveView bool
+var exportNames string
+var enrichNames string
+
+// parsed thresholds
+var checks []*thresholds.Threshold
 
 // Configure application logging
 func configLogging() {
@@ -71,11 +91,93 @@ func parseHeadersFlag(headers *string, parsedHeaders *http.Header) {
 	}
 }
 
+// Write the end-of-run statistics report as per flags
+func writeReport(report *stats.Report) {
+	if reportFormat == "none" {
+		return
+	}
+	out := os.Stdout
+	if reportFile != "" {
+		f, err := os.Create(reportFile)
+		if err != nil {
+			logger.Error(fmt.Sprint("Could not create report file: ", err))
+			return
+		}
+		defer f.Close()
+		out = f
+	}
+	var err error
+	switch reportFormat {
+	case "json":
+		err = report.WriteJSON(out)
+	case "table":
+		err = report.WriteTable(out)
+	default:
+		logger.Error(fmt.Sprintf("Unknown report format %q", reportFormat))
+		return
+	}
+	if err != nil {
+		logger.Error(fmt.Sprint("Could not write report: ", err))
+	}
+}
+
+// Log threshold outcomes and write them to the JUnit report, returns false on I/O errors
+func evaluateThresholds(outcomes []*thresholds.Outcome) bool {
+	for _, o := range outcomes {
+		if o.Passed {
+			logger.Info(fmt.Sprint("Threshold passed: ", o.Message))
+		} else {
+			logger.Error(fmt.Sprint("Threshold failed: ", o.Message))
+		}
+	}
+	if junitReportPath == "" {
+		return true
+	}
+	f, err := os.Create(junitReportPath)
+	if err != nil {
+		logger.Critical(fmt.Sprint("Could not create JUnit report: ", err))
+		return false
+	}
+	defer f.Close()
+	if err := thresholds.WriteJUnit(f, outcomes); err != nil {
+		logger.Critical(fmt.Sprint("Could not write JUnit report: ", err))
+		return false
+	}
+	return true
+}
+
+// Stop the tests on SIGINT/SIGTERM so that results still get reported and exported.
+// A second signal terminates the program right away.
+func stopOnSignal() <-chan struct{} {
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		signal.Reset(os.Interrupt, syscall.SIGTERM)
+		logger.Info(fmt.Sprintf("Received %s, stopping tests", sig))
+		close(stop)
+	}()
+	return stop
+}
+
+// Make an unique ID for the run
+func newRunID() string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405Z"), hex.EncodeToString(suffix))
+}
+
 // Initial operations
 func init() {
 	// configure logging
 	configLogging()
 
+	// Subcommands parse their own flags
+	if _, found := subcommand(); found {
+		return
+	}
+
 	// READ FLAGS
 	// Version info
 	flag.BoolVar(&showVersion, "version", false, "Show version info")
@@ -85,24 +187,23 @@ func init() {
 	flag.StringVar(&networkStack, "n", "tcp4", "Network stack")
 	flag.StringVar(&url, "url", "http://localhost", "URL to test. Add multiple URLs separated by a comma (no whitespaces in between)")
 	flag.StringVar(&hdrs, "headers", fmt.Sprintf("X-Tested-With:http-bomber/%s", AppVersion), "Additional headers example-> Host:localhost,X-Custom-Header:helloworld")
-	flag.IntVar(&duration, "duration", 10, "Test duration in seconds")
+	flag.IntVar(&duration, "duration", 10, "Test duration in seconds (0 runs continuously until interrupted)")
 	flag.IntVar(&timeout, "timeout", 5, "Connection timeout in seconds")
 	flag.IntVar(&interval, "interval", 1000, "Request interval in milliseconds")
 	flag.BoolVar(&tlsVerify, "tls-skip-verify", false, "Skip TLS certificate validation.")
 	flag.BoolVar(&followRedirects, "follow-redirects", false, "Follow HTTP Redirects.")
 	flag.BoolVar(&forceAttemptHTTP2, "force-try-http2", false, "Force attempt HTTP2.")
+	flag.StringVar(&reportFormat, "report", "table", "End-of-run statistics report format <table|json|none>")
+	flag.StringVar(&reportFile, "report-file", "", "Write the end-of-run statistics report to a file instead of stdout")
+	flag.StringVar(&thresholdSpec, "thresholds", "", "Pass/fail thresholds separated by a comma, optionally per URL example-> p95<300ms,error_rate<1%,rps>100@http://example.org")
+	flag.StringVar(&junitReportPath, "junit-report", "", "Write threshold outcomes to a JUnit XML file")
+	flag.BoolVar(&liveView, "live", false, "Show a live view of the run (periodic progress lines when stdout is not a terminal)")
 
 	// MODULE FLAGS
-	// Elasticsearch
-	flag.StringVar(&elConfig.URL, "elastic-url", "http://localhost:9200", "Elastic search URL")
-	flag.StringVar(&elConfig.IndexName, "elastic-index", "testdata", "Elasticsearch index name")
-	flag.BoolVar(&elConfig.Export, "elastic-export", false, "Export data to elasticsearch")
-	flag.BoolVar(&elConfig.ExportToFile, "elastic-export-to-file", false, "Export data to file in elasticsearch format")
-	flag.StringVar(&elConfig.ExportFilePath, "elastic-export-filepath", "/tmp/http-bomber-results.json", "Specify filepath for Elasticsearch export")
-	// IPStack
-	flag.BoolVar(&ipstackConfig.UseIPStack, "ipstack", false, "Use IPStack for example for getting geolocation details")
-	flag.StringVar(&ipstackConfig.APIKey, "ipstack-apikey", "1234", "Your personal IPStack API key")
-	flag.IntVar(&ipstackConfig.Timeout, "ipstack-timeout", 3, "IPStack connect timeout")
+	flag.StringVar(&exportNames, "export", "", "Exporters to turn on by name, separated by a comma example-> es,html")
+	flag.StringVar(&enrichNames, "enrich", "", "Enrichers to turn on by name, separated by a comma example-> ipstack")
+	// Modules add their own flags
+	exporter.RegisterFlags(flag.CommandLine)
 
 	// Parse flags
 	flag.Parse()
@@ -117,20 +218,59 @@ func init() {
 	headers.Add("User-Agent", fmt.Sprintf("http-bomber/%s", AppVersion))
 	parseHeadersFlag(&hdrs, &headers)
 
+	// Turn on modules given by name
+	if err := exporter.EnableExporters(exportNames); err != nil {
+		logger.Critical(fmt.Sprint("Invalid -export: ", err))
+		os.Exit(exitToolError)
+	}
+	if err := exporter.EnableEnrichers(enrichNames); err != nil {
+		logger.Critical(fmt.Sprint("Invalid -enrich: ", err))
+		os.Exit(exitToolError)
+	}
+
+	// Parse thresholds
+	var err error
+	checks, err = thresholds.Parse(thresholdSpec)
+	if err != nil {
+		logger.Critical(fmt.Sprint("Invalid thresholds: ", err))
+		os.Exit(exitToolError)
+	}
 }
 
 func main() {
 
+	// Run subcommand instead of a test
+	if cmd, found := subcommand(); found {
+		os.Exit(cmd(os.Args[2:]))
+	}
+
 	// Log program start
 	logger.Info(fmt.Sprint("Starting HTTP Bomber ", AppVersion))
 
 	// Get URLs
 	urls := strings.Split(url, ",")
+	run := &exporter.Run{ID: newRunID(), Version: AppVersion, Start: time.Now(), Config: exporter.Settings(flag.CommandLine)}
+	stop := stopOnSignal()
+
+	// Modules are initialized before the tests so that streaming exporters get every result
+	exporter.Init(&wg, &logger, debug)
+	if err := exporter.Begin(run); err != nil {
+		logger.Critical(fmt.Sprint("Could not start exporter: ", err))
+		os.Exit(exitToolError)
+	}
+	streamers := exporter.Streamers()
 	// Make channel for results
 	exportedDataChan = make(chan []*httptest.Result, len(urls))
 	// Set the number of wait groups based on the quantity of URLs provided by the user
 	wg.Add(len(urls))
 
+	// Live view
+	var dashboard *live.Dashboard
+	if liveView {
+		dashboard = live.NewDashboard(urls, time.Duration(duration)*time.Second)
+		dashboard.Start()
+	}
+
 	// Goroutines for each url provided
 	for i := 0; i < len(urls); i++ {
 		logger.Info(fmt.Sprintf("Starting test %v (URL: %s)", i+1, urls[i]))
@@ -146,31 +286,53 @@ func main() {
 		settings.Headers = headers
 		test := httptest.Test{}
 		test.Init(&settings, &exportedDataChan, &wg, &logger, debug)
+		test.Stop = stop
+		exporter.ConfigureTest(&test)
+		run.Settings = append(run.Settings, test.Settings)
+		if dashboard != nil {
+			test.AddObserver(dashboard)
+		}
+		for _, s := range streamers {
+			test.AddObserver(s)
+		}
 		go test.Start()
 	}
 
 	// Wait for tests
 	wg.Wait()
+	if dashboard != nil {
+		dashboard.Stop()
+	}
 
 	// Get results from channel
-	var results [][]*httptest.Result
 	for i := 0; i < len(urls); i++ {
 		incomingData := <-exportedDataChan
-		results = append(results, incomingData)
+		run.Results = append(run.Results, incomingData)
 	}
+	run.End = time.Now()
 
-	// EXPORTING TO MODULES
+	// Statistics report
+	run.Report = stats.Compute(run.Results)
+	writeReport(run.Report)
 
-	// Module Elasticsearch (define elasticsearch module first!!)
-	elasticSearchModule := elasticsearch.Module{}
-	elasticSearchModule.Init(&wg, &logger, debug)
+	// Thresholds
+	run.Outcomes = thresholds.Evaluate(checks, run.Report)
+	junitWritten := evaluateThresholds(run.Outcomes)
 
-	// Module IPStack
-	ipstackModule := ipstack.Module{}
-	ipstackModule.Init(&wg, &logger, debug)
-	ipstackModule.Start(&ipstackConfig, results, &elasticSearchModule, &elConfig)
-
-	// Run Elastic module last
-	elasticSearchModule.Start(&elConfig, results)
+	// EXPORTING TO MODULES
+	// Enrichers run first so that exporters get the enriched results. The results are
+	// exported also if the JUnit report could not be written.
+	exportErr := exporter.Process(run, &logger)
+	if !junitWritten {
+		os.Exit(exitToolError)
+	}
 
+	// Fail the run if thresholds were not met
+	if thresholds.Failed(run.Outcomes) {
+		os.Exit(exitThresholdFailure)
+	}
+	if exportErr != nil {
+		os.Exit(exitToolError)
+	}
+	os.Exit(exitOK)
 }
@@ -1,8 +1,12 @@
 package httptest
 
 import (
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -26,6 +30,10 @@ type Settings struct {
 	SkipTLSVerify     bool
 	FollowRedirects   bool
 	ForceAttemptHTTP2 bool
+	// TraceContext injects a W3C traceparent header into each request
+	TraceContext bool
+	// CaptureBody keeps up to this many bytes of each response body in the result (0 keeps none)
+	CaptureBody int
 }
 
 // Result holds information on one request
@@ -37,12 +45,36 @@ type Result struct {
 	DestinationIP   string                 `json:"destination_ip"`
 	DestinationPort int                    `json:"destination_port"`
 	RespStatusCode  int                    `json:"resp_status_code"`
+	RespProto       string                 `json:"resp_proto,omitempty"`
+	RespBodySize    int                    `json:"resp_body_size"`
+	RespBody        []byte                 `json:"-"`
 	ReqStartTime    time.Time              `json:"req_start_time"`
 	ReqEndTime      time.Time              `json:"req_end_time"`
 	ReqRoundTrip    time.Duration          `json:"req_round_trip"`
+	Error           string                 `json:"error,omitempty"`
+	ErrorKind       string                 `json:"error_kind,omitempty"`
+	ConnReused      bool                   `json:"conn_reused"`
+	Phases          Phases                 `json:"phases"`
+	TraceID         string                 `json:"trace_id,omitempty"`
+	SpanID          string                 `json:"span_id,omitempty"`
 	Modules         map[string]interface{} `json:"modules"`
 }
 
+// Phases holds the durations of the phases of a request (from net/http/httptrace).
+// Phases which did not happen (e.g. DNS and connect on a reused connection) are zero.
+type Phases struct {
+	DNS      time.Duration `json:"dns"`
+	Connect  time.Duration `json:"connect"`
+	TLS      time.Duration `json:"tls"`
+	Wait     time.Duration `json:"wait"`
+	Transfer time.Duration `json:"transfer"`
+}
+
+// Failed returns true if the request did not get a response or the server answered with a 5xx status
+func (r *Result) Failed() bool {
+	return r.Error != "" || r.RespStatusCode >= 500
+}
+
 // MakeModulesMap initializes the Modules map. Should be executed by custom modules before trying to add data
 func (r *Result) MakeModulesMap() {
 	if r.Modules == nil {
@@ -50,6 +82,13 @@ func (r *Result) MakeModulesMap() {
 	}
 }
 
+// Observer gets notified of each request while a test is running
+// RequestDone is called for every RequestStarted, with a nil result if the request could not be formed
+type Observer interface {
+	RequestStarted(url string)
+	RequestDone(url string, result *Result)
+}
+
 // Test ...
 type Test struct {
 	Settings         Settings
@@ -57,6 +96,9 @@ type Test struct {
 	WaitGroup        *sync.WaitGroup
 	Debug            bool
 	Logger           *logging.Logger
+	Observers        []Observer
+	// Stop ends the test early when closed
+	Stop <-chan struct{}
 }
 
 // Init ...
@@ -68,6 +110,11 @@ func (test *Test) Init(settings *Settings, exportedDataChan *chan []*Result, wg
 	test.Logger = logger
 }
 
+// AddObserver registers an observer which is notified of each request
+func (test *Test) AddObserver(observer Observer) {
+	test.Observers = append(test.Observers, observer)
+}
+
 // Start runs the test
 // results are appended in a resultset ([]Result) which is then passed on to the channel
 func (test *Test) Start() {
@@ -99,16 +146,28 @@ func (test *Test) Start() {
 		}
 	}
 
+	// duration of zero (or less) runs the test until stopped
 	startTime := time.Now()
+loop:
 	for {
-		if time.Since(startTime) >= test.Settings.Duration*time.Second {
+		if test.Settings.Duration > 0 && time.Since(startTime) >= test.Settings.Duration*time.Second {
 			break
 		}
+		for _, o := range test.Observers {
+			o.RequestStarted(test.Settings.URL)
+		}
 		result := test.makeRequest(&client)
 		if result != nil {
 			resultSet = append(resultSet, result)
 		}
-		time.Sleep(test.Settings.Interval * time.Millisecond)
+		for _, o := range test.Observers {
+			o.RequestDone(test.Settings.URL, result)
+		}
+		select {
+		case <-test.Stop:
+			break loop
+		case <-time.After(test.Settings.Interval * time.Millisecond):
+		}
 	}
 	// Pass resultset to channel
 	*test.ExportedDataChan <- resultSet
@@ -128,36 +187,80 @@ func (test *Test) makeRequest(client *http.Client) *Result {
 	}
 
 	var rmtaddr string
+	var connReused bool
+	var dnsStart, connectStart, tlsStart, wroteRequest, firstByte time.Time
+	var phases Phases
 
 	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:  func(httptrace.DNSDoneInfo) { phases.DNS = time.Since(dnsStart) },
+		ConnectStart: func(network, addr string) {
+			if connectStart.IsZero() {
+				connectStart = time.Now()
+			}
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil {
+				phases.Connect = time.Since(connectStart)
+			}
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { phases.TLS = time.Since(tlsStart) },
 		GotConn: func(connInfo httptrace.GotConnInfo) {
 			rmtaddr = connInfo.Conn.RemoteAddr().String()
+			connReused = connInfo.Reused
 		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { wroteRequest = time.Now() },
+		GotFirstResponseByte: func() { firstByte = time.Now() },
 	}
 
 	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
 	req.Header = test.Settings.Headers
-	r := &Result{URL: test.Settings.URL, ReqStartTime: time.Now()}
+	var traceID, spanID string
+	if test.Settings.TraceContext {
+		// the headers are shared by all requests of the test
+		req.Header = req.Header.Clone()
+		if req.Header == nil {
+			req.Header = make(http.Header)
+		}
+		traceID, spanID = newTraceContext()
+		req.Header.Set("traceparent", fmt.Sprintf("00-%s-%s-01", traceID, spanID))
+	}
+	r := &Result{URL: test.Settings.URL, ReqStartTime: time.Now(), ReqHeaders: req.Header, TraceID: traceID, SpanID: spanID}
 	resp, err := client.Do(req)
 	if err != nil {
 		if test.Debug {
 			test.Logger.Debug(fmt.Sprint("Failed request: ", err))
 		}
-		return nil
+		return test.failedResult(r, err)
 	}
 	defer resp.Body.Close()
-	_, err = ioutil.ReadAll(resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		if test.Debug {
 			test.Logger.Debug(fmt.Sprint(err))
 		}
-		return nil
+		return test.failedResult(r, err)
 	}
 	r.ReqEndTime = time.Now()
 	r.ReqRoundTrip = r.ReqEndTime.Sub(r.ReqStartTime)
+	if !wroteRequest.IsZero() && !firstByte.IsZero() {
+		phases.Wait = firstByte.Sub(wroteRequest)
+		phases.Transfer = r.ReqEndTime.Sub(firstByte)
+	}
+	r.Phases = phases
+	r.ConnReused = connReused
 	r.RespStatusCode = resp.StatusCode
-	r.ReqHeaders = req.Header
+	r.RespProto = resp.Proto
 	r.RespHeaders = resp.Header
+	r.RespBodySize = len(body)
+	if test.Settings.CaptureBody > 0 {
+		if len(body) > test.Settings.CaptureBody {
+			body = body[:test.Settings.CaptureBody]
+		}
+		// copy so that the rest of a large body can be freed
+		r.RespBody = append([]byte(nil), body...)
+	}
 	// separate IP and port
 	dst := strings.Split(rmtaddr, ":")
 	dstPort, _ := strconv.Atoi(dst[len(dst)-1])
@@ -171,3 +274,41 @@ func (test *Test) makeRequest(client *http.Client) *Result {
 	r.Timestamp = time.Now()
 	return r
 }
+
+// newTraceContext returns a random trace ID and span ID (hex encoded)
+func newTraceContext() (string, string) {
+	var ids [24]byte
+	rand.Read(ids[:])
+	return hex.EncodeToString(ids[:16]), hex.EncodeToString(ids[16:])
+}
+
+// Fill in the error details of a request which did not get a (complete) response
+func (test *Test) failedResult(r *Result, err error) *Result {
+	r.ReqEndTime = time.Now()
+	r.ReqRoundTrip = r.ReqEndTime.Sub(r.ReqStartTime)
+	r.Error = err.Error()
+	r.ErrorKind = ErrorKind(err)
+	r.Timestamp = r.ReqEndTime
+	return r
+}
+
+// ErrorKind classifies a request error into a short, label friendly category
+func ErrorKind(err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return "connect"
+	case strings.Contains(err.Error(), "tls:") || strings.Contains(err.Error(), "x509:"):
+		return "tls"
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,3 @@
+module http-bomber/influxdb
+
+go 1.16
@@ -0,0 +1,241 @@
+package influxdb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"http-bomber/exporter"
+	"http-bomber/httptest"
+	"http-bomber/logging"
+)
+
+// Config holds configuration for exporting to InfluxDB
+type Config struct {
+	URL string
+	// APIVersion is 1 (/write) or 2 (/api/v2/write)
+	APIVersion  int
+	Database    string
+	Retention   string
+	Username    string
+	Password    string
+	Org         string
+	Bucket      string
+	Token       string
+	Measurement string
+	BatchSize   int
+	Timeout     time.Duration
+	FilePath    string
+}
+
+// default URL when enabled with -export influx
+const defaultURL = "http://localhost:8086"
+
+// Module ...
+type Module struct {
+	Config    Config
+	WaitGroup *sync.WaitGroup
+	Logger    *logging.Logger
+	Debug     bool
+	client    http.Client
+}
+
+func init() {
+	exporter.Register(&Module{})
+}
+
+// Name ...
+func (mod *Module) Name() string {
+	return "influx"
+}
+
+// RegisterFlags ...
+func (mod *Module) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&mod.Config.URL, "influx-url", "", "Export results to InfluxDB example-> http://localhost:8086")
+	mod.Config.APIVersion = 2
+	fs.Var(apiVersionFlag{&mod.Config.APIVersion}, "influx-api", "InfluxDB write API version (1 or 2)")
+	fs.StringVar(&mod.Config.Database, "influx-db", "httpbomber", "InfluxDB database (API v1)")
+	fs.StringVar(&mod.Config.Retention, "influx-rp", "", "InfluxDB retention policy (API v1)")
+	fs.StringVar(&mod.Config.Username, "influx-username", "", "InfluxDB username (API v1)")
+	fs.StringVar(&mod.Config.Password, "influx-password", "", "InfluxDB password (API v1)")
+	fs.StringVar(&mod.Config.Org, "influx-org", "", "InfluxDB organization (API v2)")
+	fs.StringVar(&mod.Config.Bucket, "influx-bucket", "httpbomber", "InfluxDB bucket (API v2)")
+	fs.StringVar(&mod.Config.Token, "influx-token", "", "InfluxDB API token (API v2)")
+	fs.StringVar(&mod.Config.Measurement, "influx-measurement", "http_bomber", "InfluxDB measurement name")
+	fs.IntVar(&mod.Config.BatchSize, "influx-batch-size", 5000, "Number of points per InfluxDB write request")
+	fs.DurationVar(&mod.Config.Timeout, "influx-timeout", 10*time.Second, "Timeout of InfluxDB write requests")
+	fs.StringVar(&mod.Config.FilePath, "influx-file", "", "Write results in InfluxDB line protocol to a file")
+}
+
+// Enabled ...
+func (mod *Module) Enabled() bool {
+	return mod.Config.URL != "" || mod.Config.FilePath != ""
+}
+
+// Enable ...
+func (mod *Module) Enable() {
+	if mod.Config.URL == "" {
+		mod.Config.URL = defaultURL
+	}
+}
+
+// apiVersionFlag is the -influx-api flag, it rejects unsupported versions when the flags
+// are parsed so that a typo doesn't surface only after the run
+type apiVersionFlag struct {
+	value *int
+}
+
+func (f apiVersionFlag) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return strconv.Itoa(*f.value)
+}
+
+func (f apiVersionFlag) Set(s string) error {
+	version, err := strconv.Atoi(s)
+	if err != nil || (version != 1 && version != 2) {
+		return fmt.Errorf("unsupported InfluxDB API version %q (use 1 or 2)", s)
+	}
+	*f.value = version
+	return nil
+}
+
+// Init ...
+func (mod *Module) Init(wg *sync.WaitGroup, logger *logging.Logger, debug bool) {
+	mod.WaitGroup = wg
+	mod.Logger = logger
+	mod.Debug = debug
+	mod.client = http.Client{Timeout: mod.Config.Timeout}
+}
+
+// Export writes the results of a run to InfluxDB and/or a file
+func (mod *Module) Export(run *exporter.Run) error {
+	tags := map[string]string{"run_id": run.ID}
+	var errs []string
+	if mod.Config.FilePath != "" {
+		if err := mod.writeFile(tags, run.Results); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if mod.Config.URL != "" {
+		if err := mod.write(tags, run.Results); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// writeFile writes all results in line protocol to the configured file
+func (mod *Module) writeFile(tags map[string]string, results [][]*httptest.Result) error {
+	mod.Logger.Info(fmt.Sprintf("Exporting results to %s", mod.Config.FilePath))
+	f, err := os.Create(mod.Config.FilePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	if err := WriteLines(w, mod.Config.Measurement, tags, results); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// write sends all results to the write API in batches of BatchSize points
+func (mod *Module) write(tags map[string]string, results [][]*httptest.Result) error {
+	endpoint, err := mod.writeURL()
+	if err != nil {
+		return err
+	}
+	mod.Logger.Info(fmt.Sprintf("Exporting results to InfluxDB at %s", mod.Config.URL))
+	var batch bytes.Buffer
+	points, written := 0, 0
+	for _, resultSet := range results {
+		for _, r := range resultSet {
+			batch.WriteString(Line(mod.Config.Measurement, tags, r))
+			batch.WriteByte('\n')
+			points++
+			if points >= mod.Config.BatchSize {
+				if err := mod.post(endpoint, batch.Bytes()); err != nil {
+					return fmt.Errorf("InfluxDB write failed after %d points: %v", written, err)
+				}
+				written += points
+				points = 0
+				batch.Reset()
+			}
+		}
+	}
+	if points > 0 {
+		if err := mod.post(endpoint, batch.Bytes()); err != nil {
+			return fmt.Errorf("InfluxDB write failed after %d points: %v", written, err)
+		}
+		written += points
+	}
+	if mod.Debug {
+		mod.Logger.Debug(fmt.Sprintf("Wrote %d points to InfluxDB", written))
+	}
+	return nil
+}
+
+// writeURL returns the write endpoint of the configured API version
+func (mod *Module) writeURL() (string, error) {
+	base := strings.TrimSuffix(mod.Config.URL, "/")
+	query := url.Values{}
+	switch mod.Config.APIVersion {
+	case 1:
+		query.Set("db", mod.Config.Database)
+		if mod.Config.Retention != "" {
+			query.Set("rp", mod.Config.Retention)
+		}
+		query.Set("precision", "ns")
+		return base + "/write?" + query.Encode(), nil
+	case 2:
+		query.Set("org", mod.Config.Org)
+		query.Set("bucket", mod.Config.Bucket)
+		query.Set("precision", "ns")
+		return base + "/api/v2/write?" + query.Encode(), nil
+	default:
+		return "", fmt.Errorf("unsupported InfluxDB API version %d (use 1 or 2)", mod.Config.APIVersion)
+	}
+}
+
+// post sends one batch of points
+func (mod *Module) post(endpoint string, body []byte) error {
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if mod.Config.APIVersion == 2 && mod.Config.Token != "" {
+		req.Header.Set("Authorization", "Token "+mod.Config.Token)
+	}
+	if mod.Config.APIVersion == 1 && mod.Config.Username != "" {
+		req.SetBasicAuth(mod.Config.Username, mod.Config.Password)
+	}
+	resp, err := mod.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
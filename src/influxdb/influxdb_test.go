@@ -0,0 +1,55 @@
+package influxdb
+
+import (
+	"flag"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestAPIVersionFlag(t *testing.T) {
+	tests := []struct {
+		args    []string
+		version int
+		wantErr bool
+	}{
+		{nil, 2, false},
+		{[]string{"-influx-api", "1"}, 1, false},
+		{[]string{"-influx-api", "2"}, 2, false},
+		{[]string{"-influx-api", "3"}, 0, true},
+		{[]string{"-influx-api", "v2"}, 0, true},
+	}
+	for _, tt := range tests {
+		mod := &Module{}
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		mod.RegisterFlags(fs)
+		err := fs.Parse(tt.args)
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "use 1 or 2") {
+				t.Errorf("%v: error %v, want an unsupported version", tt.args, err)
+			}
+			continue
+		}
+		if err != nil || mod.Config.APIVersion != tt.version {
+			t.Errorf("%v: version %d (%v), want %d", tt.args, mod.Config.APIVersion, err, tt.version)
+		}
+	}
+}
+
+func TestWriteURL(t *testing.T) {
+	tests := []struct {
+		config Config
+		want   string
+	}{
+		{Config{URL: "http://influx:8086/", APIVersion: 1, Database: "perf", Retention: "week"}, "http://influx:8086/write?db=perf&precision=ns&rp=week"},
+		{Config{URL: "http://influx:8086", APIVersion: 2, Org: "my org", Bucket: "perf"}, "http://influx:8086/api/v2/write?bucket=perf&org=my+org&precision=ns"},
+	}
+	for _, tt := range tests {
+		mod := &Module{Config: tt.config}
+		got, err := mod.writeURL()
+		if err != nil || got != tt.want {
+			t.Errorf("write URL %s (%v), want %s", got, err, tt.want)
+		}
+	}
+}
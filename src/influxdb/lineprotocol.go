@@ -0,0 +1,92 @@
+package influxdb
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"http-bomber/httptest"
+)
+
+// escaping rules of the line protocol (https://docs.influxdata.com/influxdb/v2/reference/syntax/line-protocol/)
+var (
+	measurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `, "\n", `\n`)
+	tagEscaper         = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `, "\n", `\n`)
+	stringEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// Line formats one result as a line protocol point (without trailing newline).
+// URL, IP, status and error kind are tags (with additional tags such as the run ID),
+// timings are integer fields in nanoseconds.
+func Line(measurement string, tags map[string]string, r *httptest.Result) string {
+	var b strings.Builder
+	b.WriteString(measurementEscaper.Replace(measurement))
+
+	all := map[string]string{
+		"url":    r.URL,
+		"ip":     r.DestinationIP,
+		"status": strconv.Itoa(r.RespStatusCode),
+	}
+	if r.Error != "" {
+		all["status"] = "error"
+		all["error_kind"] = r.ErrorKind
+	}
+	for k, v := range tags {
+		all[k] = v
+	}
+	keys := make([]string, 0, len(all))
+	for k, v := range all {
+		// empty tag values are not allowed
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	// tags sorted by key are faster to ingest
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteString("," + tagEscaper.Replace(k) + "=" + tagEscaper.Replace(all[k]))
+	}
+
+	fields := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"round_trip", r.ReqRoundTrip},
+		{"dns", r.Phases.DNS},
+		{"connect", r.Phases.Connect},
+		{"tls", r.Phases.TLS},
+		{"wait", r.Phases.Wait},
+		{"transfer", r.Phases.Transfer},
+	}
+	for i, f := range fields {
+		if i == 0 {
+			b.WriteString(" ")
+		} else {
+			b.WriteString(",")
+		}
+		b.WriteString(f.name + "=" + strconv.FormatInt(int64(f.value), 10) + "i")
+	}
+	b.WriteString(",status_code=" + strconv.Itoa(r.RespStatusCode) + "i")
+	b.WriteString(",port=" + strconv.Itoa(r.DestinationPort) + "i")
+	b.WriteString(",conn_reused=" + strconv.FormatBool(r.ConnReused))
+	if r.Error != "" {
+		b.WriteString(`,error="` + stringEscaper.Replace(r.Error) + `"`)
+	}
+
+	b.WriteString(" " + strconv.FormatInt(r.Timestamp.UnixNano(), 10))
+	return b.String()
+}
+
+// WriteLines writes all results in line protocol, one point per line
+func WriteLines(w io.Writer, measurement string, tags map[string]string, results [][]*httptest.Result) error {
+	for _, resultSet := range results {
+		for _, r := range resultSet {
+			if _, err := io.WriteString(w, Line(measurement, tags, r)+"\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
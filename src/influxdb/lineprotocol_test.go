@@ -0,0 +1,98 @@
+package influxdb
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"http-bomber/httptest"
+)
+
+func TestEscaping(t *testing.T) {
+	tests := []struct {
+		name    string
+		escaper interface{ Replace(string) string }
+		input   string
+		want    string
+	}{
+		{"measurement", measurementEscaper, "http_bomber", "http_bomber"},
+		{"measurement with space and comma", measurementEscaper, "http bomber,v2", `http\ bomber\,v2`},
+		// equal signs and quotes need no escaping in measurements
+		{"measurement with equal sign", measurementEscaper, `a=b"c`, `a=b"c`},
+		{"tag", tagEscaper, "run-1", "run-1"},
+		{"tag with URL", tagEscaper, "http://example.org/a b?x=1,2", `http://example.org/a\ b?x\=1\,2`},
+		{"tag with newline", tagEscaper, "a\nb", `a\nb`},
+		{"string", stringEscaper, "plain text, with = and spaces", "plain text, with = and spaces"},
+		{"string with quotes", stringEscaper, `Get "http://example.org": EOF`, `Get \"http://example.org\": EOF`},
+		{"string with backslash", stringEscaper, `C:\path`, `C:\\path`},
+		{"string with newline", stringEscaper, "line 1\nline 2", `line 1\nline 2`},
+	}
+	for _, tt := range tests {
+		if got := tt.escaper.Replace(tt.input); got != tt.want {
+			t.Errorf("%s: %q escaped as %q, want %q", tt.name, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestLine(t *testing.T) {
+	timestamp := time.Unix(1700000000, 123)
+	tests := []struct {
+		name        string
+		measurement string
+		tags        map[string]string
+		result      *httptest.Result
+		want        string
+	}{
+		{
+			name:        "response",
+			measurement: "http_bomber",
+			tags:        map[string]string{"run_id": "run 1"},
+			result: &httptest.Result{
+				Timestamp: timestamp, URL: "http://example.org/a,b", DestinationIP: "192.0.2.1", DestinationPort: 443,
+				RespStatusCode: 200, ReqRoundTrip: 20 * time.Millisecond, ConnReused: true,
+				Phases: httptest.Phases{Wait: 15 * time.Millisecond, Transfer: 5 * time.Millisecond},
+			},
+			want: `http_bomber,ip=192.0.2.1,run_id=run\ 1,status=200,url=http://example.org/a\,b ` +
+				`round_trip=20000000i,dns=0i,connect=0i,tls=0i,wait=15000000i,transfer=5000000i,status_code=200i,port=443i,conn_reused=true ` +
+				`1700000000000000123`,
+		},
+		{
+			// no IP tag (empty tag values are not allowed) and the error as string field
+			name:        "error",
+			measurement: "load test",
+			result: &httptest.Result{
+				Timestamp: timestamp, URL: "http://example.org", Error: `Get "http://example.org": dial tcp: i/o timeout`, ErrorKind: "timeout",
+				ReqRoundTrip: time.Second, Phases: httptest.Phases{DNS: time.Millisecond},
+			},
+			want: `load\ test,error_kind=timeout,status=error,url=http://example.org ` +
+				`round_trip=1000000000i,dns=1000000i,connect=0i,tls=0i,wait=0i,transfer=0i,status_code=0i,port=0i,conn_reused=false,` +
+				`error="Get \"http://example.org\": dial tcp: i/o timeout" 1700000000000000123`,
+		},
+		{
+			// additional tags override the result tags
+			name:        "tag override",
+			measurement: "m",
+			tags:        map[string]string{"url": "grouped"},
+			result:      &httptest.Result{Timestamp: timestamp, URL: "http://example.org", RespStatusCode: 503},
+			want: `m,status=503,url=grouped ` +
+				`round_trip=0i,dns=0i,connect=0i,tls=0i,wait=0i,transfer=0i,status_code=503i,port=0i,conn_reused=false 1700000000000000123`,
+		},
+	}
+	for _, tt := range tests {
+		if got := Line(tt.measurement, tt.tags, tt.result); got != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteLines(t *testing.T) {
+	r := &httptest.Result{Timestamp: time.Unix(1, 0), URL: "http://a", RespStatusCode: 200}
+	var buf bytes.Buffer
+	if err := WriteLines(&buf, "m", nil, [][]*httptest.Result{{r, r}, {r}}); err != nil {
+		t.Fatal(err)
+	}
+	line := Line("m", nil, r) + "\n"
+	if got := buf.String(); got != line+line+line {
+		t.Errorf("wrote %q, want 3 lines of %q", got, line)
+	}
+}
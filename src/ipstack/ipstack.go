@@ -2,8 +2,9 @@ package ipstack
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
-	"http-bomber/elasticsearch"
+	"http-bomber/exporter"
 	"http-bomber/httptest"
 	"http-bomber/logging"
 	"io/ioutil"
@@ -14,11 +15,49 @@ import (
 
 // Module ...
 type Module struct {
+	Settings  Settings
 	WaitGroup *sync.WaitGroup
 	Logger    *logging.Logger
 	Debug     bool
 }
 
+func init() {
+	exporter.Register(&Module{})
+}
+
+// Name ...
+func (mod *Module) Name() string {
+	return "ipstack"
+}
+
+// RegisterFlags ...
+func (mod *Module) RegisterFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&mod.Settings.UseIPStack, "ipstack", false, "Use IPStack for example for getting geolocation details")
+	fs.StringVar(&mod.Settings.APIKey, "ipstack-apikey", "1234", "Your personal IPStack API key")
+	fs.IntVar(&mod.Settings.Timeout, "ipstack-timeout", 3, "IPStack connect timeout")
+}
+
+// Enabled ...
+func (mod *Module) Enabled() bool {
+	return mod.Settings.UseIPStack
+}
+
+// Enable ...
+func (mod *Module) Enable() {
+	mod.Settings.UseIPStack = true
+}
+
+// Enrich adds IPStack details of the destination IPs to the results
+func (mod *Module) Enrich(run *exporter.Run) error {
+	mod.Start(&mod.Settings, run.Results)
+	return nil
+}
+
+// FieldTypes lets exporters map the coordinates as a geo point
+func (mod *Module) FieldTypes() map[string]string {
+	return map[string]string{"modules.ipstack.LatitudeLongitude": "geo_point"}
+}
+
 // Init ...
 func (mod *Module) Init(wg *sync.WaitGroup, logger *logging.Logger, debug bool) {
 	mod.WaitGroup = wg
@@ -111,26 +150,14 @@ type Response struct {
 }
 
 // Start ...
-func (mod *Module) Start(settings *Settings, results [][]*httptest.Result, elasticModule *elasticsearch.Module, elasticConfig *elasticsearch.Config) {
+func (mod *Module) Start(settings *Settings, results [][]*httptest.Result) {
 
 	if settings.UseIPStack {
 		mod.Logger.Info("Starting IPStack module (ipstack.com)")
-		if elasticConfig.Export {
-			mapping := `{
-				"properties" : {
-				  "ipstack" : {
-					"properties": {
-					  "LatitudeLongitude" : {
-						"type" : "geo_point"
-					  }
-					}
-				}
-			  }
-			}\n`
-			elasticModule.CreateIndex(elasticConfig)
-			elasticModule.CreateIndexWithMapping(elasticConfig, &mapping)
-		}
 		for i := 0; i < len(results); i++ {
+			if len(results[i]) == 0 {
+				continue
+			}
 			if mod.Debug {
 				mod.Logger.Debug(fmt.Sprint("Getting IP information for url ", results[i][0].URL))
 			}
@@ -150,6 +177,10 @@ func (mod *Module) ParseResults(settings *Settings, resultSet []*httptest.Result
 
 	// Check if unique IP addresses are found in results and get IP info for each
 	for _, r := range resultSet {
+		// failed requests have no destination to look up
+		if r.DestinationIP == "" {
+			continue
+		}
 		r.MakeModulesMap()
 		if v, found := m[r.DestinationIP]; found {
 			r.Modules["ipstack"] = v
@@ -175,7 +206,9 @@ func (mod *Module) GetIPInfo(ipAddr string, settings *Settings) Response {
 		if mod.Debug {
 			mod.Logger.Debug(fmt.Sprint("Could not get IP information from IPStack ", err))
 		}
+		return response
 	}
+	defer resp.Body.Close()
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		if mod.Debug {
@@ -0,0 +1,3 @@
+module http-bomber/kibana
+
+go 1.16
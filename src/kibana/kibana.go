@@ -0,0 +1,245 @@
+package kibana
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed" // bundled saved objects
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// savedObjects holds the data views (index patterns), visualizations, map and dashboards,
+// one object per line. They are in the format of MinVersion, newer versions of Kibana
+// migrate them on import.
+//
+//go:embed saved_objects.ndjson
+var savedObjects []byte
+
+// MinVersion is the oldest version of Kibana which can import the saved objects
+var MinVersion = Version{7, 10, 0}
+
+// IDs of the data views of the results and of the run summaries
+const (
+	ResultsDataView = "http-bomber-results"
+	RunsDataView    = "http-bomber-runs"
+)
+
+// Version of Kibana (major, minor, patch)
+type Version [3]int
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+// Less tells if v is older than other
+func (v Version) Less(other Version) bool {
+	for i := range v {
+		if v[i] != other[i] {
+			return v[i] < other[i]
+		}
+	}
+	return false
+}
+
+// ParseVersion parses a version number such as 7.11.2 or 8.12.0-SNAPSHOT
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	s = strings.SplitN(s, "-", 2)[0]
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// Client talks to the Kibana API, optionally in a space
+type Client struct {
+	HTTP  *http.Client
+	URL   string
+	Space string
+}
+
+// ImportResult is the response of the saved objects import API
+type ImportResult struct {
+	Success      bool `json:"success"`
+	SuccessCount int  `json:"successCount"`
+	Errors       []struct {
+		ID    string `json:"id"`
+		Type  string `json:"type"`
+		Title string `json:"title"`
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	} `json:"errors"`
+}
+
+// Err returns the objects which could not be imported as an error, nil if all were imported
+func (r *ImportResult) Err() error {
+	if r.Success && len(r.Errors) == 0 {
+		return nil
+	}
+	var msgs []string
+	for _, e := range r.Errors {
+		msgs = append(msgs, fmt.Sprintf("%s %s (%s): %s %s", e.Type, e.ID, e.Title, e.Error.Type, e.Error.Message))
+	}
+	if len(msgs) == 0 {
+		return fmt.Errorf("import failed after %d objects", r.SuccessCount)
+	}
+	return fmt.Errorf("could not import %s", strings.Join(msgs, "; "))
+}
+
+// url of an API, in the space of the client
+func (c *Client) url(path string) string {
+	base := strings.TrimRight(c.URL, "/")
+	if c.Space != "" && c.Space != "default" {
+		base += "/s/" + c.Space
+	}
+	return base + path
+}
+
+func (c *Client) do(req *http.Request) ([]byte, error) {
+	// Kibana refuses API requests without this header (CSRF protection)
+	req.Header.Set("kbn-xsrf", "true")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: status %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// Version returns the version of Kibana
+func (c *Client) Version() (Version, error) {
+	req, err := http.NewRequest("GET", strings.TrimRight(c.URL, "/")+"/api/status", nil)
+	if err != nil {
+		return Version{}, err
+	}
+	body, err := c.do(req)
+	if err != nil {
+		return Version{}, err
+	}
+	var status struct {
+		Version struct {
+			Number string `json:"number"`
+		} `json:"version"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return Version{}, fmt.Errorf("invalid status response: %v", err)
+	}
+	return ParseVersion(status.Version.Number)
+}
+
+// CheckVersion returns the version of Kibana, and an error if it is older than MinVersion
+func (c *Client) CheckVersion() (Version, error) {
+	version, err := c.Version()
+	if err != nil {
+		return version, err
+	}
+	if version.Less(MinVersion) {
+		return version, fmt.Errorf("Kibana %s is not supported, the dashboards need %s or later", version, MinVersion)
+	}
+	return version, nil
+}
+
+// Import imports saved objects (NDJSON), replacing existing objects with the same IDs if overwrite is set
+func (c *Client) Import(objects []byte, overwrite bool) (*ImportResult, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	file, err := form.CreateFormFile("file", "http-bomber.ndjson")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(objects); err != nil {
+		return nil, err
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", c.url("/api/saved_objects/_import?overwrite="+strconv.FormatBool(overwrite)), &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	data, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	var result ImportResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("invalid import response: %v", err)
+	}
+	return &result, nil
+}
+
+// SavedObjects returns the bundled saved objects for the index patterns of the results and
+// of the run summaries. Without a summary index, the objects of the run summaries are left out.
+func SavedObjects(resultsPattern string, runsPattern string) ([]byte, error) {
+	skipped := make(map[string]bool)
+	if runsPattern == "" {
+		skipped[RunsDataView] = true
+	}
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(savedObjects))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var object map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &object); err != nil {
+			return nil, fmt.Errorf("invalid saved object: %v", err)
+		}
+		id, _ := object["id"].(string)
+		objectType, _ := object["type"].(string)
+		// leave out objects which refer to left out objects, in the order of the file
+		if skipped[id] && objectType == "index-pattern" || refersTo(object, skipped) {
+			skipped[id] = true
+			continue
+		}
+		if objectType == "index-pattern" {
+			attributes := object["attributes"].(map[string]interface{})
+			switch id {
+			case ResultsDataView:
+				attributes["title"] = resultsPattern
+			case RunsDataView:
+				attributes["title"] = runsPattern
+			}
+		}
+		line, err := json.Marshal(object)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes(), scanner.Err()
+}
+
+func refersTo(object map[string]interface{}, ids map[string]bool) bool {
+	references, _ := object["references"].([]interface{})
+	for _, r := range references {
+		if ref, ok := r.(map[string]interface{}); ok {
+			if id, _ := ref["id"].(string); ids[id] {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,246 @@
+package kibana
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// stub is a Kibana which answers the status and import APIs and records the requests
+type stub struct {
+	version string
+	// import response, status and body
+	status int
+	result string
+
+	mu       sync.Mutex
+	paths    []string
+	xsrf     []string
+	imported []byte
+}
+
+func (s *stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paths = append(s.paths, r.URL.RequestURI())
+	s.xsrf = append(s.xsrf, r.Header.Get("kbn-xsrf"))
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/api/status":
+		fmt.Fprintf(w, `{"name":"kibana","version":{"number":%q}}`, s.version)
+	case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/api/saved_objects/_import"):
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.imported, _ = ioutil.ReadAll(file)
+		if s.status != 0 {
+			w.WriteHeader(s.status)
+		}
+		fmt.Fprint(w, s.result)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newStub(t *testing.T, s *stub) *Client {
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return &Client{HTTP: server.Client(), URL: server.URL + "/"}
+}
+
+func TestCheckVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    Version
+		wantErr string
+	}{
+		{"7.10.0", Version{7, 10, 0}, ""},
+		{"7.17.9", Version{7, 17, 9}, ""},
+		{"8.12.0-SNAPSHOT", Version{8, 12, 0}, ""},
+		{"7.9.3", Version{7, 9, 3}, "7.10.0 or later"},
+		{"6.8.23", Version{6, 8, 23}, "not supported"},
+		{"", Version{}, "invalid version"},
+		{"seven", Version{}, "invalid version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			s := &stub{version: tt.version}
+			client := newStub(t, s)
+			version, err := client.CheckVersion()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error %v, want %q", err, tt.wantErr)
+			}
+			if err == nil && version != tt.want {
+				t.Errorf("version %s, want %s", version, tt.want)
+			}
+			// the status API is not in a space
+			if s.paths[0] != "/api/status" {
+				t.Errorf("path %s, want /api/status", s.paths[0])
+			}
+		})
+	}
+}
+
+func TestVersionLess(t *testing.T) {
+	tests := []struct {
+		a, b Version
+		less bool
+	}{
+		{Version{7, 9, 3}, Version{7, 10, 0}, true},
+		{Version{7, 10, 0}, Version{7, 10, 0}, false},
+		{Version{7, 10, 1}, Version{7, 10, 0}, false},
+		{Version{8, 0, 0}, Version{7, 17, 0}, false},
+		{Version{6, 99, 99}, Version{7, 0, 0}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Less(tt.b); got != tt.less {
+			t.Errorf("%s < %s = %v, want %v", tt.a, tt.b, got, tt.less)
+		}
+	}
+}
+
+func TestImportSpacesAndHeaders(t *testing.T) {
+	tests := []struct {
+		space     string
+		overwrite bool
+		wantPath  string
+	}{
+		{"", true, "/api/saved_objects/_import?overwrite=true"},
+		{"default", false, "/api/saved_objects/_import?overwrite=false"},
+		{"perf-team", true, "/s/perf-team/api/saved_objects/_import?overwrite=true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.space, func(t *testing.T) {
+			s := &stub{version: "7.11.2", result: `{"success":true,"successCount":1}`}
+			client := newStub(t, s)
+			client.Space = tt.space
+			if _, err := client.Version(); err != nil {
+				t.Fatal(err)
+			}
+			objects := []byte(`{"type":"index-pattern","id":"x","attributes":{"title":"x*"}}` + "\n")
+			result, err := client.Import(objects, tt.overwrite)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.SuccessCount != 1 || result.Err() != nil {
+				t.Errorf("result %+v, want one imported object", result)
+			}
+			if s.paths[1] != tt.wantPath {
+				t.Errorf("import path %s, want %s", s.paths[1], tt.wantPath)
+			}
+			// Kibana refuses API requests without the header
+			for i, xsrf := range s.xsrf {
+				if xsrf != "true" {
+					t.Errorf("request %s has kbn-xsrf %q, want true", s.paths[i], xsrf)
+				}
+			}
+			if !bytes.Equal(s.imported, objects) {
+				t.Errorf("imported %q, want %q", s.imported, objects)
+			}
+		})
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	s := &stub{version: "7.11.2", result: `{"success":false,"successCount":14,"errors":[
+		{"id":"http-bomber-destinations-map","type":"map","title":"Destinations","error":{"type":"missing_references","message":"Maps app is disabled"}},
+		{"id":"http-bomber-latency","type":"dashboard","title":"Latency","error":{"type":"conflict","message":"version conflict"}}]}`}
+	client := newStub(t, s)
+	result, err := client.Import([]byte("{}\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Success || result.SuccessCount != 14 || len(result.Errors) != 2 {
+		t.Fatalf("result %+v, want 14 imported and 2 errors", result)
+	}
+	err = result.Err()
+	if err == nil {
+		t.Fatal("no error for a failed import")
+	}
+	for _, want := range []string{"map http-bomber-destinations-map (Destinations): missing_references Maps app is disabled", "dashboard http-bomber-latency", "version conflict"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+
+	// a failed request is an error of its own
+	s = &stub{version: "7.11.2", status: http.StatusForbidden, result: `{"statusCode":403,"message":"Unable to bulk_create index-pattern"}`}
+	client = newStub(t, s)
+	if _, err := client.Import([]byte("{}\n"), true); err == nil || !strings.Contains(err.Error(), "403") || !strings.Contains(err.Error(), "Unable to bulk_create") {
+		t.Errorf("error %v, want the status and message", err)
+	}
+}
+
+// objects parses NDJSON saved objects by ID
+func objects(t *testing.T, data []byte) map[string]map[string]interface{} {
+	byID := make(map[string]map[string]interface{})
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var object map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &object); err != nil {
+			t.Fatalf("invalid saved object: %v", err)
+		}
+		byID[object["id"].(string)] = object
+	}
+	return byID
+}
+
+func title(object map[string]interface{}) string {
+	return object["attributes"].(map[string]interface{})["title"].(string)
+}
+
+func TestSavedObjectsWithoutSummaryIndex(t *testing.T) {
+	data, err := SavedObjects("perf*", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byID := objects(t, data)
+	if _, found := byID[RunsDataView]; found {
+		t.Error("the runs data view is included without a summary index")
+	}
+	if _, found := byID["http-bomber-run-trends"]; found {
+		t.Error("the run trends dashboard is included without a summary index")
+	}
+	for id, object := range byID {
+		if refersTo(object, map[string]bool{RunsDataView: true}) {
+			t.Errorf("%s refers to the runs data view", id)
+		}
+	}
+	for _, id := range []string{ResultsDataView, "http-bomber-latency", "http-bomber-errors", "http-bomber-destinations-map"} {
+		if _, found := byID[id]; !found {
+			t.Errorf("%s is missing", id)
+		}
+	}
+	if got := title(byID[ResultsDataView]); got != "perf*" {
+		t.Errorf("results data view title %q, want perf*", got)
+	}
+}
+
+func TestSavedObjectsWithSummaryIndex(t *testing.T) {
+	all, err := SavedObjects("perf*", "perf-runs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byID := objects(t, all)
+	if got := len(byID); got != len(objects(t, savedObjects)) {
+		t.Errorf("%d objects, want all of them", got)
+	}
+	if got := title(byID[RunsDataView]); got != "perf-runs" {
+		t.Errorf("runs data view title %q, want perf-runs", got)
+	}
+	if _, found := byID["http-bomber-run-trends"]; !found {
+		t.Error("the run trends dashboard is missing")
+	}
+}
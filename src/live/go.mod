@@ -0,0 +1,3 @@
+module http-bomber/live
+
+go 1.16
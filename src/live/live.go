@@ -0,0 +1,227 @@
+package live
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"http-bomber/httptest"
+	"http-bomber/stats"
+)
+
+// number of one second slots in the rolling window
+const windowSlots = 10
+
+// refresh rate of the terminal view
+const refreshInterval = 1 * time.Second
+
+// progress line rate when stdout is not a terminal
+const progressInterval = 10 * time.Second
+
+// slot holds the requests finished during one second
+type slot struct {
+	second    int64
+	requests  int
+	errors    int
+	histogram *stats.Histogram
+}
+
+// target holds the live state of one URL
+type target struct {
+	url         string
+	inFlight    int
+	requests    int
+	errors      int
+	statusCodes map[int]int
+	slots       [windowSlots]*slot
+}
+
+// Dashboard shows the progress of a run on the terminal.
+// It implements httptest.Observer.
+type Dashboard struct {
+	Output   io.Writer
+	TTY      bool
+	Duration time.Duration
+	mu       sync.Mutex
+	start    time.Time
+	targets  map[string]*target
+	order    []string
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewDashboard creates a dashboard writing to stdout for a run of the given duration (zero when running continuously)
+func NewDashboard(urls []string, duration time.Duration) *Dashboard {
+	d := &Dashboard{
+		Output:   os.Stdout,
+		TTY:      isTerminal(os.Stdout),
+		Duration: duration,
+		targets:  make(map[string]*target),
+	}
+	for _, url := range urls {
+		d.target(url)
+	}
+	return d
+}
+
+// isTerminal checks if the file is a character device (a terminal)
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// get or create the state of an URL, must be called with the lock held
+func (d *Dashboard) target(url string) *target {
+	t, found := d.targets[url]
+	if !found {
+		t = &target{url: url, statusCodes: make(map[int]int)}
+		for i := range t.slots {
+			t.slots[i] = &slot{histogram: stats.NewHistogram()}
+		}
+		d.targets[url] = t
+		d.order = append(d.order, url)
+	}
+	return t
+}
+
+// RequestStarted ...
+func (d *Dashboard) RequestStarted(url string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.target(url).inFlight++
+}
+
+// RequestDone ...
+func (d *Dashboard) RequestDone(url string, result *httptest.Result) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t := d.target(url)
+	t.inFlight--
+	if result == nil {
+		return
+	}
+	t.requests++
+	if result.Error == "" {
+		t.statusCodes[result.RespStatusCode]++
+	}
+
+	// rotate the slot of the current second
+	now := time.Now().Unix()
+	s := t.slots[now%windowSlots]
+	if s.second != now {
+		s.second = now
+		s.requests = 0
+		s.errors = 0
+		s.histogram.Reset()
+	}
+	s.requests++
+	if result.Failed() {
+		t.errors++
+		s.errors++
+	}
+	if result.Error == "" {
+		s.histogram.Record(result.ReqRoundTrip)
+	}
+}
+
+// Start starts refreshing the view in the background
+func (d *Dashboard) Start() {
+	d.start = time.Now()
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+	interval := refreshInterval
+	if !d.TTY {
+		interval = progressInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		defer close(d.done)
+		for {
+			select {
+			case <-ticker.C:
+				d.render()
+			case <-d.stop:
+				d.render()
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops refreshing the view after rendering it one last time
+func (d *Dashboard) Stop() {
+	close(d.stop)
+	<-d.done
+}
+
+// rolling window figures of a target, must be called with the lock held
+func (t *target) window(now int64) (rps float64, errorRate float64, h *stats.Histogram) {
+	h = stats.NewHistogram()
+	requests, errors := 0, 0
+	for _, s := range t.slots {
+		// skip the current (incomplete) second and outdated slots
+		if s.second >= now || s.second <= now-windowSlots {
+			continue
+		}
+		requests += s.requests
+		errors += s.errors
+		h.Merge(s.histogram)
+	}
+	rps = float64(requests) / float64(windowSlots-1)
+	if requests > 0 {
+		errorRate = float64(errors) / float64(requests)
+	}
+	return rps, errorRate, h
+}
+
+// render writes the current state to the output
+func (d *Dashboard) render() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	elapsed := now.Sub(d.start).Round(time.Second)
+	remaining := "-"
+	if d.Duration > 0 {
+		left := (d.Duration - elapsed).Round(time.Second)
+		if left < 0 {
+			left = 0
+		}
+		remaining = left.String()
+	}
+
+	if !d.TTY {
+		parts := []string{fmt.Sprintf("elapsed %s remaining %s", elapsed, remaining)}
+		for _, url := range d.order {
+			t := d.targets[url]
+			rps, errorRate, h := t.window(now.Unix())
+			parts = append(parts, fmt.Sprintf("%s: %d reqs %.1f rps p95 %s err %.2f%%",
+				url, t.requests, rps, h.Percentile(95).Round(time.Microsecond), errorRate*100))
+		}
+		fmt.Fprintln(d.Output, strings.Join(parts, " | "))
+		return
+	}
+
+	// clear screen and move the cursor to the top left corner
+	fmt.Fprint(d.Output, "\033[H\033[2J")
+	fmt.Fprintf(d.Output, "HTTP Bomber  elapsed %s  remaining %s\n\n", elapsed, remaining)
+	tw := tabwriter.NewWriter(d.Output, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "URL\tREQUESTS\tRPS\tIN-FLIGHT\tP50\tP95\tP99\tERROR RATE\tSTATUS CODES")
+	for _, url := range d.order {
+		t := d.targets[url]
+		rps, errorRate, h := t.window(now.Unix())
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%d\t%s\t%s\t%s\t%.2f%%\t%s\n",
+			url, t.requests, rps, t.inFlight,
+			h.Percentile(50).Round(time.Microsecond), h.Percentile(95).Round(time.Microsecond), h.Percentile(99).Round(time.Microsecond),
+			errorRate*100, stats.FormatStatusCodes(t.statusCodes))
+	}
+	tw.Flush()
+	fmt.Fprintf(d.Output, "\n(rolling values over the last %d seconds)\n", windowSlots-1)
+}
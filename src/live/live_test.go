@@ -0,0 +1,44 @@
+package live
+
+import (
+	"testing"
+	"time"
+
+	"http-bomber/httptest"
+)
+
+func TestWindowSkipsOutdatedSlots(t *testing.T) {
+	d := NewDashboard([]string{"http://a"}, 0)
+	tg := d.targets["http://a"]
+	const now = 1000
+	// one request per second for the last windowSlots seconds, none yet in the current
+	// second, so the slot of the current second still holds the outdated one
+	for second := int64(now - windowSlots); second < now; second++ {
+		s := tg.slots[second%windowSlots]
+		s.second = second
+		s.requests = 1
+		s.histogram.Record(time.Millisecond)
+	}
+	rps, _, h := tg.window(now)
+	if rps != 1 {
+		t.Errorf("rps %v, want 1", rps)
+	}
+	if h.Count() != windowSlots-1 {
+		t.Errorf("%d latencies in the window, want %d", h.Count(), windowSlots-1)
+	}
+}
+
+func TestRequestDoneWithoutResult(t *testing.T) {
+	d := NewDashboard([]string{"http://a"}, 0)
+	d.RequestStarted("http://a")
+	d.RequestStarted("http://a")
+	d.RequestDone("http://a", nil)
+	d.RequestDone("http://a", &httptest.Result{URL: "http://a", RespStatusCode: 200})
+	tg := d.targets["http://a"]
+	if tg.inFlight != 0 {
+		t.Errorf("%d in flight, want 0", tg.inFlight)
+	}
+	if tg.requests != 1 {
+		t.Errorf("%d requests, want 1", tg.requests)
+	}
+}
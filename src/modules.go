@@ -0,0 +1,17 @@
+package main
+
+// Exporter and enricher modules register themselves (and their flags) when imported.
+// Add new modules here.
+import (
+	_ "http-bomber/elasticsearch"
+	_ "http-bomber/fileexport"
+	_ "http-bomber/har"
+	_ "http-bomber/htmlreport"
+	_ "http-bomber/influxdb"
+	_ "http-bomber/ipstack"
+	_ "http-bomber/otlp"
+	_ "http-bomber/prometheus"
+	_ "http-bomber/sqlite"
+	_ "http-bomber/statsd"
+	_ "http-bomber/webhook"
+)
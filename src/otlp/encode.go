@@ -0,0 +1,344 @@
+package otlp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"http-bomber/httptest"
+	"http-bomber/protobuf"
+)
+
+// Protobuf encoding of the OTLP messages (https://github.com/open-telemetry/opentelemetry-proto),
+// field numbers as in opentelemetry/proto/{common,resource,trace,metrics}/v1
+
+// span kinds and status codes
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+	statusCodeError  = 2
+	// aggregation temporality of the metrics
+	temporalityCumulative = 2
+)
+
+// DurationBuckets are the explicit bucket bounds (in seconds) of the request duration histogram
+var DurationBuckets = []float64{0, 0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10}
+
+// attribute is a key with a string, int64, float64 or bool value
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+func keyValue(a attribute) *protobuf.Encoder {
+	value := &protobuf.Encoder{}
+	switch v := a.value.(type) {
+	case string:
+		value.String(1, v)
+	case bool:
+		value.Bool(2, v)
+	case int64:
+		value.Int64(3, v)
+	case float64:
+		value.Double(4, v)
+	}
+	kv := &protobuf.Encoder{}
+	kv.String(1, a.key)
+	kv.Message(2, value)
+	return kv
+}
+
+func addAttributes(e *protobuf.Encoder, field int, attributes []attribute) {
+	for _, a := range attributes {
+		e.Message(field, keyValue(a))
+	}
+}
+
+func resource(serviceName string, runID string) *protobuf.Encoder {
+	r := &protobuf.Encoder{}
+	addAttributes(r, 1, []attribute{
+		{"service.name", serviceName},
+		{"http_bomber.run_id", runID},
+	})
+	return r
+}
+
+func scope() *protobuf.Encoder {
+	s := &protobuf.Encoder{}
+	s.String(1, "http-bomber")
+	return s
+}
+
+func unixNano(t time.Time) uint64 {
+	return uint64(t.UnixNano())
+}
+
+// decodeID returns the bytes of a hex encoded ID, or random bytes if it is not set
+func decodeID(id string, size int) []byte {
+	b, err := hex.DecodeString(id)
+	if err != nil || len(b) != size {
+		b = make([]byte, size)
+		rand.Read(b)
+	}
+	return b
+}
+
+// requestAttributes follow the OpenTelemetry semantic conventions for HTTP client spans
+func requestAttributes(r *httptest.Result) []attribute {
+	attributes := []attribute{
+		{"http.request.method", "GET"},
+		{"url.full", r.URL},
+	}
+	if u, err := url.Parse(r.URL); err == nil {
+		attributes = append(attributes, attribute{"server.address", u.Hostname()})
+	}
+	if r.DestinationIP != "" {
+		attributes = append(attributes,
+			attribute{"network.peer.address", r.DestinationIP},
+			attribute{"network.peer.port", int64(r.DestinationPort)},
+		)
+	}
+	if r.RespStatusCode > 0 {
+		attributes = append(attributes, attribute{"http.response.status_code", int64(r.RespStatusCode)})
+	}
+	if kind := errorType(r); kind != "" {
+		attributes = append(attributes, attribute{"error.type", kind})
+	}
+	return append(attributes, attribute{"http_bomber.conn_reused", r.ConnReused})
+}
+
+// errorType is the error kind of failed requests or the status code of 5xx responses
+func errorType(r *httptest.Result) string {
+	switch {
+	case r.Error != "":
+		return r.ErrorKind
+	case r.RespStatusCode >= 500:
+		return strconv.Itoa(r.RespStatusCode)
+	}
+	return ""
+}
+
+// phase is one httptrace phase of a request
+type phase struct {
+	name  string
+	start time.Time
+	end   time.Time
+}
+
+// phases lays out the phase durations of a request on its timeline: DNS, connect and TLS
+// follow the start of the request, wait and transfer precede its end. Phases which did not
+// happen are left out.
+func phases(r *httptest.Result) []phase {
+	var list []phase
+	t := r.ReqStartTime
+	for _, p := range []struct {
+		name     string
+		duration time.Duration
+	}{{"dns", r.Phases.DNS}, {"connect", r.Phases.Connect}, {"tls", r.Phases.TLS}} {
+		if p.duration > 0 {
+			list = append(list, phase{p.name, t, t.Add(p.duration)})
+			t = t.Add(p.duration)
+		}
+	}
+	transferStart := r.ReqEndTime.Add(-r.Phases.Transfer)
+	if r.Phases.Wait > 0 {
+		list = append(list, phase{"wait", transferStart.Add(-r.Phases.Wait), transferStart})
+	}
+	if r.Phases.Transfer > 0 {
+		list = append(list, phase{"transfer", transferStart, r.ReqEndTime})
+	}
+	return list
+}
+
+// spans encodes a request as a client span. Its phases are added as child spans
+// (mode "spans") or as events of the span (mode "events").
+func spans(r *httptest.Result, mode string) []*protobuf.Encoder {
+	traceID := decodeID(r.TraceID, 16)
+	spanID := decodeID(r.SpanID, 8)
+
+	span := &protobuf.Encoder{}
+	span.Bytes(1, traceID)
+	span.Bytes(2, spanID)
+	span.String(5, "GET")
+	span.Uint64(6, spanKindClient)
+	span.Fixed64(7, unixNano(r.ReqStartTime))
+	span.Fixed64(8, unixNano(r.ReqEndTime))
+	addAttributes(span, 9, requestAttributes(r))
+
+	var children []*protobuf.Encoder
+	for _, p := range phases(r) {
+		switch mode {
+		case "events":
+			event := &protobuf.Encoder{}
+			event.Fixed64(1, unixNano(p.start))
+			event.String(2, p.name)
+			addAttributes(event, 3, []attribute{{"http_bomber.phase.duration", p.end.Sub(p.start).Seconds()}})
+			span.Message(11, event)
+		case "spans":
+			child := &protobuf.Encoder{}
+			child.Bytes(1, traceID)
+			child.Bytes(2, decodeID("", 8))
+			child.Bytes(4, spanID)
+			child.String(5, p.name)
+			child.Uint64(6, spanKindInternal)
+			child.Fixed64(7, unixNano(p.start))
+			child.Fixed64(8, unixNano(p.end))
+			children = append(children, child)
+		}
+	}
+
+	if r.Failed() {
+		status := &protobuf.Encoder{}
+		if r.Error != "" {
+			status.String(2, r.Error)
+		}
+		status.Uint64(3, statusCodeError)
+		span.Message(15, status)
+	}
+	return append([]*protobuf.Encoder{span}, children...)
+}
+
+// TracesRequest encodes spans as an ExportTraceServiceRequest
+func TracesRequest(serviceName string, runID string, spans []*protobuf.Encoder) []byte {
+	scopeSpans := &protobuf.Encoder{}
+	scopeSpans.Message(1, scope())
+	for _, s := range spans {
+		scopeSpans.Message(2, s)
+	}
+	resourceSpans := &protobuf.Encoder{}
+	resourceSpans.Message(1, resource(serviceName, runID))
+	resourceSpans.Message(2, scopeSpans)
+	request := &protobuf.Encoder{}
+	request.Message(1, resourceSpans)
+	return request.Encoded()
+}
+
+// histogram holds the data of one histogram data point
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+	min    float64
+	max    float64
+}
+
+// MetricsRequest aggregates results into an ExportMetricsServiceRequest with
+// cumulative metrics over the run:
+// http.client.request.duration (histogram by URL), http_bomber.requests (by URL and status)
+// and http_bomber.request.errors (by URL and error type).
+func MetricsRequest(serviceName string, runID string, start time.Time, end time.Time, results [][]*httptest.Result) []byte {
+	durations := make(map[string]*histogram)
+	requests := make(map[[2]string]int64)
+	errors := make(map[[2]string]int64)
+	for _, resultSet := range results {
+		for _, r := range resultSet {
+			status := strconv.Itoa(r.RespStatusCode)
+			if r.Error != "" {
+				status = "error"
+			}
+			requests[[2]string{r.URL, status}]++
+			if kind := errorType(r); kind != "" {
+				errors[[2]string{r.URL, kind}]++
+			}
+			if r.Error != "" {
+				continue
+			}
+			h, found := durations[r.URL]
+			seconds := r.ReqRoundTrip.Seconds()
+			if !found {
+				h = &histogram{counts: make([]uint64, len(DurationBuckets)+1), min: seconds, max: seconds}
+				durations[r.URL] = h
+			}
+			h.counts[sort.SearchFloat64s(DurationBuckets, seconds)]++
+			h.count++
+			h.sum += seconds
+			if seconds < h.min {
+				h.min = seconds
+			}
+			if seconds > h.max {
+				h.max = seconds
+			}
+		}
+	}
+
+	scopeMetrics := &protobuf.Encoder{}
+	scopeMetrics.Message(1, scope())
+
+	durationHistogram := &protobuf.Encoder{}
+	for _, u := range sortedKeys(durations) {
+		h := durations[u]
+		point := &protobuf.Encoder{}
+		point.Fixed64(2, unixNano(start))
+		point.Fixed64(3, unixNano(end))
+		point.Fixed64(4, h.count)
+		point.Double(5, h.sum)
+		point.PackedFixed64(6, h.counts)
+		point.PackedDouble(7, DurationBuckets)
+		addAttributes(point, 9, []attribute{{"url.full", u}})
+		point.Double(11, h.min)
+		point.Double(12, h.max)
+		durationHistogram.Message(1, point)
+	}
+	durationHistogram.Uint64(2, temporalityCumulative)
+	scopeMetrics.Message(2, metric("http.client.request.duration", "Duration of successful requests.", "s", 9, durationHistogram))
+
+	scopeMetrics.Message(2, metric("http_bomber.requests", "Requests by URL and status code (or error).", "{request}", 7,
+		counter(start, end, requests, "status")))
+	scopeMetrics.Message(2, metric("http_bomber.request.errors", "Failed requests and 5xx responses by URL and error type.", "{request}", 7,
+		counter(start, end, errors, "error.type")))
+
+	resourceMetrics := &protobuf.Encoder{}
+	resourceMetrics.Message(1, resource(serviceName, runID))
+	resourceMetrics.Message(2, scopeMetrics)
+	request := &protobuf.Encoder{}
+	request.Message(1, resourceMetrics)
+	return request.Encoded()
+}
+
+// metric wraps data (a sum, field 7, or a histogram, field 9) into a Metric message
+func metric(name string, description string, unit string, field int, data *protobuf.Encoder) *protobuf.Encoder {
+	m := &protobuf.Encoder{}
+	m.String(1, name)
+	m.String(2, description)
+	m.String(3, unit)
+	m.Message(field, data)
+	return m
+}
+
+// counter encodes a monotonic cumulative sum with one data point per URL and label value
+func counter(start time.Time, end time.Time, values map[[2]string]int64, label string) *protobuf.Encoder {
+	keys := make([][2]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	sum := &protobuf.Encoder{}
+	for _, k := range keys {
+		point := &protobuf.Encoder{}
+		point.Fixed64(2, unixNano(start))
+		point.Fixed64(3, unixNano(end))
+		point.Fixed64(6, uint64(values[k]))
+		addAttributes(point, 7, []attribute{{"url.full", k[0]}, {label, k[1]}})
+		sum.Message(1, point)
+	}
+	sum.Uint64(2, temporalityCumulative)
+	sum.Bool(3, true)
+	return sum
+}
+
+func sortedKeys(m map[string]*histogram) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,3 @@
+module http-bomber/otlp
+
+go 1.16
@@ -0,0 +1,243 @@
+package otlp
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"http-bomber/exporter"
+	"http-bomber/httptest"
+	"http-bomber/logging"
+	"http-bomber/protobuf"
+)
+
+// Config holds configuration for the OTLP exporter
+type Config struct {
+	// Endpoint is the base URL, signals are sent to <endpoint>/v1/traces and <endpoint>/v1/metrics
+	Endpoint    string
+	Headers     string
+	ServiceName string
+	// Propagate injects a W3C traceparent header into each request
+	Propagate bool
+	// Phases is "spans", "events" or "none"
+	Phases    string
+	BatchSize int
+	Timeout   time.Duration
+}
+
+// default endpoint when enabled with -export otlp
+const defaultEndpoint = "http://localhost:4318"
+
+// Module ...
+type Module struct {
+	Config    Config
+	WaitGroup *sync.WaitGroup
+	Logger    *logging.Logger
+	Debug     bool
+	client    http.Client
+}
+
+func init() {
+	exporter.Register(&Module{})
+}
+
+// Name ...
+func (mod *Module) Name() string {
+	return "otlp"
+}
+
+// RegisterFlags ...
+func (mod *Module) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&mod.Config.Endpoint, "otlp-endpoint", "", "Export traces and metrics via OTLP/HTTP to this endpoint example-> http://localhost:4318")
+	fs.Var(headersFlag{&mod.Config.Headers}, "otlp-headers", "Additional headers for OTLP requests example-> Authorization:Bearer xyz")
+	fs.StringVar(&mod.Config.ServiceName, "otlp-service-name", "http-bomber", "Service name of the exported traces and metrics")
+	fs.BoolVar(&mod.Config.Propagate, "otlp-propagate", true, "Inject a W3C traceparent header into each request")
+	mod.Config.Phases = "spans"
+	fs.Var(phasesFlag{&mod.Config.Phases}, "otlp-phases", "Export request phases as child spans, as span events or not at all (spans|events|none)")
+	fs.IntVar(&mod.Config.BatchSize, "otlp-batch-size", 1000, "Number of requests per OTLP traces request")
+	fs.DurationVar(&mod.Config.Timeout, "otlp-timeout", 10*time.Second, "Timeout of OTLP requests")
+}
+
+// Enabled ...
+func (mod *Module) Enabled() bool {
+	return mod.Config.Endpoint != ""
+}
+
+// Enable ...
+func (mod *Module) Enable() {
+	if mod.Config.Endpoint == "" {
+		mod.Config.Endpoint = defaultEndpoint
+	}
+}
+
+// phasesFlag is the -otlp-phases flag, it rejects unknown values when the flags are
+// parsed so that a typo doesn't surface only after the run
+type phasesFlag struct {
+	value *string
+}
+
+func (f phasesFlag) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return *f.value
+}
+
+func (f phasesFlag) Set(s string) error {
+	if err := checkPhases(s); err != nil {
+		return err
+	}
+	*f.value = s
+	return nil
+}
+
+func checkPhases(phases string) error {
+	switch phases {
+	case "spans", "events", "none":
+		return nil
+	default:
+		return fmt.Errorf("%q is not one of spans, events or none", phases)
+	}
+}
+
+// headersFlag is the -otlp-headers flag, it rejects malformed entries when the flags are
+// parsed so that a typo doesn't surface only after the run
+type headersFlag struct {
+	value *string
+}
+
+func (f headersFlag) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return *f.value
+}
+
+func (f headersFlag) Set(s string) error {
+	if _, err := parseHeaders(s); err != nil {
+		return err
+	}
+	*f.value = s
+	return nil
+}
+
+// parseHeaders parses "Name:value,Name2:value2" lists
+func parseHeaders(list string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, h := range strings.Split(list, ",") {
+		if strings.TrimSpace(h) == "" {
+			continue
+		}
+		kv := strings.SplitN(h, ":", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("entry %q is not Name:value", h)
+		}
+		headers.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+	return headers, nil
+}
+
+// Init ...
+func (mod *Module) Init(wg *sync.WaitGroup, logger *logging.Logger, debug bool) {
+	mod.WaitGroup = wg
+	mod.Logger = logger
+	mod.Debug = debug
+	mod.client = http.Client{Timeout: mod.Config.Timeout}
+}
+
+// ConfigureTest turns on trace context propagation so that the spans of the
+// server side link to the exported client spans
+func (mod *Module) ConfigureTest(test *httptest.Test) {
+	if mod.Config.Propagate {
+		test.Settings.TraceContext = true
+	}
+}
+
+// Export sends a span per request and the request metrics of the run
+func (mod *Module) Export(run *exporter.Run) error {
+	if err := checkPhases(mod.Config.Phases); err != nil {
+		return fmt.Errorf("invalid -otlp-phases: %v", err)
+	}
+	headers, err := parseHeaders(mod.Config.Headers)
+	if err != nil {
+		return fmt.Errorf("invalid -otlp-headers: %v", err)
+	}
+	endpoint := strings.TrimSuffix(mod.Config.Endpoint, "/")
+	mod.Logger.Info(fmt.Sprintf("Exporting traces and metrics to %s", endpoint))
+
+	var errs []string
+	if err := mod.exportTraces(endpoint+"/v1/traces", headers, run); err != nil {
+		errs = append(errs, fmt.Sprint("traces: ", err))
+	}
+	metrics := MetricsRequest(mod.Config.ServiceName, run.ID, run.Start, run.End, run.Results)
+	if err := mod.post(endpoint+"/v1/metrics", headers, metrics); err != nil {
+		errs = append(errs, fmt.Sprint("metrics: ", err))
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// exportTraces sends the spans in batches of BatchSize requests
+func (mod *Module) exportTraces(endpoint string, headers http.Header, run *exporter.Run) error {
+	var batch []*protobuf.Encoder
+	requests, sent := 0, 0
+	flush := func() error {
+		if err := mod.post(endpoint, headers, TracesRequest(mod.Config.ServiceName, run.ID, batch)); err != nil {
+			return fmt.Errorf("failed after %d requests: %v", sent, err)
+		}
+		sent += requests
+		requests = 0
+		batch = batch[:0]
+		return nil
+	}
+	for _, resultSet := range run.Results {
+		for _, r := range resultSet {
+			batch = append(batch, spans(r, mod.Config.Phases)...)
+			requests++
+			if requests >= mod.Config.BatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if requests > 0 {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	if mod.Debug {
+		mod.Logger.Debug(fmt.Sprintf("Exported spans of %d requests", sent))
+	}
+	return nil
+}
+
+// post sends one protobuf encoded export request
+func (mod *Module) post(endpoint string, headers http.Header, body []byte) error {
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	resp, err := mod.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package otlp
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFlags(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr string
+	}{
+		{nil, ""},
+		{[]string{"-otlp-phases", "events", "-otlp-headers", "Authorization:Bearer xyz, X-Tenant: a:b"}, ""},
+		{[]string{"-otlp-phases", "span"}, `"span" is not one of spans, events or none`},
+		{[]string{"-otlp-headers", "Authorization=Bearer xyz"}, `entry "Authorization=Bearer xyz" is not Name:value`},
+		{[]string{"-otlp-headers", ":value"}, "is not Name:value"},
+	}
+	for _, tt := range tests {
+		mod := &Module{}
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		mod.RegisterFlags(fs)
+		err := fs.Parse(tt.args)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%v: unexpected error %v", tt.args, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%v: error %v, want %q", tt.args, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := parseHeaders("Authorization:Bearer xyz, x-tenant: a:b,,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := http.Header{"Authorization": {"Bearer xyz"}, "X-Tenant": {"a:b"}}
+	if !reflect.DeepEqual(headers, want) {
+		t.Errorf("headers %v, want %v", headers, want)
+	}
+}
@@ -0,0 +1,3 @@
+module http-bomber/prometheus
+
+go 1.16
@@ -0,0 +1,248 @@
+package prometheus
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"http-bomber/httptest"
+)
+
+// DefaultBuckets are the upper bounds (in seconds) of the latency histograms
+var DefaultBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// requestKey identifies a request counter
+type requestKey struct {
+	url       string
+	status    string
+	errorKind string
+}
+
+// histogramKey identifies a latency histogram
+type histogramKey struct {
+	url   string
+	phase string
+}
+
+// Histogram holds Prometheus style histogram data, Counts are per bucket (not cumulative)
+type Histogram struct {
+	Counts []uint64
+	Count  uint64
+	Sum    float64
+}
+
+func (h *Histogram) observe(buckets []float64, seconds float64) {
+	i := sort.SearchFloat64s(buckets, seconds)
+	h.Counts[i]++
+	h.Count++
+	h.Sum += seconds
+}
+
+// Metrics aggregates results into counters, gauges and histograms.
+// It implements httptest.Observer.
+type Metrics struct {
+	Buckets    []float64
+	mu         sync.Mutex
+	requests   map[requestKey]uint64
+	inFlight   map[string]int64
+	histograms map[histogramKey]*Histogram
+}
+
+// NewMetrics ...
+func NewMetrics(buckets []float64) *Metrics {
+	return &Metrics{
+		Buckets:    buckets,
+		requests:   make(map[requestKey]uint64),
+		inFlight:   make(map[string]int64),
+		histograms: make(map[histogramKey]*Histogram),
+	}
+}
+
+// RequestStarted ...
+func (m *Metrics) RequestStarted(url string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight[url]++
+}
+
+// RequestDone ...
+func (m *Metrics) RequestDone(url string, result *httptest.Result) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight[url]--
+	if result == nil {
+		return
+	}
+	m.record(result)
+}
+
+// record must be called with the lock held
+func (m *Metrics) record(result *httptest.Result) {
+	key := requestKey{url: result.URL, status: strconv.Itoa(result.RespStatusCode), errorKind: "none"}
+	if result.Error != "" {
+		key.status = "error"
+		key.errorKind = result.ErrorKind
+	} else if result.RespStatusCode >= 500 {
+		key.errorKind = "http_5xx"
+	}
+	m.requests[key]++
+	if result.Error != "" {
+		return
+	}
+	durations := map[string]time.Duration{
+		"total":    result.ReqRoundTrip,
+		"dns":      result.Phases.DNS,
+		"connect":  result.Phases.Connect,
+		"tls":      result.Phases.TLS,
+		"wait":     result.Phases.Wait,
+		"transfer": result.Phases.Transfer,
+	}
+	for phase, d := range durations {
+		// skip phases which did not happen, e.g. on reused connections
+		if d == 0 && phase != "total" {
+			continue
+		}
+		hk := histogramKey{url: result.URL, phase: phase}
+		h, found := m.histograms[hk]
+		if !found {
+			h = &Histogram{Counts: make([]uint64, len(m.Buckets)+1)}
+			m.histograms[hk] = h
+		}
+		h.observe(m.Buckets, d.Seconds())
+	}
+}
+
+// Series is one sample with labels
+type Series struct {
+	Name   string
+	Labels [][2]string
+	Value  float64
+}
+
+// Snapshot returns all current samples in a stable order, histograms expanded into
+// _bucket (cumulative, with "le" label), _sum and _count samples
+func (m *Metrics) Snapshot() []Series {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var series []Series
+
+	requestKeys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		requestKeys = append(requestKeys, k)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		a, b := requestKeys[i], requestKeys[j]
+		if a.url != b.url {
+			return a.url < b.url
+		}
+		if a.status != b.status {
+			return a.status < b.status
+		}
+		return a.errorKind < b.errorKind
+	})
+	for _, k := range requestKeys {
+		series = append(series, Series{
+			Name:   "http_bomber_requests_total",
+			Labels: [][2]string{{"url", k.url}, {"status", k.status}, {"error_kind", k.errorKind}},
+			Value:  float64(m.requests[k]),
+		})
+	}
+
+	urls := make([]string, 0, len(m.inFlight))
+	for url := range m.inFlight {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		series = append(series, Series{
+			Name:   "http_bomber_requests_in_flight",
+			Labels: [][2]string{{"url", url}},
+			Value:  float64(m.inFlight[url]),
+		})
+	}
+
+	histogramKeys := make([]histogramKey, 0, len(m.histograms))
+	for k := range m.histograms {
+		histogramKeys = append(histogramKeys, k)
+	}
+	sort.Slice(histogramKeys, func(i, j int) bool {
+		a, b := histogramKeys[i], histogramKeys[j]
+		if a.url != b.url {
+			return a.url < b.url
+		}
+		return a.phase < b.phase
+	})
+	for _, k := range histogramKeys {
+		h := m.histograms[k]
+		labels := [][2]string{{"url", k.url}, {"phase", k.phase}}
+		var cumulative uint64
+		for i, upper := range m.Buckets {
+			cumulative += h.Counts[i]
+			series = append(series, Series{
+				Name:   "http_bomber_request_duration_seconds_bucket",
+				Labels: append(append([][2]string{}, labels...), [2]string{"le", formatFloat(upper)}),
+				Value:  float64(cumulative),
+			})
+		}
+		series = append(series,
+			Series{Name: "http_bomber_request_duration_seconds_bucket", Labels: append(append([][2]string{}, labels...), [2]string{"le", "+Inf"}), Value: float64(h.Count)},
+			Series{Name: "http_bomber_request_duration_seconds_sum", Labels: labels, Value: h.Sum},
+			Series{Name: "http_bomber_request_duration_seconds_count", Labels: labels, Value: float64(h.Count)},
+		)
+	}
+	return series
+}
+
+// metric family help texts and types, in exposition order
+var families = []struct {
+	name string
+	kind string
+	help string
+}{
+	{"http_bomber_requests_total", "counter", "Requests made by http-bomber by URL, status code and error kind."},
+	{"http_bomber_requests_in_flight", "gauge", "Requests currently in flight by URL."},
+	{"http_bomber_request_duration_seconds", "histogram", "Request duration by URL and phase (total, dns, connect, tls, wait, transfer)."},
+}
+
+// WriteText writes the metrics in the Prometheus text exposition format
+func (m *Metrics) WriteText(w io.Writer) error {
+	series := m.Snapshot()
+	for _, family := range families {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", family.name, family.help, family.name, family.kind)
+		for _, s := range series {
+			// histogram samples have _bucket, _sum and _count suffixes
+			if s.Name != family.name && !(family.kind == "histogram" && strings.HasPrefix(s.Name, family.name+"_")) {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "%s%s %s\n", s.Name, formatLabels(s.Labels), formatFloat(s.Value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func formatLabels(labels [][2]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels))
+	for _, l := range labels {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", l[0], escapeLabel(l[1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
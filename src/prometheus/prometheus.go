@@ -0,0 +1,115 @@
+package prometheus
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"http-bomber/exporter"
+	"http-bomber/httptest"
+	"http-bomber/logging"
+)
+
+// Config holds configuration for the metrics endpoint
+type Config struct {
+	Listen string
+	Path   string
+	// Linger keeps the endpoint up after the run so that the final values get scraped
+	Linger time.Duration
+}
+
+// default listen address when enabled with -export prom
+const defaultListen = ":9102"
+
+// Module ...
+type Module struct {
+	Config    Config
+	WaitGroup *sync.WaitGroup
+	Logger    *logging.Logger
+	Debug     bool
+	Metrics   *Metrics
+	server    *http.Server
+}
+
+func init() {
+	exporter.Register(&Module{})
+}
+
+// Name ...
+func (mod *Module) Name() string {
+	return "prom"
+}
+
+// RegisterFlags ...
+func (mod *Module) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&mod.Config.Listen, "metrics-listen", "", "Serve Prometheus metrics during the run on this address example-> :9102")
+	fs.StringVar(&mod.Config.Path, "metrics-path", "/metrics", "Path of the Prometheus metrics endpoint")
+	fs.DurationVar(&mod.Config.Linger, "metrics-linger", 0, "Keep serving metrics for this long after the run ends example-> 30s")
+}
+
+// Enabled ...
+func (mod *Module) Enabled() bool {
+	return mod.Config.Listen != ""
+}
+
+// Enable ...
+func (mod *Module) Enable() {
+	if mod.Config.Listen == "" {
+		mod.Config.Listen = defaultListen
+	}
+}
+
+// Init ...
+func (mod *Module) Init(wg *sync.WaitGroup, logger *logging.Logger, debug bool) {
+	mod.WaitGroup = wg
+	mod.Logger = logger
+	mod.Debug = debug
+	mod.Metrics = NewMetrics(DefaultBuckets)
+}
+
+// Begin starts serving the metrics endpoint
+func (mod *Module) Begin(run *exporter.Run) error {
+	listener, err := net.Listen("tcp", mod.Config.Listen)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(mod.Config.Path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := mod.Metrics.WriteText(w); err != nil && mod.Debug {
+			mod.Logger.Debug(fmt.Sprint("Failed to write metrics: ", err))
+		}
+	})
+	mod.server = &http.Server{Handler: mux}
+	go mod.server.Serve(listener)
+	mod.Logger.Info(fmt.Sprintf("Serving Prometheus metrics on %s%s", listener.Addr(), mod.Config.Path))
+	return nil
+}
+
+// RequestStarted ...
+func (mod *Module) RequestStarted(url string) {
+	mod.Metrics.RequestStarted(url)
+}
+
+// RequestDone ...
+func (mod *Module) RequestDone(url string, result *httptest.Result) {
+	mod.Metrics.RequestDone(url, result)
+}
+
+// Export stops serving the metrics endpoint, after lingering if configured
+func (mod *Module) Export(run *exporter.Run) error {
+	if mod.server == nil {
+		return nil
+	}
+	if mod.Config.Linger > 0 {
+		mod.Logger.Info(fmt.Sprintf("Serving final metrics for %s", mod.Config.Linger))
+		time.Sleep(mod.Config.Linger)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return mod.server.Shutdown(ctx)
+}
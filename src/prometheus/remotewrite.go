@@ -0,0 +1,289 @@
+package prometheus
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"http-bomber/exporter"
+	"http-bomber/httptest"
+	"http-bomber/logging"
+	"http-bomber/protobuf"
+	"http-bomber/stats"
+)
+
+// RemoteWriteConfig holds configuration for the remote write exporter
+type RemoteWriteConfig struct {
+	URL      string
+	Interval time.Duration
+	Timeout  time.Duration
+	// Labels are added to every series, e.g. "env=staging,team=web"
+	Labels  string
+	Headers string
+}
+
+// quantiles pushed per interval
+var intervalQuantiles = []float64{0.5, 0.9, 0.95, 0.99}
+
+// RemoteWriteModule pushes aggregated series to a Prometheus remote write endpoint
+// periodically during the run and once more at the end
+type RemoteWriteModule struct {
+	Config    RemoteWriteConfig
+	WaitGroup *sync.WaitGroup
+	Logger    *logging.Logger
+	Debug     bool
+	Metrics   *Metrics
+	client    http.Client
+	labels    [][2]string
+	headers   http.Header
+	mu        sync.Mutex
+	// latencies of the current interval per URL
+	interval map[string]*stats.Histogram
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func init() {
+	exporter.Register(&RemoteWriteModule{})
+}
+
+// Name ...
+func (mod *RemoteWriteModule) Name() string {
+	return "promrw"
+}
+
+// RegisterFlags ...
+func (mod *RemoteWriteModule) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&mod.Config.URL, "remote-write-url", "", "Push metrics to a Prometheus remote write endpoint example-> http://localhost:9090/api/v1/write")
+	fs.DurationVar(&mod.Config.Interval, "remote-write-interval", 15*time.Second, "Push interval of remote write")
+	fs.DurationVar(&mod.Config.Timeout, "remote-write-timeout", 10*time.Second, "Request timeout of remote write")
+	fs.StringVar(&mod.Config.Labels, "remote-write-labels", "", "Labels added to every remote write series (run_id is added automatically) example-> env=staging,team=web")
+	fs.StringVar(&mod.Config.Headers, "remote-write-headers", "", "Additional headers for remote write requests example-> Authorization:Bearer xyz,X-Scope-OrgID:tenant1")
+}
+
+// Enabled ...
+func (mod *RemoteWriteModule) Enabled() bool {
+	return mod.Config.URL != ""
+}
+
+// Enable ...
+func (mod *RemoteWriteModule) Enable() {
+	if mod.Config.URL == "" {
+		mod.Config.URL = "http://localhost:9090/api/v1/write"
+	}
+}
+
+// Init ...
+func (mod *RemoteWriteModule) Init(wg *sync.WaitGroup, logger *logging.Logger, debug bool) {
+	mod.WaitGroup = wg
+	mod.Logger = logger
+	mod.Debug = debug
+	mod.Metrics = NewMetrics(DefaultBuckets)
+	mod.interval = make(map[string]*stats.Histogram)
+	mod.client = http.Client{Timeout: mod.Config.Timeout}
+}
+
+// Begin parses labels and headers and starts pushing periodically
+func (mod *RemoteWriteModule) Begin(run *exporter.Run) error {
+	if mod.Config.Interval <= 0 {
+		return fmt.Errorf("invalid -remote-write-interval %s (use a duration above 0)", mod.Config.Interval)
+	}
+	labels, err := parsePairs(mod.Config.Labels, "=")
+	if err != nil {
+		return fmt.Errorf("invalid -remote-write-labels: %v", err)
+	}
+	mod.labels = [][2]string{{"run_id", run.ID}}
+	for _, l := range labels {
+		if l[0] == "run_id" {
+			mod.labels[0] = l
+			continue
+		}
+		mod.labels = append(mod.labels, l)
+	}
+	headers, err := parsePairs(mod.Config.Headers, ":")
+	if err != nil {
+		return fmt.Errorf("invalid -remote-write-headers: %v", err)
+	}
+	mod.headers = make(http.Header)
+	for _, h := range headers {
+		mod.headers.Add(h[0], h[1])
+	}
+
+	mod.stop = make(chan struct{})
+	mod.done = make(chan struct{})
+	go func() {
+		defer close(mod.done)
+		ticker := time.NewTicker(mod.Config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := mod.push(); err != nil {
+					mod.Logger.Warning(fmt.Sprint("Remote write failed: ", err))
+				}
+			case <-mod.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// parse "k=v,k2=v2" style lists
+func parsePairs(list string, separator string) ([][2]string, error) {
+	var pairs [][2]string
+	for _, item := range strings.Split(list, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		kv := strings.SplitN(item, separator, 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("expected name%svalue, got %q", separator, item)
+		}
+		pairs = append(pairs, [2]string{strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])})
+	}
+	return pairs, nil
+}
+
+// RequestStarted ...
+func (mod *RemoteWriteModule) RequestStarted(url string) {
+	mod.Metrics.RequestStarted(url)
+}
+
+// RequestDone ...
+func (mod *RemoteWriteModule) RequestDone(url string, result *httptest.Result) {
+	mod.Metrics.RequestDone(url, result)
+	if result == nil || result.Error != "" {
+		return
+	}
+	mod.mu.Lock()
+	defer mod.mu.Unlock()
+	h, found := mod.interval[result.URL]
+	if !found {
+		h = stats.NewHistogram()
+		mod.interval[result.URL] = h
+	}
+	h.Record(result.ReqRoundTrip)
+}
+
+// Export stops the periodic pushes and pushes the final values
+func (mod *RemoteWriteModule) Export(run *exporter.Run) error {
+	if mod.stop != nil {
+		close(mod.stop)
+		<-mod.done
+	}
+	mod.Logger.Info(fmt.Sprintf("Pushing final metrics to %s", mod.Config.URL))
+	return mod.push()
+}
+
+// series returns the current series: counters and histograms from the metrics and
+// latency quantiles of the interval since the previous push. The latencies of the
+// interval are returned as well, so that they can be restored if the push fails.
+func (mod *RemoteWriteModule) series() ([]Series, map[string]*stats.Histogram) {
+	series := mod.Metrics.Snapshot()
+	mod.mu.Lock()
+	interval := mod.interval
+	mod.interval = make(map[string]*stats.Histogram)
+	mod.mu.Unlock()
+	urls := make([]string, 0, len(interval))
+	for url := range interval {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		h := interval[url]
+		if h.Count() == 0 {
+			continue
+		}
+		for _, q := range intervalQuantiles {
+			series = append(series, Series{
+				Name:   "http_bomber_request_duration_seconds_interval",
+				Labels: [][2]string{{"url", url}, {"quantile", formatFloat(q)}},
+				Value:  h.Percentile(q * 100).Seconds(),
+			})
+		}
+	}
+	return series, interval
+}
+
+// restore adds the latencies of an interval which could not be pushed to the current one
+func (mod *RemoteWriteModule) restore(interval map[string]*stats.Histogram) {
+	mod.mu.Lock()
+	defer mod.mu.Unlock()
+	for url, h := range interval {
+		if current, found := mod.interval[url]; found {
+			h.Merge(current)
+		}
+		mod.interval[url] = h
+	}
+}
+
+// push sends the current series, the latencies of the interval are kept for the
+// next push if it fails
+func (mod *RemoteWriteModule) push() error {
+	series, interval := mod.series()
+	if err := mod.send(series); err != nil {
+		mod.restore(interval)
+		return err
+	}
+	return nil
+}
+
+// send posts series as a remote write request
+func (mod *RemoteWriteModule) send(series []Series) error {
+	body := snappyEncode(mod.writeRequest(series, time.Now()))
+	req, err := http.NewRequest("POST", mod.Config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range mod.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := mod.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if mod.Debug {
+		mod.Logger.Debug(fmt.Sprintf("Remote write pushed %d bytes", len(body)))
+	}
+	return nil
+}
+
+// writeRequest encodes series as a prometheus.WriteRequest protobuf message
+func (mod *RemoteWriteModule) writeRequest(series []Series, now time.Time) []byte {
+	timestamp := now.UnixNano() / int64(time.Millisecond)
+	request := &protobuf.Encoder{}
+	for _, s := range series {
+		labels := append([][2]string{{"__name__", s.Name}}, s.Labels...)
+		labels = append(labels, mod.labels...)
+		sort.Slice(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] })
+
+		ts := &protobuf.Encoder{}
+		for _, l := range labels {
+			label := &protobuf.Encoder{}
+			label.String(1, l[0])
+			label.String(2, l[1])
+			ts.Message(1, label)
+		}
+		sample := &protobuf.Encoder{}
+		sample.Double(1, s.Value)
+		sample.Int64(2, timestamp)
+		ts.Message(2, sample)
+		request.Message(1, ts)
+	}
+	return request.Encoded()
+}
@@ -0,0 +1,398 @@
+package prometheus
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	httpserver "net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"http-bomber/exporter"
+	"http-bomber/httptest"
+	"http-bomber/logging"
+)
+
+// snappyDecode decompresses a snappy block (all tag types, not only those of the encoder)
+func snappyDecode(src []byte) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, errors.New("invalid length")
+	}
+	src = src[n:]
+	dst := make([]byte, 0, length)
+	for len(src) > 0 {
+		tag := src[0]
+		var offset, size int
+		switch tag & 0x03 {
+		case tagLiteral:
+			size = int(tag >> 2)
+			src = src[1:]
+			if size >= 60 {
+				extra := size - 59
+				if len(src) < extra {
+					return nil, errors.New("truncated literal length")
+				}
+				size = 0
+				for i := 0; i < extra; i++ {
+					size |= int(src[i]) << (8 * i)
+				}
+				src = src[extra:]
+			}
+			size++
+			if len(src) < size {
+				return nil, errors.New("truncated literal")
+			}
+			dst = append(dst, src[:size]...)
+			src = src[size:]
+			continue
+		case 0x01:
+			size = 4 + int(tag>>2)&0x07
+			offset = int(tag&0xe0)<<3 | int(src[1])
+			src = src[2:]
+		case tagCopy2:
+			size = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		default:
+			size = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) {
+			return nil, fmt.Errorf("invalid copy offset %d", offset)
+		}
+		for i := 0; i < size; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if uint64(len(dst)) != length {
+		return nil, fmt.Errorf("decoded %d bytes, want %d", len(dst), length)
+	}
+	return dst, nil
+}
+
+// protoField is a field of a protobuf message, varint and fixed64 values in num
+type protoField struct {
+	number int
+	num    uint64
+	data   []byte
+}
+
+func protoFields(b []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errors.New("invalid key")
+		}
+		b = b[n:]
+		f := protoField{number: int(key >> 3)}
+		switch key & 0x07 {
+		case 0:
+			f.num, n = binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errors.New("invalid varint")
+			}
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				return nil, errors.New("truncated fixed64")
+			}
+			f.num = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case 2:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return nil, errors.New("truncated bytes")
+			}
+			f.data = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			return nil, fmt.Errorf("unexpected wire type %d", key&0x07)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// timeSeries is a decoded prometheus.TimeSeries with one sample
+type timeSeries struct {
+	labels    [][2]string
+	value     float64
+	timestamp int64
+}
+
+func (ts timeSeries) label(name string) string {
+	for _, l := range ts.labels {
+		if l[0] == name {
+			return l[1]
+		}
+	}
+	return ""
+}
+
+// decodeWriteRequest decodes a prometheus.WriteRequest
+func decodeWriteRequest(b []byte) ([]timeSeries, error) {
+	request, err := protoFields(b)
+	if err != nil {
+		return nil, err
+	}
+	var series []timeSeries
+	for _, f := range request {
+		if f.number != 1 {
+			return nil, fmt.Errorf("unexpected WriteRequest field %d", f.number)
+		}
+		fields, err := protoFields(f.data)
+		if err != nil {
+			return nil, err
+		}
+		var ts timeSeries
+		for _, tf := range fields {
+			sub, err := protoFields(tf.data)
+			if err != nil {
+				return nil, err
+			}
+			switch tf.number {
+			case 1:
+				var label [2]string
+				for _, lf := range sub {
+					label[lf.number-1] = string(lf.data)
+				}
+				ts.labels = append(ts.labels, label)
+			case 2:
+				for _, sf := range sub {
+					switch sf.number {
+					case 1:
+						ts.value = math.Float64frombits(sf.num)
+					case 2:
+						ts.timestamp = int64(sf.num)
+					}
+				}
+			}
+		}
+		series = append(series, ts)
+	}
+	return series, nil
+}
+
+type receivedWrite struct {
+	header http.Header
+	body   []byte
+}
+
+func TestRemoteWriteReceiver(t *testing.T) {
+	var mu sync.Mutex
+	var received []receivedWrite
+	server := httpserver.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, receivedWrite{header: r.Header, body: body})
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	mod := &RemoteWriteModule{}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	mod.RegisterFlags(fs)
+	err := fs.Parse([]string{
+		"-remote-write-url", server.URL + "/api/v1/write",
+		"-remote-write-interval", "1h",
+		"-remote-write-labels", "env=staging,team=web",
+		"-remote-write-headers", "Authorization:Bearer xyz,X-Scope-OrgID:tenant1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := &logging.Logger{}
+	logger.Init(ioutil.Discard)
+	mod.Init(&sync.WaitGroup{}, logger, false)
+	run := &exporter.Run{ID: "run-1"}
+	if err := mod.Begin(run); err != nil {
+		t.Fatal(err)
+	}
+	const url = "http://example.org"
+	for i := 1; i <= 10; i++ {
+		mod.RequestStarted(url)
+		mod.RequestDone(url, &httptest.Result{URL: url, RespStatusCode: 200, ReqRoundTrip: time.Duration(i) * 10 * time.Millisecond})
+	}
+	start := time.Now()
+	if err := mod.Export(run); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(received) != 1 {
+		t.Fatalf("received %d writes, want 1", len(received))
+	}
+	header := received[0].header
+	for name, want := range map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+		"Authorization":                     "Bearer xyz",
+		"X-Scope-Orgid":                     "tenant1",
+	} {
+		if got := header.Get(name); got != want {
+			t.Errorf("header %s = %q, want %q", name, got, want)
+		}
+	}
+
+	body, err := snappyDecode(received[0].body)
+	if err != nil {
+		t.Fatalf("invalid snappy body: %v", err)
+	}
+	series, err := decodeWriteRequest(body)
+	if err != nil {
+		t.Fatalf("invalid WriteRequest: %v", err)
+	}
+	if len(series) == 0 {
+		t.Fatal("no series")
+	}
+	found := make(map[string]timeSeries)
+	for _, ts := range series {
+		for i := 1; i < len(ts.labels); i++ {
+			if ts.labels[i-1][0] >= ts.labels[i][0] {
+				t.Errorf("labels not sorted: %v", ts.labels)
+			}
+		}
+		if ts.label("run_id") != "run-1" || ts.label("env") != "staging" || ts.label("team") != "web" {
+			t.Errorf("series %v misses the run ID or configured labels", ts.labels)
+		}
+		if ts.timestamp < start.UnixNano()/int64(time.Millisecond)-1000 {
+			t.Errorf("series %v has timestamp %d, want about now", ts.labels, ts.timestamp)
+		}
+		key := ts.label("__name__")
+		if q := ts.label("quantile"); q != "" {
+			key += "{quantile=" + q + "}"
+		}
+		if ts.label("__name__") == "http_bomber_requests_total" {
+			key += "{status=" + ts.label("status") + "}"
+		}
+		found[key] = ts
+	}
+
+	requests, ok := found["http_bomber_requests_total{status=200}"]
+	if !ok || requests.value != 10 || requests.label("url") != url {
+		t.Errorf("requests total %v, want 10 requests of %s", requests, url)
+	}
+	if inFlight, ok := found["http_bomber_requests_in_flight"]; !ok || inFlight.value != 0 {
+		t.Errorf("in flight %v, want 0", inFlight)
+	}
+	median, ok := found["http_bomber_request_duration_seconds_interval{quantile=0.5}"]
+	if !ok || median.value < 0.04 || median.value > 0.06 {
+		t.Errorf("interval median %v, want about 0.05s", median)
+	}
+	for _, name := range []string{"http_bomber_request_duration_seconds_sum", "http_bomber_request_duration_seconds_count"} {
+		if _, ok := found[name]; !ok {
+			t.Errorf("missing series %s", name)
+		}
+	}
+}
+
+func TestRemoteWriteKeepsIntervalOnFailure(t *testing.T) {
+	var mu sync.Mutex
+	var received [][]byte
+	server := httpserver.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, body)
+		// the first push fails
+		if len(received) == 1 {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	mod := &RemoteWriteModule{Config: RemoteWriteConfig{URL: server.URL, Interval: time.Hour}}
+	mod.Init(&sync.WaitGroup{}, &logging.Logger{}, false)
+	if err := mod.Begin(&exporter.Run{ID: "run-1"}); err != nil {
+		t.Fatal(err)
+	}
+	defer close(mod.stop)
+	const url = "http://example.org"
+	record := func(roundTrip time.Duration) {
+		mod.RequestStarted(url)
+		mod.RequestDone(url, &httptest.Result{URL: url, RespStatusCode: 200, ReqRoundTrip: roundTrip})
+	}
+	for i := 1; i <= 10; i++ {
+		record(time.Duration(i) * 10 * time.Millisecond)
+	}
+	if err := mod.push(); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("error %v, want the failed push", err)
+	}
+	for i := 1; i <= 10; i++ {
+		record(time.Second)
+	}
+	if err := mod.push(); err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := snappyDecode(received[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	series, err := decodeWriteRequest(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, ts := range series {
+		if ts.label("__name__") == "http_bomber_request_duration_seconds_interval" && ts.label("quantile") == "0.5" {
+			found = true
+			// the median of both intervals, 1s if the first one was lost
+			if ts.value < 0.09 || ts.value > 0.11 {
+				t.Errorf("interval median %vs, want about 0.1s", ts.value)
+			}
+		}
+	}
+	if !found {
+		t.Error("no interval median")
+	}
+	if len(mod.interval) != 0 {
+		t.Errorf("%d interval histograms after a successful push, want none", len(mod.interval))
+	}
+}
+
+func TestRemoteWriteRunIDLabelOverride(t *testing.T) {
+	mod := &RemoteWriteModule{Config: RemoteWriteConfig{Interval: time.Hour, Labels: "run_id=nightly"}}
+	mod.Init(&sync.WaitGroup{}, &logging.Logger{}, false)
+	if err := mod.Begin(&exporter.Run{ID: "run-1"}); err != nil {
+		t.Fatal(err)
+	}
+	defer close(mod.stop)
+	if len(mod.labels) != 1 || mod.labels[0] != [2]string{"run_id", "nightly"} {
+		t.Errorf("labels %v, want only run_id=nightly", mod.labels)
+	}
+}
+
+func TestRemoteWriteRejectsZeroInterval(t *testing.T) {
+	mod := &RemoteWriteModule{Config: RemoteWriteConfig{}}
+	mod.Init(&sync.WaitGroup{}, &logging.Logger{}, false)
+	if err := mod.Begin(&exporter.Run{ID: "run-1"}); err == nil || !strings.Contains(err.Error(), "-remote-write-interval") {
+		t.Errorf("error %v, want an invalid interval", err)
+	}
+}
+
+func TestSnappyRoundTrip(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"a",
+		strings.Repeat("abcd", 1000),
+		strings.Repeat("http_bomber_requests_total", 50) + "unique tail",
+	} {
+		decoded, err := snappyDecode(snappyEncode([]byte(input)))
+		if err != nil || string(decoded) != input {
+			t.Errorf("round trip of %d bytes failed: %v", len(input), err)
+		}
+	}
+}
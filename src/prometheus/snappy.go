@@ -0,0 +1,77 @@
+package prometheus
+
+import "encoding/binary"
+
+// snappy block format (https://github.com/google/snappy/blob/main/format_description.txt)
+// encoder, as required by the remote write protocol
+
+const (
+	tagLiteral = 0x00
+	tagCopy2   = 0x02
+	// longest match a single 2-byte-offset copy can express
+	maxCopyLength = 64
+	maxOffset     = 1<<16 - 1
+	hashBits      = 14
+)
+
+// snappyEncode compresses src into a snappy block
+func snappyEncode(src []byte) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], uint64(len(src)))
+	dst := append(make([]byte, 0, len(src)/2+n+16), tmp[:n]...)
+
+	// table maps the hash of 4 bytes to their last position (+1, zero meaning empty)
+	var table [1 << hashBits]int
+	literalStart := 0
+	for i := 0; i+4 <= len(src); {
+		key := binary.LittleEndian.Uint32(src[i:])
+		h := (key * 0x1e35a7bd) >> (32 - hashBits)
+		candidate := table[h] - 1
+		table[h] = i + 1
+		if candidate < 0 || i-candidate > maxOffset || binary.LittleEndian.Uint32(src[candidate:]) != key {
+			i++
+			continue
+		}
+		dst = emitLiteral(dst, src[literalStart:i])
+		length := 4
+		for i+length < len(src) && src[candidate+length] == src[i+length] {
+			length++
+		}
+		dst = emitCopy(dst, i-candidate, length)
+		i += length
+		literalStart = i
+	}
+	return emitLiteral(dst, src[literalStart:])
+}
+
+func emitLiteral(dst []byte, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+	n := len(lit) - 1
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|tagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|tagLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|tagLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|tagLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|tagLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, lit...)
+}
+
+func emitCopy(dst []byte, offset int, length int) []byte {
+	for length > 0 {
+		n := length
+		if n > maxCopyLength {
+			n = maxCopyLength
+		}
+		dst = append(dst, byte(n-1)<<2|tagCopy2, byte(offset), byte(offset>>8))
+		length -= n
+	}
+	return dst
+}
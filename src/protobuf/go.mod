@@ -0,0 +1,3 @@
+module http-bomber/protobuf
+
+go 1.16
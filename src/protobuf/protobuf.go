@@ -0,0 +1,113 @@
+package protobuf
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// Encoder builds a protocol buffers message field by field. It covers what the
+// exporters need (e.g. Prometheus remote write and OTLP) without generated code.
+// Fields are written as given, zero values are not skipped.
+type Encoder struct {
+	buf []byte
+}
+
+// Encoded returns the message
+func (e *Encoder) Encoded() []byte {
+	return e.buf
+}
+
+// Len returns the size of the message
+func (e *Encoder) Len() int {
+	return len(e.buf)
+}
+
+func (e *Encoder) varint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	e.buf = append(e.buf, tmp[:n]...)
+}
+
+func (e *Encoder) tag(field int, wireType int) {
+	e.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+// Uint64 writes an uint64/uint32/enum field
+func (e *Encoder) Uint64(field int, v uint64) {
+	e.tag(field, wireVarint)
+	e.varint(v)
+}
+
+// Int64 writes an int64/int32 field (not zigzag encoded)
+func (e *Encoder) Int64(field int, v int64) {
+	e.tag(field, wireVarint)
+	e.varint(uint64(v))
+}
+
+// Bool writes a bool field
+func (e *Encoder) Bool(field int, v bool) {
+	var n uint64
+	if v {
+		n = 1
+	}
+	e.Uint64(field, n)
+}
+
+// Fixed64 writes a fixed64/sfixed64 field
+func (e *Encoder) Fixed64(field int, v uint64) {
+	e.tag(field, wireFixed64)
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], v)
+	e.buf = append(e.buf, tmp[:]...)
+}
+
+// Double writes a double field
+func (e *Encoder) Double(field int, v float64) {
+	e.Fixed64(field, math.Float64bits(v))
+}
+
+// Bytes writes a bytes field
+func (e *Encoder) Bytes(field int, v []byte) {
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// String writes a string field
+func (e *Encoder) String(field int, v string) {
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// Message writes an embedded message field
+func (e *Encoder) Message(field int, m *Encoder) {
+	e.Bytes(field, m.buf)
+}
+
+// PackedFixed64 writes a packed repeated fixed64 field
+func (e *Encoder) PackedFixed64(field int, vs []uint64) {
+	e.tag(field, wireBytes)
+	e.varint(uint64(8 * len(vs)))
+	var tmp [8]byte
+	for _, v := range vs {
+		binary.LittleEndian.PutUint64(tmp[:], v)
+		e.buf = append(e.buf, tmp[:]...)
+	}
+}
+
+// PackedDouble writes a packed repeated double field
+func (e *Encoder) PackedDouble(field int, vs []float64) {
+	bits := make([]uint64, len(vs))
+	for i, v := range vs {
+		bits[i] = math.Float64bits(v)
+	}
+	e.PackedFixed64(field, bits)
+}
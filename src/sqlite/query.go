@@ -0,0 +1,317 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"http-bomber/httptest"
+	"http-bomber/stats"
+)
+
+// Filter selects the runs and URLs of a query
+type Filter struct {
+	// Run is a run ID, "latest" or "all"
+	Run string
+	// URL selects the URLs containing this text
+	URL string
+}
+
+// Report is the outcome of a built-in query
+type Report struct {
+	Header []string
+	Rows   [][]string
+	// Data is written by WriteJSON
+	Data interface{}
+}
+
+// WriteTable writes the report as a human readable table
+func (report *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(report.Header, "\t"))
+	for _, row := range report.Rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// WriteJSON writes the report as indented JSON
+func (report *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report.Data)
+}
+
+// Reports are the built-in queries by name
+var Reports = map[string]func(db *sql.DB, filter *Filter, options *Options) (*Report, error){
+	"runs":        Runs,
+	"percentiles": Percentiles,
+	"errors":      ErrorsOverTime,
+	"slowest":     Slowest,
+}
+
+// Options of the built-in queries
+type Options struct {
+	// Bucket is the time resolution of the errors report
+	Bucket time.Duration
+	// Limit is the number of requests of the slowest report
+	Limit int
+}
+
+// where returns the condition (on targets t) and arguments of a filter
+func (filter *Filter) where(db *sql.DB) (string, []interface{}, error) {
+	var conditions []string
+	var args []interface{}
+	switch filter.Run {
+	case "all":
+	case "", "latest":
+		var id string
+		err := db.QueryRow("SELECT id FROM runs ORDER BY start_time DESC LIMIT 1").Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil, errors.New("no runs in the database")
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, "t.run_id = ?")
+		args = append(args, id)
+	default:
+		conditions = append(conditions, "t.run_id = ?")
+		args = append(args, filter.Run)
+	}
+	if filter.URL != "" {
+		conditions = append(conditions, "instr(t.url, ?) > 0")
+		args = append(args, filter.URL)
+	}
+	if len(conditions) == 0 {
+		return "1", nil, nil
+	}
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+// RunInfo is a row of the runs report
+type RunInfo struct {
+	ID       string    `json:"id"`
+	Version  string    `json:"version"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Passed   bool      `json:"passed"`
+	URLs     int       `json:"urls"`
+	Requests int       `json:"requests"`
+	Errors   int       `json:"errors"`
+}
+
+// Runs lists the stored runs, latest first (all runs unless a run is given)
+func Runs(db *sql.DB, filter *Filter, options *Options) (*Report, error) {
+	if filter.Run == "" || filter.Run == "latest" {
+		filter = &Filter{Run: "all", URL: filter.URL}
+	}
+	where, args, err := filter.where(db)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT ru.id, ru.version, ru.start_time, ru.end_time, ru.passed, count(DISTINCT t.id), count(r.id),
+		coalesce(sum(r.error != '' OR r.status_code >= 500), 0)
+		FROM runs ru JOIN targets t ON t.run_id = ru.id LEFT JOIN results r ON r.target_id = t.id
+		WHERE `+where+` GROUP BY ru.id ORDER BY ru.start_time DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	report := &Report{Header: []string{"RUN", "VERSION", "START", "DURATION", "URLS", "REQUESTS", "ERRORS", "PASSED"}}
+	runs := []*RunInfo{}
+	for rows.Next() {
+		run := &RunInfo{}
+		var start, end int64
+		if err := rows.Scan(&run.ID, &run.Version, &start, &end, &run.Passed, &run.URLs, &run.Requests, &run.Errors); err != nil {
+			return nil, err
+		}
+		run.Start, run.End = time.Unix(0, start), time.Unix(0, end)
+		runs = append(runs, run)
+		report.Rows = append(report.Rows, []string{
+			run.ID, run.Version, run.Start.Format(time.RFC3339), run.End.Sub(run.Start).Round(time.Second).String(),
+			strconv.Itoa(run.URLs), strconv.Itoa(run.Requests), strconv.Itoa(run.Errors), strconv.FormatBool(run.Passed),
+		})
+	}
+	report.Data = runs
+	return report, rows.Err()
+}
+
+// URLSummary is a row of the percentiles report
+type URLSummary struct {
+	RunID string `json:"run_id"`
+	*stats.Summary
+}
+
+// Percentiles summarizes the latencies and errors by run and URL
+func Percentiles(db *sql.DB, filter *Filter, options *Options) (*Report, error) {
+	where, args, err := filter.where(db)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT t.run_id, t.url, r.start_time, r.end_time, r.round_trip, r.status_code, r.error, r.error_kind
+		FROM results r JOIN targets t ON r.target_id = t.id JOIN runs ru ON t.run_id = ru.id
+		WHERE `+where+` ORDER BY ru.start_time, t.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	type key struct{ run, url string }
+	var keys []key
+	results := make(map[key][]*httptest.Result)
+	for rows.Next() {
+		var k key
+		var start, end, roundTrip int64
+		r := &httptest.Result{}
+		if err := rows.Scan(&k.run, &k.url, &start, &end, &roundTrip, &r.RespStatusCode, &r.Error, &r.ErrorKind); err != nil {
+			return nil, err
+		}
+		r.URL = k.url
+		r.ReqStartTime, r.ReqEndTime, r.ReqRoundTrip = time.Unix(0, start), time.Unix(0, end), time.Duration(roundTrip)
+		if _, found := results[k]; !found {
+			keys = append(keys, k)
+		}
+		results[k] = append(results[k], r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &Report{Header: []string{"RUN", "URL", "REQUESTS", "RPS", "ERRORS", "MEAN", "P50", "P90", "P95", "P99", "MAX"}}
+	summaries := []*URLSummary{}
+	for _, k := range keys {
+		s := stats.Summarize(k.url, results[k])
+		summaries = append(summaries, &URLSummary{RunID: k.run, Summary: s})
+		l := s.Latency
+		report.Rows = append(report.Rows, []string{
+			k.run, k.url, strconv.Itoa(s.Requests), strconv.FormatFloat(s.RPS, 'f', 2, 64),
+			fmt.Sprintf("%d (%.2f%%)", s.Errors, s.ErrorRate*100),
+			stats.Round(l.Mean).String(), stats.Round(l.P50).String(), stats.Round(l.P90).String(),
+			stats.Round(l.P95).String(), stats.Round(l.P99).String(), stats.Round(l.Max).String(),
+		})
+	}
+	report.Data = summaries
+	return report, nil
+}
+
+// ErrorBucket is a row of the errors report
+type ErrorBucket struct {
+	Time       time.Time      `json:"time"`
+	URL        string         `json:"url"`
+	Requests   int            `json:"requests"`
+	Errors     int            `json:"errors"`
+	ErrorKinds map[string]int `json:"error_kinds"`
+}
+
+// ErrorsOverTime counts requests and errors (by kind, 5xx responses as "http_5xx")
+// per URL and time bucket
+func ErrorsOverTime(db *sql.DB, filter *Filter, options *Options) (*Report, error) {
+	if options.Bucket <= 0 {
+		return nil, fmt.Errorf("invalid bucket %s", options.Bucket)
+	}
+	where, args, err := filter.where(db)
+	if err != nil {
+		return nil, err
+	}
+	bucket := int64(options.Bucket)
+	rows, err := db.Query(`SELECT r.start_time / ? * ? AS bucket, t.url,
+		CASE WHEN r.error != '' THEN r.error_kind WHEN r.status_code >= 500 THEN 'http_5xx' ELSE '' END AS kind, count(*)
+		FROM results r JOIN targets t ON r.target_id = t.id
+		WHERE `+where+` GROUP BY bucket, t.url, kind ORDER BY bucket, t.url`, append([]interface{}{bucket, bucket}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	buckets := []*ErrorBucket{}
+	var last *ErrorBucket
+	for rows.Next() {
+		var t int64
+		var url, kind string
+		var count int
+		if err := rows.Scan(&t, &url, &kind, &count); err != nil {
+			return nil, err
+		}
+		if last == nil || last.Time.UnixNano() != t || last.URL != url {
+			last = &ErrorBucket{Time: time.Unix(0, t), URL: url, ErrorKinds: make(map[string]int)}
+			buckets = append(buckets, last)
+		}
+		last.Requests += count
+		if kind != "" {
+			last.Errors += count
+			last.ErrorKinds[kind] += count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &Report{Header: []string{"TIME", "URL", "REQUESTS", "ERRORS", "ERROR KINDS"}, Data: buckets}
+	for _, b := range buckets {
+		report.Rows = append(report.Rows, []string{
+			b.Time.Format("2006-01-02 15:04:05"), b.URL, strconv.Itoa(b.Requests),
+			fmt.Sprintf("%d (%.2f%%)", b.Errors, 100*float64(b.Errors)/float64(b.Requests)), stats.FormatErrorKinds(b.ErrorKinds),
+		})
+	}
+	return report, nil
+}
+
+// SlowRequest is a row of the slowest report
+type SlowRequest struct {
+	RunID         string          `json:"run_id"`
+	URL           string          `json:"url"`
+	Start         time.Time       `json:"start"`
+	RoundTrip     time.Duration   `json:"round_trip"`
+	StatusCode    int             `json:"status_code"`
+	Error         string          `json:"error,omitempty"`
+	DestinationIP string          `json:"destination_ip"`
+	ConnReused    bool            `json:"conn_reused"`
+	Phases        httptest.Phases `json:"phases"`
+}
+
+// Slowest lists the requests with the longest round trip
+func Slowest(db *sql.DB, filter *Filter, options *Options) (*Report, error) {
+	where, args, err := filter.where(db)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT t.run_id, t.url, r.start_time, r.round_trip, r.status_code, r.error, r.destination_ip, r.conn_reused,
+		r.dns, r.connect, r.tls, r.wait, r.transfer
+		FROM results r JOIN targets t ON r.target_id = t.id
+		WHERE `+where+` ORDER BY r.round_trip DESC LIMIT ?`, append(args, options.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	report := &Report{Header: []string{"RUN", "URL", "START", "ROUND TRIP", "STATUS", "IP", "DNS", "CONNECT", "TLS", "WAIT", "TRANSFER"}}
+	requests := []*SlowRequest{}
+	for rows.Next() {
+		r := &SlowRequest{}
+		var start, roundTrip int64
+		var p [5]int64
+		if err := rows.Scan(&r.RunID, &r.URL, &start, &roundTrip, &r.StatusCode, &r.Error, &r.DestinationIP, &r.ConnReused,
+			&p[0], &p[1], &p[2], &p[3], &p[4]); err != nil {
+			return nil, err
+		}
+		r.Start, r.RoundTrip = time.Unix(0, start), time.Duration(roundTrip)
+		r.Phases = httptest.Phases{DNS: time.Duration(p[0]), Connect: time.Duration(p[1]), TLS: time.Duration(p[2]),
+			Wait: time.Duration(p[3]), Transfer: time.Duration(p[4])}
+		requests = append(requests, r)
+		status := strconv.Itoa(r.StatusCode)
+		if r.Error != "" {
+			status = r.Error
+		}
+		report.Rows = append(report.Rows, []string{
+			r.RunID, r.URL, r.Start.Format("2006-01-02 15:04:05.000"), stats.Round(r.RoundTrip).String(), status, r.DestinationIP,
+			stats.Round(r.Phases.DNS).String(), stats.Round(r.Phases.Connect).String(), stats.Round(r.Phases.TLS).String(),
+			stats.Round(r.Phases.Wait).String(), stats.Round(r.Phases.Transfer).String(),
+		})
+	}
+	report.Data = requests
+	return report, rows.Err()
+}
@@ -0,0 +1,248 @@
+package sqlite
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilterWhere(t *testing.T) {
+	db := testDB(t, secondRun(), firstRun())
+	tests := []struct {
+		filter Filter
+		where  string
+		args   []interface{}
+	}{
+		// the run started last, not the one stored last
+		{Filter{}, "t.run_id = ?", []interface{}{"run-2"}},
+		{Filter{Run: "latest"}, "t.run_id = ?", []interface{}{"run-2"}},
+		{Filter{Run: "run-1", URL: "b"}, "t.run_id = ? AND instr(t.url, ?) > 0", []interface{}{"run-1", "b"}},
+		{Filter{Run: "all"}, "1", nil},
+		{Filter{Run: "all", URL: "a"}, "instr(t.url, ?) > 0", []interface{}{"a"}},
+	}
+	for _, tt := range tests {
+		where, args, err := tt.filter.where(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if where != tt.where || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("%+v: %s %v, want %s %v", tt.filter, where, args, tt.where, tt.args)
+		}
+	}
+
+	empty := testDB(t)
+	if _, _, err := (&Filter{Run: "latest"}).where(empty); err == nil || err.Error() != "no runs in the database" {
+		t.Errorf("error %v on an empty database", err)
+	}
+	if _, _, err := (&Filter{Run: "all"}).where(empty); err != nil {
+		t.Errorf("error %v for all runs of an empty database", err)
+	}
+}
+
+func TestRuns(t *testing.T) {
+	db := testDB(t, secondRun(), firstRun())
+	tests := []struct {
+		filter Filter
+		rows   [][]string
+	}{
+		// all runs unless a run is given
+		{Filter{Run: "latest"}, [][]string{
+			{"run-2", "1.1.0", secondStart.Local().Format(time.RFC3339), "2s", "1", "4", "2", "false"},
+			{"run-1", "1.0.0", firstStart.Local().Format(time.RFC3339), "10s", "2", "4", "0", "true"},
+		}},
+		{Filter{Run: "run-1"}, [][]string{
+			{"run-1", "1.0.0", firstStart.Local().Format(time.RFC3339), "10s", "2", "4", "0", "true"},
+		}},
+		// only the matching URLs are counted
+		{Filter{Run: "all", URL: "http://b"}, [][]string{
+			{"run-1", "1.0.0", firstStart.Local().Format(time.RFC3339), "10s", "1", "1", "0", "true"},
+		}},
+		{Filter{Run: "run-3"}, nil},
+	}
+	for _, tt := range tests {
+		report, err := Runs(db, &tt.filter, &Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(report.Rows, tt.rows) {
+			t.Errorf("%+v: rows %v, want %v", tt.filter, report.Rows, tt.rows)
+		}
+		if runs := report.Data.([]*RunInfo); len(runs) != len(tt.rows) {
+			t.Errorf("%+v: %d runs in the data, want %d", tt.filter, len(runs), len(tt.rows))
+		}
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	// the second run is stored first, the report is ordered by start time
+	db := testDB(t, secondRun(), firstRun())
+	tests := []struct {
+		filter Filter
+		rows   [][]string
+	}{
+		// the latencies leave out the timeout, the percentiles are accurate to 3 significant digits
+		{Filter{Run: "latest"}, [][]string{
+			{"run-2", "http://a", "4", "2.11", "2 (50.00%)", "168.33ms", "100.03ms", "400ms", "400ms", "400ms", "400ms"},
+		}},
+		{Filter{Run: "all"}, [][]string{
+			{"run-1", "http://a", "3", "1.48", "0 (0.00%)", "20ms", "20.02ms", "30ms", "30ms", "30ms", "30ms"},
+			{"run-1", "http://b", "1", "20.00", "0 (0.00%)", "50ms", "50ms", "50ms", "50ms", "50ms", "50ms"},
+			{"run-2", "http://a", "4", "2.11", "2 (50.00%)", "168.33ms", "100.03ms", "400ms", "400ms", "400ms", "400ms"},
+		}},
+		{Filter{Run: "all", URL: "b"}, [][]string{
+			{"run-1", "http://b", "1", "20.00", "0 (0.00%)", "50ms", "50ms", "50ms", "50ms", "50ms", "50ms"},
+		}},
+	}
+	for _, tt := range tests {
+		report, err := Percentiles(db, &tt.filter, &Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(report.Rows, tt.rows) {
+			t.Errorf("%+v: rows\n%v, want\n%v", tt.filter, report.Rows, tt.rows)
+		}
+	}
+
+	report, err := Percentiles(db, &Filter{Run: "run-2"}, &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := report.Data.([]*URLSummary)[0]
+	if s.RunID != "run-2" || s.StatusCodes[503] != 1 || s.ErrorKinds["timeout"] != 1 || s.ErrorKinds["http_5xx"] != 1 {
+		t.Errorf("summary %+v", s)
+	}
+}
+
+func TestErrorsOverTime(t *testing.T) {
+	db := testDB(t, firstRun(), secondRun())
+	tests := []struct {
+		name    string
+		filter  Filter
+		bucket  time.Duration
+		buckets []ErrorBucket
+	}{
+		{"seconds", Filter{Run: "latest"}, time.Second, []ErrorBucket{
+			{Time: secondStart, URL: "http://a", Requests: 2, Errors: 1, ErrorKinds: map[string]int{"timeout": 1}},
+			{Time: secondStart.Add(time.Second), URL: "http://a", Requests: 2, Errors: 1, ErrorKinds: map[string]int{"http_5xx": 1}},
+		}},
+		{"one bucket", Filter{Run: "latest"}, time.Minute, []ErrorBucket{
+			{Time: secondStart, URL: "http://a", Requests: 4, Errors: 2, ErrorKinds: map[string]int{"http_5xx": 1, "timeout": 1}},
+		}},
+		// a bucket per URL, also without errors
+		{"per URL", Filter{Run: "run-1"}, time.Minute, []ErrorBucket{
+			{Time: firstStart, URL: "http://a", Requests: 3, ErrorKinds: map[string]int{}},
+			{Time: firstStart, URL: "http://b", Requests: 1, ErrorKinds: map[string]int{}},
+		}},
+	}
+	for _, tt := range tests {
+		report, err := ErrorsOverTime(db, &tt.filter, &Options{Bucket: tt.bucket})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buckets := report.Data.([]*ErrorBucket)
+		if len(buckets) != len(tt.buckets) {
+			t.Fatalf("%s: %d buckets, want %d", tt.name, len(buckets), len(tt.buckets))
+		}
+		for i, b := range buckets {
+			want := tt.buckets[i]
+			if !b.Time.Equal(want.Time) || b.URL != want.URL || b.Requests != want.Requests || b.Errors != want.Errors || !reflect.DeepEqual(b.ErrorKinds, want.ErrorKinds) {
+				t.Errorf("%s: bucket %d %+v, want %+v", tt.name, i, b, want)
+			}
+		}
+	}
+
+	report, err := ErrorsOverTime(db, &Filter{Run: "run-2"}, &Options{Bucket: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{secondStart.Local().Format("2006-01-02 15:04:05"), "http://a", "4", "2 (50.00%)", "http_5xx:1 timeout:1"}
+	if !reflect.DeepEqual(report.Rows[0], want) {
+		t.Errorf("row %v, want %v", report.Rows[0], want)
+	}
+
+	for _, bucket := range []time.Duration{0, -time.Second} {
+		if _, err := ErrorsOverTime(db, &Filter{}, &Options{Bucket: bucket}); err == nil {
+			t.Errorf("no error for a bucket of %s", bucket)
+		}
+	}
+}
+
+func TestSlowest(t *testing.T) {
+	db := testDB(t, firstRun(), secondRun())
+	report, err := Slowest(db, &Filter{Run: "all"}, &Options{Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := report.Data.([]*SlowRequest)
+	if len(requests) != 3 {
+		t.Fatalf("%d requests, want 3", len(requests))
+	}
+	for i, want := range []time.Duration{time.Second, 400 * time.Millisecond, 100 * time.Millisecond} {
+		if requests[i].RoundTrip != want || requests[i].RunID != "run-2" {
+			t.Errorf("request %d took %s in %s, want %s in run-2", i, requests[i].RoundTrip, requests[i].RunID, want)
+		}
+	}
+	// failed requests show the error instead of the status code
+	if report.Rows[0][4] != "context deadline exceeded" || report.Rows[1][4] != "200" {
+		t.Errorf("status %q and %q", report.Rows[0][4], report.Rows[1][4])
+	}
+	slow := requests[1]
+	if !slow.ConnReused || slow.Phases.Wait != 390*time.Millisecond || slow.Phases.Transfer != 7*time.Millisecond || slow.DestinationIP != "192.0.2.1" {
+		t.Errorf("request %+v", slow)
+	}
+	if want := []string{"400ms", "200", "192.0.2.1", "1ms", "2ms", "0s", "390ms", "7ms"}; !reflect.DeepEqual(report.Rows[1][3:], want) {
+		t.Errorf("row %v, want %v", report.Rows[1][3:], want)
+	}
+
+	report, err = Slowest(db, &Filter{Run: "run-1", URL: "http://a"}, &Options{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Rows) != 3 || report.Rows[0][3] != "30ms" {
+		t.Errorf("rows %v, want the 3 requests of http://a in run-1", report.Rows)
+	}
+}
+
+func TestReportOutput(t *testing.T) {
+	db := testDB(t, firstRun())
+	report, err := Runs(db, &Filter{Run: "all"}, &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := report.WriteTable(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "RUN    VERSION  START") || !strings.HasPrefix(lines[1], "run-1  1.0.0") {
+		t.Errorf("table\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var runs []*RunInfo
+	if err := json.Unmarshal(buf.Bytes(), &runs); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(runs) != 1 || runs[0].ID != "run-1" || !runs[0].Start.Equal(firstStart) || runs[0].URLs != 2 || !runs[0].Passed {
+		t.Errorf("decoded %+v", runs[0])
+	}
+}
+
+func TestReportsOnEmptyDatabase(t *testing.T) {
+	db := testDB(t)
+	for name, report := range Reports {
+		// the runs report lists all runs unless a run is given
+		if name == "runs" {
+			continue
+		}
+		if _, err := report(db, &Filter{Run: "latest"}, &Options{Bucket: time.Second, Limit: 1}); err == nil || err.Error() != "no runs in the database" {
+			t.Errorf("%s: error %v on an empty database", name, err)
+		}
+	}
+}
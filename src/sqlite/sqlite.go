@@ -0,0 +1,207 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sync"
+
+	"http-bomber/exporter"
+	"http-bomber/logging"
+	"http-bomber/thresholds"
+
+	// pure Go driver, keeps the binary free of cgo
+	_ "modernc.org/sqlite"
+)
+
+// Config holds configuration for the SQLite exporter
+type Config struct {
+	Path string
+}
+
+// default database when enabled with -export sqlite
+const defaultPath = "http-bomber.db"
+
+// Module ...
+type Module struct {
+	Config    Config
+	WaitGroup *sync.WaitGroup
+	Logger    *logging.Logger
+	Debug     bool
+}
+
+// schema of the result store. Times are unix nanoseconds, durations nanoseconds.
+const schema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id         TEXT PRIMARY KEY,
+	version    TEXT NOT NULL,
+	start_time INTEGER NOT NULL,
+	end_time   INTEGER NOT NULL,
+	passed     INTEGER NOT NULL,
+	config     TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS targets (
+	id          INTEGER PRIMARY KEY,
+	run_id      TEXT NOT NULL REFERENCES runs(id) ON DELETE CASCADE,
+	url         TEXT NOT NULL,
+	duration_s  INTEGER NOT NULL,
+	timeout_s   INTEGER NOT NULL,
+	interval_ms INTEGER NOT NULL,
+	UNIQUE (run_id, url)
+);
+CREATE TABLE IF NOT EXISTS results (
+	id               INTEGER PRIMARY KEY,
+	target_id        INTEGER NOT NULL REFERENCES targets(id) ON DELETE CASCADE,
+	start_time       INTEGER NOT NULL,
+	end_time         INTEGER NOT NULL,
+	round_trip       INTEGER NOT NULL,
+	status_code      INTEGER NOT NULL,
+	error            TEXT NOT NULL,
+	error_kind       TEXT NOT NULL,
+	destination_ip   TEXT NOT NULL,
+	destination_port INTEGER NOT NULL,
+	conn_reused      INTEGER NOT NULL,
+	dns              INTEGER NOT NULL,
+	connect          INTEGER NOT NULL,
+	tls              INTEGER NOT NULL,
+	wait             INTEGER NOT NULL,
+	transfer         INTEGER NOT NULL,
+	body_size        INTEGER NOT NULL,
+	trace_id         TEXT NOT NULL,
+	modules          TEXT
+);
+CREATE INDEX IF NOT EXISTS results_target_start ON results (target_id, start_time);
+`
+
+func init() {
+	exporter.Register(&Module{})
+}
+
+// Name ...
+func (mod *Module) Name() string {
+	return "sqlite"
+}
+
+// RegisterFlags ...
+func (mod *Module) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&mod.Config.Path, "sqlite-path", "", "Store runs and results in a SQLite database (created if missing)")
+}
+
+// Enabled ...
+func (mod *Module) Enabled() bool {
+	return mod.Config.Path != ""
+}
+
+// Enable ...
+func (mod *Module) Enable() {
+	if mod.Config.Path == "" {
+		mod.Config.Path = defaultPath
+	}
+}
+
+// Init ...
+func (mod *Module) Init(wg *sync.WaitGroup, logger *logging.Logger, debug bool) {
+	mod.WaitGroup = wg
+	mod.Logger = logger
+	mod.Debug = debug
+}
+
+// Open opens (and if needed creates) a result store
+func Open(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Export stores the run, one target per URL and all results in one transaction
+func (mod *Module) Export(run *exporter.Run) error {
+	mod.Logger.Info(fmt.Sprintf("Storing results in %s", mod.Config.Path))
+	db, err := Open(mod.Config.Path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := Store(db, run); err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// Store writes a run into the result store
+func Store(db *sql.DB, run *exporter.Run) error {
+	config := make(map[string]string)
+	for _, s := range run.Config {
+		config[s.Name] = s.Value
+	}
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("INSERT INTO runs (id, version, start_time, end_time, passed, config) VALUES (?, ?, ?, ?, ?, ?)",
+		run.ID, run.Version, run.Start.UnixNano(), run.End.UnixNano(), !thresholds.Failed(run.Outcomes), string(configJSON)); err != nil {
+		return err
+	}
+	insertResult, err := tx.Prepare(`INSERT INTO results (target_id, start_time, end_time, round_trip, status_code, error, error_kind,
+		destination_ip, destination_port, conn_reused, dns, connect, tls, wait, transfer, body_size, trace_id, modules)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insertResult.Close()
+
+	// one target per URL, results arrive in the order the tests finished
+	targets := make(map[string]int64)
+	for _, s := range run.Settings {
+		if _, found := targets[s.URL]; found {
+			continue
+		}
+		res, err := tx.Exec("INSERT INTO targets (run_id, url, duration_s, timeout_s, interval_ms) VALUES (?, ?, ?, ?, ?)",
+			run.ID, s.URL, int64(s.Duration), int64(s.Timeout), int64(s.Interval))
+		if err != nil {
+			return err
+		}
+		if targets[s.URL], err = res.LastInsertId(); err != nil {
+			return err
+		}
+	}
+	for _, resultSet := range run.Results {
+		for _, r := range resultSet {
+			targetID, found := targets[r.URL]
+			if !found {
+				return fmt.Errorf("no settings for URL %s", r.URL)
+			}
+			var modules interface{}
+			if len(r.Modules) > 0 {
+				data, err := json.Marshal(r.Modules)
+				if err != nil {
+					return err
+				}
+				modules = string(data)
+			}
+			if _, err := insertResult.Exec(targetID, r.ReqStartTime.UnixNano(), r.ReqEndTime.UnixNano(), int64(r.ReqRoundTrip),
+				r.RespStatusCode, r.Error, r.ErrorKind, r.DestinationIP, r.DestinationPort, r.ConnReused,
+				int64(r.Phases.DNS), int64(r.Phases.Connect), int64(r.Phases.TLS), int64(r.Phases.Wait), int64(r.Phases.Transfer),
+				r.RespBodySize, r.TraceID, modules); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,199 @@
+package sqlite
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"http-bomber/exporter"
+	"http-bomber/httptest"
+	"http-bomber/logging"
+	"http-bomber/thresholds"
+)
+
+var (
+	firstStart  = time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	secondStart = time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+)
+
+func result(url string, start time.Time, roundTrip time.Duration, status int) *httptest.Result {
+	return &httptest.Result{
+		URL: url, RespStatusCode: status, ReqStartTime: start, ReqEndTime: start.Add(roundTrip), ReqRoundTrip: roundTrip,
+		DestinationIP: "192.0.2.1", DestinationPort: 80, RespBodySize: 100,
+	}
+}
+
+func settings(urls ...string) []httptest.Settings {
+	var list []httptest.Settings
+	for _, url := range urls {
+		list = append(list, httptest.Settings{URL: url, Duration: 10 * time.Second, Timeout: 5 * time.Second, Interval: 500 * time.Millisecond})
+	}
+	return list
+}
+
+// firstRun passed with 3 requests to http://a and one to http://b
+func firstRun() *exporter.Run {
+	a := []*httptest.Result{
+		result("http://a", firstStart, 10*time.Millisecond, 200),
+		result("http://a", firstStart.Add(time.Second), 20*time.Millisecond, 200),
+		result("http://a", firstStart.Add(2*time.Second), 30*time.Millisecond, 200),
+	}
+	b := []*httptest.Result{result("http://b", firstStart, 50*time.Millisecond, 200)}
+	b[0].Modules = map[string]interface{}{"ipstack": map[string]interface{}{"City": "Vienna"}}
+	return &exporter.Run{
+		ID: "run-1", Version: "1.0.0", Start: firstStart, End: firstStart.Add(10 * time.Second),
+		Settings: settings("http://a", "http://b"),
+		Config:   []exporter.Setting{{Name: "url", Value: "http://a"}, {Name: "elastic-api-key", Value: exporter.Masked}},
+		Results:  [][]*httptest.Result{a, b},
+	}
+}
+
+// secondRun failed a threshold, http://a timed out once and returned a 503 in its second second
+func secondRun() *exporter.Run {
+	timeout := result("http://a", secondStart.Add(500*time.Millisecond), time.Second, 0)
+	timeout.Error, timeout.ErrorKind = "context deadline exceeded", "timeout"
+	failed := result("http://a", secondStart.Add(1200*time.Millisecond), 5*time.Millisecond, 503)
+	slow := result("http://a", secondStart.Add(1500*time.Millisecond), 400*time.Millisecond, 200)
+	slow.ConnReused = true
+	slow.Phases = httptest.Phases{DNS: time.Millisecond, Connect: 2 * time.Millisecond, Wait: 390 * time.Millisecond, Transfer: 7 * time.Millisecond}
+	return &exporter.Run{
+		ID: "run-2", Version: "1.1.0", Start: secondStart, End: secondStart.Add(2 * time.Second),
+		Settings: settings("http://a"),
+		Results: [][]*httptest.Result{{
+			result("http://a", secondStart, 100*time.Millisecond, 200), timeout, failed, slow,
+		}},
+		Outcomes: []*thresholds.Outcome{{Passed: false}},
+	}
+}
+
+// testDB opens a temporary result store holding the runs
+func testDB(t *testing.T, runs ...*exporter.Run) *sql.DB {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, run := range runs {
+		if err := Store(db, run); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// count returns the number of rows of a table, optionally followed by a condition
+func count(t *testing.T, db *sql.DB, table string) int {
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM " + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestStore(t *testing.T) {
+	db := testDB(t, firstRun(), secondRun())
+	if runs, targets, results := count(t, db, "runs"), count(t, db, "targets"), count(t, db, "results"); runs != 2 || targets != 3 || results != 8 {
+		t.Errorf("%d runs, %d targets, %d results, want 2, 3 and 8", runs, targets, results)
+	}
+
+	var version, config string
+	var start, end int64
+	var passed bool
+	if err := db.QueryRow("SELECT version, start_time, end_time, passed, config FROM runs WHERE id = 'run-1'").Scan(&version, &start, &end, &passed, &config); err != nil {
+		t.Fatal(err)
+	}
+	if version != "1.0.0" || start != firstStart.UnixNano() || end != firstStart.Add(10*time.Second).UnixNano() || !passed {
+		t.Errorf("run-1 stored as %s %d %d passed %v", version, start, end, passed)
+	}
+	// secrets stay masked
+	if config != `{"elastic-api-key":"***","url":"http://a"}` {
+		t.Errorf("config %s", config)
+	}
+	if err := db.QueryRow("SELECT passed FROM runs WHERE id = 'run-2'").Scan(&passed); err != nil || passed {
+		t.Errorf("run-2 passed %v (%v), want it failed", passed, err)
+	}
+
+	var duration, timeout, interval int64
+	if err := db.QueryRow("SELECT duration_s, timeout_s, interval_ms FROM targets WHERE run_id = 'run-1' AND url = 'http://b'").Scan(&duration, &timeout, &interval); err != nil {
+		t.Fatal(err)
+	}
+	if duration != int64(10*time.Second) || timeout != int64(5*time.Second) || interval != int64(500*time.Millisecond) {
+		t.Errorf("target stored as %d %d %d", duration, timeout, interval)
+	}
+
+	var modules sql.NullString
+	if err := db.QueryRow(`SELECT r.modules FROM results r JOIN targets t ON r.target_id = t.id
+		WHERE t.run_id = 'run-1' AND t.url = 'http://b'`).Scan(&modules); err != nil {
+		t.Fatal(err)
+	}
+	if modules.String != `{"ipstack":{"City":"Vienna"}}` {
+		t.Errorf("modules %v", modules)
+	}
+	if n := count(t, db, "results WHERE modules IS NULL"); n != 7 {
+		t.Errorf("%d results without modules, want 7", n)
+	}
+
+	var status int
+	var errorText, errorKind string
+	var reused bool
+	var dns, connect, tls, wait, transfer int64
+	if err := db.QueryRow(`SELECT status_code, error, error_kind, conn_reused, dns, connect, tls, wait, transfer FROM results
+		WHERE round_trip = ?`, int64(400*time.Millisecond)).Scan(&status, &errorText, &errorKind, &reused, &dns, &connect, &tls, &wait, &transfer); err != nil {
+		t.Fatal(err)
+	}
+	if status != 200 || errorText != "" || !reused || dns != int64(time.Millisecond) || connect != int64(2*time.Millisecond) || tls != 0 ||
+		wait != int64(390*time.Millisecond) || transfer != int64(7*time.Millisecond) {
+		t.Errorf("result stored as %d %q %q %v %d %d %d %d %d", status, errorText, errorKind, reused, dns, connect, tls, wait, transfer)
+	}
+}
+
+func TestStoreRollsBack(t *testing.T) {
+	db := testDB(t, firstRun())
+	tests := []struct {
+		name    string
+		run     *exporter.Run
+		wantErr string
+	}{
+		{"run stored before", firstRun(), "UNIQUE"},
+		{"result without settings", func() *exporter.Run {
+			run := secondRun()
+			run.Results = append(run.Results, []*httptest.Result{result("http://c", secondStart, time.Millisecond, 200)})
+			return run
+		}(), "no settings for URL http://c"},
+	}
+	for _, tt := range tests {
+		if err := Store(db, tt.run); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+		}
+		// nothing of the failed run is stored
+		if runs, targets, results := count(t, db, "runs"), count(t, db, "targets"), count(t, db, "results"); runs != 1 || targets != 2 || results != 4 {
+			t.Errorf("%s: %d runs, %d targets, %d results, want 1, 2 and 4", tt.name, runs, targets, results)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	logger := &logging.Logger{}
+	logger.Init(ioutil.Discard)
+	path := filepath.Join(t.TempDir(), "results.db")
+	for _, run := range []*exporter.Run{firstRun(), secondRun()} {
+		mod := &Module{Config: Config{Path: path}}
+		mod.Init(&sync.WaitGroup{}, logger, false)
+		if err := mod.Export(run); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the runs are added to the existing database
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if runs := count(t, db, "runs"); runs != 2 {
+		t.Errorf("%d runs, want 2", runs)
+	}
+}
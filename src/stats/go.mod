@@ -0,0 +1,3 @@
+module http-bomber/stats
+
+go 1.16
@@ -0,0 +1,153 @@
+package stats
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Histogram is a HDR style (log-linear) latency histogram with microsecond resolution.
+// Values are kept with 3 significant digits of precision in a fixed amount of memory
+// no matter how many values are recorded.
+type Histogram struct {
+	counts []uint64
+	total  uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+// sub buckets per power of two (2^subBucketBits)
+const subBucketBits = 11
+const subBucketCount = 1 << subBucketBits
+const subBucketHalf = subBucketCount / 2
+
+// NewHistogram ...
+func NewHistogram() *Histogram {
+	return &Histogram{counts: make([]uint64, subBucketCount)}
+}
+
+// bucket index of a value (in microseconds)
+func bucketIndex(v uint64) int {
+	pow := bits.Len64(v|(subBucketCount-1)) - subBucketBits
+	return pow*subBucketHalf + int(v>>uint(pow))
+}
+
+// highest value (in microseconds) that falls into the bucket
+func bucketHighest(idx int) uint64 {
+	if idx < subBucketCount {
+		return uint64(idx)
+	}
+	pow := (idx-subBucketCount)/subBucketHalf + 1
+	sub := uint64((idx-subBucketCount)%subBucketHalf + subBucketHalf)
+	return ((sub + 1) << uint(pow)) - 1
+}
+
+// Record adds a value to the histogram
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	idx := bucketIndex(uint64(d / time.Microsecond))
+	if idx >= len(h.counts) {
+		grown := make([]uint64, idx+subBucketHalf)
+		copy(grown, h.counts)
+		h.counts = grown
+	}
+	h.counts[idx]++
+	if h.total == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.total++
+	h.sum += d
+}
+
+// Merge adds all values of another histogram to this one
+func (h *Histogram) Merge(other *Histogram) {
+	if other.total == 0 {
+		return
+	}
+	if len(other.counts) > len(h.counts) {
+		grown := make([]uint64, len(other.counts))
+		copy(grown, h.counts)
+		h.counts = grown
+	}
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	if h.total == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	h.total += other.total
+	h.sum += other.sum
+}
+
+// Reset clears all recorded values
+func (h *Histogram) Reset() {
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	h.total = 0
+	h.sum = 0
+	h.min = 0
+	h.max = 0
+}
+
+// Count returns the number of recorded values
+func (h *Histogram) Count() uint64 {
+	return h.total
+}
+
+// Min returns the smallest recorded value
+func (h *Histogram) Min() time.Duration {
+	return h.min
+}
+
+// Max returns the largest recorded value
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Mean returns the average of the recorded values
+func (h *Histogram) Mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.total)
+}
+
+// Percentile returns the value below which the given percentage (0-100) of values fall
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	if p >= 100 {
+		return h.max
+	}
+	target := uint64(math.Ceil(p / 100 * float64(h.total)))
+	if target == 0 {
+		target = 1
+	}
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= target {
+			v := time.Duration(bucketHighest(i)) * time.Microsecond
+			// bucket bounds are approximations, never report beyond what was seen
+			if v > h.max {
+				return h.max
+			}
+			if v < h.min {
+				return h.min
+			}
+			return v
+		}
+	}
+	return h.max
+}
@@ -0,0 +1,162 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketBoundaries(t *testing.T) {
+	tests := []struct {
+		value   uint64
+		index   int
+		highest uint64
+	}{
+		// one bucket per microsecond up to the sub bucket count
+		{0, 0, 0},
+		{1, 1, 1},
+		{2047, 2047, 2047},
+		// then buckets of 2, 4, ... microseconds
+		{2048, 2048, 2049},
+		{2049, 2048, 2049},
+		{2050, 2049, 2051},
+		{4095, 3071, 4095},
+		{4096, 3072, 4099},
+		{4099, 3072, 4099},
+		{4100, 3073, 4103},
+		{8191, 4095, 8191},
+		{8192, 4096, 8199},
+	}
+	for _, tt := range tests {
+		idx := bucketIndex(tt.value)
+		if idx != tt.index {
+			t.Errorf("bucketIndex(%d) = %d, want %d", tt.value, idx, tt.index)
+		}
+		if got := bucketHighest(idx); got != tt.highest {
+			t.Errorf("bucketHighest(%d) = %d, want %d", idx, got, tt.highest)
+		}
+	}
+}
+
+func TestBucketPrecision(t *testing.T) {
+	// from a microsecond to about 12 days
+	for _, v := range []uint64{1, 999, 2048, 12345, 999999, 1000000, 60000000, 1 << 40} {
+		idx := bucketIndex(v)
+		highest := bucketHighest(idx)
+		if highest < v {
+			t.Errorf("value %d above the highest value %d of its bucket", v, highest)
+		}
+		// 3 significant digits
+		if float64(highest-v) > float64(v)/1000 {
+			t.Errorf("value %d reported as %d", v, highest)
+		}
+		if next := bucketIndex(highest + 1); next != idx+1 {
+			t.Errorf("value %d is in bucket %d, want %d", highest+1, next, idx+1)
+		}
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	h := NewHistogram()
+	// 1ms to 10s in steps of 1ms
+	for i := 1; i <= 10000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	tests := []struct {
+		percentile float64
+		want       time.Duration
+	}{
+		{0, time.Millisecond},
+		{1, 100 * time.Millisecond},
+		{50, 5 * time.Second},
+		{90, 9 * time.Second},
+		{95, 9500 * time.Millisecond},
+		{99, 9900 * time.Millisecond},
+		{99.9, 9990 * time.Millisecond},
+		{100, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		got := h.Percentile(tt.percentile)
+		if got < tt.want || got > tt.want+tt.want/1000 {
+			t.Errorf("p%v = %s, want %s (+0.1%%)", tt.percentile, got, tt.want)
+		}
+	}
+	if h.Count() != 10000 || h.Min() != time.Millisecond || h.Max() != 10*time.Second {
+		t.Errorf("count %d min %s max %s, want 10000 1ms 10s", h.Count(), h.Min(), h.Max())
+	}
+	if h.Mean() != 5000500*time.Microsecond {
+		t.Errorf("mean %s, want 5.0005s", h.Mean())
+	}
+}
+
+func TestPercentilesWithinSeenValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []time.Duration
+		p      float64
+		want   time.Duration
+	}{
+		{"empty", nil, 50, 0},
+		{"single", []time.Duration{12345678 * time.Nanosecond}, 50, 12345678 * time.Nanosecond},
+		// the bucket of 4.096ms reaches 4.099ms
+		{"bucket above the max", []time.Duration{4096 * time.Microsecond}, 99, 4096 * time.Microsecond},
+		{"below a microsecond", []time.Duration{500 * time.Nanosecond}, 50, 500 * time.Nanosecond},
+		{"negative", []time.Duration{-time.Second}, 50, 0},
+	}
+	for _, tt := range tests {
+		h := NewHistogram()
+		for _, v := range tt.values {
+			h.Record(v)
+		}
+		if got := h.Percentile(tt.p); got != tt.want {
+			t.Errorf("%s: p%v = %s, want %s", tt.name, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name        string
+		left, right []time.Duration
+	}{
+		{"both", []time.Duration{time.Millisecond, 3 * time.Millisecond}, []time.Duration{2 * time.Millisecond, 4 * time.Millisecond}},
+		{"empty left", nil, []time.Duration{5 * time.Millisecond}},
+		{"empty right", []time.Duration{5 * time.Millisecond}, nil},
+		// the right histogram has grown for a large value
+		{"grown", []time.Duration{time.Millisecond}, []time.Duration{time.Hour, 2 * time.Millisecond}},
+		{"both empty", nil, nil},
+	}
+	for _, tt := range tests {
+		left, right, all := NewHistogram(), NewHistogram(), NewHistogram()
+		for _, v := range tt.left {
+			left.Record(v)
+			all.Record(v)
+		}
+		for _, v := range tt.right {
+			right.Record(v)
+			all.Record(v)
+		}
+		left.Merge(right)
+		if left.Count() != all.Count() || left.Min() != all.Min() || left.Max() != all.Max() || left.Mean() != all.Mean() {
+			t.Errorf("%s: merged count %d min %s max %s mean %s, want %d %s %s %s", tt.name,
+				left.Count(), left.Min(), left.Max(), left.Mean(), all.Count(), all.Min(), all.Max(), all.Mean())
+		}
+		for _, p := range []float64{0, 25, 50, 75, 99, 100} {
+			if got, want := left.Percentile(p), all.Percentile(p); got != want {
+				t.Errorf("%s: merged p%v = %s, want %s", tt.name, p, got, want)
+			}
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	h := NewHistogram()
+	h.Record(time.Hour)
+	h.Reset()
+	if h.Count() != 0 || h.Max() != 0 || h.Percentile(50) != 0 {
+		t.Errorf("count %d max %s p50 %s after reset, want zeros", h.Count(), h.Max(), h.Percentile(50))
+	}
+	h.Record(2 * time.Millisecond)
+	if h.Min() != 2*time.Millisecond || h.Percentile(50) != 2*time.Millisecond {
+		t.Errorf("min %s p50 %s, want 2ms", h.Min(), h.Percentile(50))
+	}
+}
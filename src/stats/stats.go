@@ -0,0 +1,223 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"http-bomber/httptest"
+)
+
+// Latency holds latency distribution figures of a set of requests
+type Latency struct {
+	Min  time.Duration `json:"min"`
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"p50"`
+	P90  time.Duration `json:"p90"`
+	P95  time.Duration `json:"p95"`
+	P99  time.Duration `json:"p99"`
+	P999 time.Duration `json:"p99_9"`
+	Max  time.Duration `json:"max"`
+}
+
+// Summary holds statistics of one URL (or all URLs combined)
+type Summary struct {
+	URL         string         `json:"url"`
+	Start       time.Time      `json:"start"`
+	End         time.Time      `json:"end"`
+	Requests    int            `json:"requests"`
+	Errors      int            `json:"errors"`
+	ErrorRate   float64        `json:"error_rate"`
+	RPS         float64        `json:"rps"`
+	StatusCodes map[int]int    `json:"status_codes"`
+	ErrorKinds  map[string]int `json:"error_kinds"`
+	Latency     Latency        `json:"latency"`
+	Histogram   *Histogram     `json:"-"`
+}
+
+// Report holds the end-of-run statistics
+type Report struct {
+	URLs    []*Summary `json:"urls"`
+	Overall *Summary   `json:"overall"`
+}
+
+// Compute builds a report from the resultsets of a run (one resultset per URL)
+func Compute(results [][]*httptest.Result) *Report {
+	report := &Report{}
+	overall := newSummary("all")
+	for _, resultSet := range results {
+		if len(resultSet) == 0 {
+			continue
+		}
+		summary := newSummary(resultSet[0].URL)
+		for _, r := range resultSet {
+			summary.add(r)
+		}
+		summary.finish()
+		report.URLs = append(report.URLs, summary)
+		overall.merge(summary)
+	}
+	overall.finish()
+	report.Overall = overall
+	return report
+}
+
+// Summarize builds statistics of a single resultset
+func Summarize(url string, resultSet []*httptest.Result) *Summary {
+	summary := newSummary(url)
+	for _, r := range resultSet {
+		summary.add(r)
+	}
+	summary.finish()
+	return summary
+}
+
+func newSummary(url string) *Summary {
+	return &Summary{
+		URL:         url,
+		StatusCodes: make(map[int]int),
+		ErrorKinds:  make(map[string]int),
+		Histogram:   NewHistogram(),
+	}
+}
+
+// add a single result to the summary
+func (s *Summary) add(r *httptest.Result) {
+	s.Requests++
+	if s.Start.IsZero() || r.ReqStartTime.Before(s.Start) {
+		s.Start = r.ReqStartTime
+	}
+	if r.ReqEndTime.After(s.End) {
+		s.End = r.ReqEndTime
+	}
+	if r.Error != "" {
+		s.Errors++
+		s.ErrorKinds[r.ErrorKind]++
+		return
+	}
+	s.StatusCodes[r.RespStatusCode]++
+	if r.Failed() {
+		s.Errors++
+		s.ErrorKinds["http_5xx"]++
+	}
+	s.Histogram.Record(r.ReqRoundTrip)
+}
+
+// merge another (finished) summary into this one
+func (s *Summary) merge(other *Summary) {
+	s.Requests += other.Requests
+	s.Errors += other.Errors
+	if s.Start.IsZero() || other.Start.Before(s.Start) {
+		s.Start = other.Start
+	}
+	if other.End.After(s.End) {
+		s.End = other.End
+	}
+	for k, v := range other.StatusCodes {
+		s.StatusCodes[k] += v
+	}
+	for k, v := range other.ErrorKinds {
+		s.ErrorKinds[k] += v
+	}
+	s.Histogram.Merge(other.Histogram)
+}
+
+// calculate the derived figures
+func (s *Summary) finish() {
+	if s.Requests > 0 {
+		s.ErrorRate = float64(s.Errors) / float64(s.Requests)
+	}
+	if elapsed := s.End.Sub(s.Start); elapsed > 0 {
+		s.RPS = float64(s.Requests) / elapsed.Seconds()
+	}
+	h := s.Histogram
+	s.Latency = Latency{
+		Min:  h.Min(),
+		Mean: h.Mean(),
+		P50:  h.Percentile(50),
+		P90:  h.Percentile(90),
+		P95:  h.Percentile(95),
+		P99:  h.Percentile(99),
+		P999: h.Percentile(99.9),
+		Max:  h.Max(),
+	}
+}
+
+// WriteJSON writes the report as indented JSON
+func (report *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// WriteTable writes the report as a human readable table
+func (report *Report) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "URL\tREQUESTS\tRPS\tERRORS\tMIN\tMEAN\tP50\tP90\tP95\tP99\tP99.9\tMAX")
+	rows := report.URLs
+	if len(rows) != 1 {
+		rows = append(rows, report.Overall)
+	}
+	for _, s := range rows {
+		l := s.Latency
+		fmt.Fprintf(tw, "%s\t%d\t%.2f\t%d (%.2f%%)\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			s.URL, s.Requests, s.RPS, s.Errors, s.ErrorRate*100,
+			Round(l.Min), Round(l.Mean), Round(l.P50), Round(l.P90), Round(l.P95), Round(l.P99), Round(l.P999), Round(l.Max))
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "URL\tSTATUS CODES\tERRORS")
+	for _, s := range rows {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.URL, FormatStatusCodes(s.StatusCodes), FormatErrorKinds(s.ErrorKinds))
+	}
+	return tw.Flush()
+}
+
+// Round rounds durations to a readable precision
+func Round(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
+
+// FormatStatusCodes formats status code counts as "200:10 503:2", or "-" if there are none
+func FormatStatusCodes(codes map[int]int) string {
+	if len(codes) == 0 {
+		return "-"
+	}
+	keys := make([]int, 0, len(codes))
+	for k := range codes {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%d:%d", k, codes[k]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// FormatErrorKinds formats error kind counts as "timeout:3 http_5xx:2", or "-" if there are none
+func FormatErrorKinds(kinds map[string]int) string {
+	if len(kinds) == 0 {
+		return "-"
+	}
+	keys := make([]string, 0, len(kinds))
+	for k := range kinds {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s:%d", k, kinds[k]))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,3 @@
+module http-bomber/statsd
+
+go 1.16
@@ -0,0 +1,241 @@
+package statsd
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"http-bomber/exporter"
+	"http-bomber/httptest"
+	"http-bomber/logging"
+)
+
+// Config holds configuration for the StatsD exporter
+type Config struct {
+	Address string
+	Prefix  string
+	// Tags are added to every metric (DogStatsD only), e.g. "env:staging,team:web"
+	Tags       string
+	DogStatsD  bool
+	SampleRate float64
+	// PacketSize is the maximum payload of one UDP packet
+	PacketSize    int
+	FlushInterval time.Duration
+}
+
+// default address when enabled with -export statsd
+const defaultAddress = "127.0.0.1:8125"
+
+// Module sends metrics of each request to a StatsD agent while the test is running.
+// Metrics are batched into packets of up to PacketSize bytes which are sent when full
+// and every FlushInterval.
+type Module struct {
+	Config    Config
+	WaitGroup *sync.WaitGroup
+	Logger    *logging.Logger
+	Debug     bool
+	conn      net.Conn
+	tags      []string
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	sent      int
+	failed    int
+	lastErr   error
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func init() {
+	exporter.Register(&Module{})
+}
+
+// Name ...
+func (mod *Module) Name() string {
+	return "statsd"
+}
+
+// RegisterFlags ...
+func (mod *Module) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&mod.Config.Address, "statsd-addr", "", "Send metrics to a StatsD agent at this UDP address example-> 127.0.0.1:8125")
+	fs.StringVar(&mod.Config.Prefix, "statsd-prefix", "http_bomber.", "Prefix of the StatsD metric names")
+	fs.StringVar(&mod.Config.Tags, "statsd-tags", "", "Tags added to every metric (DogStatsD) example-> env:staging,team:web")
+	fs.BoolVar(&mod.Config.DogStatsD, "statsd-dogstatsd", false, "Add DogStatsD style tags (url, status, error_kind and -statsd-tags) to the metrics")
+	fs.Float64Var(&mod.Config.SampleRate, "statsd-sample-rate", 1, "Share of requests sent to StatsD (0 < rate <= 1)")
+	fs.IntVar(&mod.Config.PacketSize, "statsd-packet-size", 1432, "Maximum size of a StatsD UDP packet in bytes")
+	fs.DurationVar(&mod.Config.FlushInterval, "statsd-flush-interval", time.Second, "Send buffered StatsD metrics at least this often")
+}
+
+// Enabled ...
+func (mod *Module) Enabled() bool {
+	return mod.Config.Address != ""
+}
+
+// Enable ...
+func (mod *Module) Enable() {
+	if mod.Config.Address == "" {
+		mod.Config.Address = defaultAddress
+	}
+}
+
+// Init ...
+func (mod *Module) Init(wg *sync.WaitGroup, logger *logging.Logger, debug bool) {
+	mod.WaitGroup = wg
+	mod.Logger = logger
+	mod.Debug = debug
+}
+
+// Begin opens the UDP socket and starts flushing periodically
+func (mod *Module) Begin(run *exporter.Run) error {
+	if mod.Config.SampleRate <= 0 || mod.Config.SampleRate > 1 {
+		return fmt.Errorf("invalid -statsd-sample-rate %v (use 0 < rate <= 1)", mod.Config.SampleRate)
+	}
+	if mod.Config.FlushInterval <= 0 {
+		return fmt.Errorf("invalid -statsd-flush-interval %s (use a duration above 0)", mod.Config.FlushInterval)
+	}
+	for _, tag := range strings.Split(mod.Config.Tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			mod.tags = append(mod.tags, sanitizeTag(tag))
+		}
+	}
+	conn, err := net.Dial("udp", mod.Config.Address)
+	if err != nil {
+		return err
+	}
+	mod.conn = conn
+	mod.Logger.Info(fmt.Sprintf("Sending metrics to StatsD at %s", mod.Config.Address))
+
+	mod.stop = make(chan struct{})
+	mod.done = make(chan struct{})
+	go func() {
+		defer close(mod.done)
+		ticker := time.NewTicker(mod.Config.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				mod.mu.Lock()
+				mod.flush()
+				mod.mu.Unlock()
+			case <-mod.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// RequestStarted ...
+func (mod *Module) RequestStarted(url string) {}
+
+// RequestDone sends a timing, a status code counter and, for failed requests,
+// an error counter
+func (mod *Module) RequestDone(url string, result *httptest.Result) {
+	if result == nil {
+		return
+	}
+	if mod.Config.SampleRate < 1 && rand.Float64() >= mod.Config.SampleRate {
+		return
+	}
+	status := strconv.Itoa(result.RespStatusCode)
+	errorKind := result.ErrorKind
+	if result.Error == "" && result.RespStatusCode >= 500 {
+		errorKind = "http_5xx"
+	}
+	if result.Error != "" {
+		status = "error"
+	}
+
+	var tags []string
+	if mod.Config.DogStatsD {
+		tags = append(tags, "url:"+sanitizeTag(result.URL), "status:"+status)
+		if errorKind != "" {
+			tags = append(tags, "error_kind:"+errorKind)
+		}
+		tags = append(tags, mod.tags...)
+	}
+
+	mod.mu.Lock()
+	defer mod.mu.Unlock()
+	if result.Error == "" {
+		ms := strconv.FormatFloat(float64(result.ReqRoundTrip)/float64(time.Millisecond), 'f', 3, 64)
+		mod.add(metric(mod.Config.Prefix+"request.duration", ms, "ms", mod.Config.SampleRate, tags))
+	}
+	mod.add(metric(mod.Config.Prefix+"response.status."+status, "1", "c", mod.Config.SampleRate, tags))
+	if errorKind != "" {
+		mod.add(metric(mod.Config.Prefix+"request.error."+errorKind, "1", "c", mod.Config.SampleRate, tags))
+	}
+}
+
+// metric formats one metric line, name:value|type[|@rate][|#tags]
+func metric(name string, value string, kind string, rate float64, tags []string) string {
+	line := name + ":" + value + "|" + kind
+	if rate < 1 {
+		line += "|@" + strconv.FormatFloat(rate, 'g', -1, 64)
+	}
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+// characters which separate the parts of a metric line
+var tagReplacer = strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_")
+
+func sanitizeTag(tag string) string {
+	return tagReplacer.Replace(tag)
+}
+
+// add appends a line to the current packet, sending the packet first if the line
+// does not fit. Must be called with the lock held.
+func (mod *Module) add(line string) {
+	if mod.buf.Len() > 0 && mod.buf.Len()+1+len(line) > mod.Config.PacketSize {
+		mod.flush()
+	}
+	if mod.buf.Len() > 0 {
+		mod.buf.WriteByte('\n')
+	}
+	mod.buf.WriteString(line)
+}
+
+// flush sends the current packet. Must be called with the lock held.
+func (mod *Module) flush() {
+	if mod.buf.Len() == 0 {
+		return
+	}
+	if _, err := mod.conn.Write(mod.buf.Bytes()); err != nil {
+		mod.failed++
+		mod.lastErr = err
+		if mod.Debug {
+			mod.Logger.Debug(fmt.Sprint("Failed to send StatsD packet: ", err))
+		}
+	} else {
+		mod.sent++
+	}
+	mod.buf.Reset()
+}
+
+// Export sends the remaining metrics and closes the socket
+func (mod *Module) Export(run *exporter.Run) error {
+	if mod.conn == nil {
+		return nil
+	}
+	close(mod.stop)
+	<-mod.done
+	mod.mu.Lock()
+	defer mod.mu.Unlock()
+	mod.flush()
+	mod.conn.Close()
+	if mod.Debug {
+		mod.Logger.Debug(fmt.Sprintf("Sent %d StatsD packets", mod.sent))
+	}
+	if mod.failed > 0 {
+		return fmt.Errorf("%d of %d StatsD packets could not be sent: %v", mod.failed, mod.failed+mod.sent, mod.lastErr)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package statsd
+
+import (
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"http-bomber/exporter"
+	"http-bomber/httptest"
+	"http-bomber/logging"
+)
+
+func TestMetric(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		kind  string
+		rate  float64
+		tags  []string
+		want  string
+	}{
+		{"a.duration", "12.500", "ms", 1, nil, "a.duration:12.500|ms"},
+		{"a.status.200", "1", "c", 0.25, nil, "a.status.200:1|c|@0.25"},
+		{"a.status.200", "1", "c", 1, []string{"url:x", "env:dev"}, "a.status.200:1|c|#url:x,env:dev"},
+		{"a.error.timeout", "1", "c", 0.1, []string{"status:error"}, "a.error.timeout:1|c|@0.1|#status:error"},
+	}
+	for _, tt := range tests {
+		if got := metric(tt.name, tt.value, tt.kind, tt.rate, tt.tags); got != tt.want {
+			t.Errorf("metric %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{"env:staging", "env:staging"},
+		{"url:http://example.org/a?b=1,2", "url:http://example.org/a?b=1_2"},
+		{"a|b#c\nd", "a_b_c_d"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeTag(tt.tag); got != tt.want {
+			t.Errorf("%q sanitized as %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+}
+
+// listen starts a UDP agent and returns a function reading the packets received so far
+func listen(t *testing.T) (string, func() []string) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	read := func() []string {
+		var packets []string
+		buf := make([]byte, 65536)
+		for {
+			conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return packets
+			}
+			packets = append(packets, string(buf[:n]))
+		}
+	}
+	return conn.LocalAddr().String(), read
+}
+
+func newModule(t *testing.T, config Config) *Module {
+	logger := &logging.Logger{}
+	logger.Init(ioutil.Discard)
+	mod := &Module{Config: config}
+	mod.Init(&sync.WaitGroup{}, logger, false)
+	if err := mod.Begin(&exporter.Run{ID: "run-1"}); err != nil {
+		t.Fatal(err)
+	}
+	return mod
+}
+
+func TestDogStatsD(t *testing.T) {
+	addr, read := listen(t)
+	mod := newModule(t, Config{
+		Address: addr, Prefix: "http_bomber.", DogStatsD: true, Tags: "env:staging, team|web",
+		SampleRate: 1, PacketSize: 1432, FlushInterval: time.Hour,
+	})
+	const url = "http://example.org/a,b"
+	for _, r := range []*httptest.Result{
+		{URL: url, RespStatusCode: 200, ReqRoundTrip: 20 * time.Millisecond},
+		{URL: url, RespStatusCode: 503, ReqRoundTrip: 1500 * time.Microsecond},
+		{URL: url, Error: "dial tcp: i/o timeout", ErrorKind: "timeout", ReqRoundTrip: time.Second},
+	} {
+		mod.RequestStarted(url)
+		mod.RequestDone(url, r)
+	}
+	// a request which could not be formed sends nothing
+	mod.RequestStarted(url)
+	mod.RequestDone(url, nil)
+	if err := mod.Export(&exporter.Run{}); err != nil {
+		t.Fatal(err)
+	}
+
+	packets := read()
+	if len(packets) != 1 {
+		t.Fatalf("%d packets, want 1", len(packets))
+	}
+	tags := ",env:staging,team_web"
+	want := []string{
+		"http_bomber.request.duration:20.000|ms|#url:http://example.org/a_b,status:200" + tags,
+		"http_bomber.response.status.200:1|c|#url:http://example.org/a_b,status:200" + tags,
+		"http_bomber.request.duration:1.500|ms|#url:http://example.org/a_b,status:503,error_kind:http_5xx" + tags,
+		"http_bomber.response.status.503:1|c|#url:http://example.org/a_b,status:503,error_kind:http_5xx" + tags,
+		"http_bomber.request.error.http_5xx:1|c|#url:http://example.org/a_b,status:503,error_kind:http_5xx" + tags,
+		// no timing without a response
+		"http_bomber.response.status.error:1|c|#url:http://example.org/a_b,status:error,error_kind:timeout" + tags,
+		"http_bomber.request.error.timeout:1|c|#url:http://example.org/a_b,status:error,error_kind:timeout" + tags,
+	}
+	if got := strings.Split(packets[0], "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got lines\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestPacketSize(t *testing.T) {
+	addr, read := listen(t)
+	const packetSize = 100
+	mod := newModule(t, Config{Address: addr, Prefix: "p.", SampleRate: 1, PacketSize: packetSize, FlushInterval: time.Hour})
+	const requests = 20
+	for i := 0; i < requests; i++ {
+		mod.RequestDone("http://a", &httptest.Result{URL: "http://a", RespStatusCode: 200, ReqRoundTrip: time.Duration(i) * time.Millisecond})
+	}
+	if err := mod.Export(&exporter.Run{}); err != nil {
+		t.Fatal(err)
+	}
+
+	packets := read()
+	var lines []string
+	for _, p := range packets {
+		if len(p) > packetSize {
+			t.Errorf("packet of %d bytes, want at most %d", len(p), packetSize)
+		}
+		// the packets are filled before they are sent
+		if len(p) < packetSize/2 && p != packets[len(packets)-1] {
+			t.Errorf("packet of only %d bytes", len(p))
+		}
+		lines = append(lines, strings.Split(p, "\n")...)
+	}
+	if len(lines) != 2*requests {
+		t.Fatalf("%d lines in %d packets, want %d", len(lines), len(packets), 2*requests)
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "p.request.duration:") && !strings.HasPrefix(line, "p.response.status.200:1|c") {
+			t.Errorf("line %d %q is incomplete", i, line)
+		}
+	}
+	if lines[2] != "p.request.duration:1.000|ms" {
+		t.Errorf("line %q, want the timing of the second request", lines[2])
+	}
+}
+
+func TestSampleRate(t *testing.T) {
+	addr, read := listen(t)
+	mod := newModule(t, Config{Address: addr, Prefix: "p.", SampleRate: 0.5, PacketSize: 65000, FlushInterval: time.Hour})
+	const requests = 2000
+	for i := 0; i < requests; i++ {
+		mod.RequestDone("http://a", &httptest.Result{URL: "http://a", RespStatusCode: 200})
+	}
+	if err := mod.Export(&exporter.Run{}); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, p := range read() {
+		lines = append(lines, strings.Split(p, "\n")...)
+	}
+	// 2 lines per sampled request, sampled with a rate of 0.5
+	if sampled := len(lines) / 2; sampled < requests/2-150 || sampled > requests/2+150 {
+		t.Errorf("%d sampled requests, want about %d", sampled, requests/2)
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, "|@0.5") {
+			t.Errorf("line %q without sample rate", line)
+			break
+		}
+	}
+}
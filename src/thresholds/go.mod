@@ -0,0 +1,3 @@
+module http-bomber/thresholds
+
+go 1.16
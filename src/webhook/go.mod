@@ -0,0 +1,3 @@
+module http-bomber/webhook
+
+go 1.16
@@ -0,0 +1,267 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"http-bomber/exporter"
+	"http-bomber/httptest"
+	"http-bomber/logging"
+	"http-bomber/stats"
+	"http-bomber/thresholds"
+)
+
+// Config holds configuration for the webhook exporter
+type Config struct {
+	URL string
+	// On is "end" (after every run) or "breach" (only when a threshold failed)
+	On           string
+	TemplatePath string
+	ContentType  string
+	Headers      string
+	Retries      int
+	Timeout      time.Duration
+}
+
+// Module ...
+type Module struct {
+	Config    Config
+	WaitGroup *sync.WaitGroup
+	Logger    *logging.Logger
+	Debug     bool
+	client    http.Client
+	// enabled by name with -export webhook
+	enabled bool
+}
+
+// Payload is the data passed to the template
+type Payload struct {
+	ID       string
+	Start    time.Time
+	End      time.Time
+	Duration time.Duration
+	// Overall holds the statistics of all URLs, URLs those of each URL
+	Overall *stats.Summary
+	URLs    []*stats.Summary
+	// Passed is false if a threshold failed, Failed holds the failing checks
+	Passed bool
+	Checks []*thresholds.Outcome
+	Failed []*thresholds.Outcome
+	// Config maps option names to their values (secrets masked)
+	Config map[string]string
+}
+
+// DefaultTemplate renders a JSON document with a "text" field which chat webhooks
+// (e.g. Slack or Mattermost) display as the message
+const DefaultTemplate = `{
+  "text": {{ json (printf "http-bomber run %s %s: %d requests, %s%% errors, %.1f req/s, p95 %s ms%s" .ID (status .Passed) .Overall.Requests (percent .Overall.ErrorRate) .Overall.RPS (ms .Overall.Latency.P95) (failed .Failed)) }},
+  "run_id": {{ json .ID }},
+  "passed": {{ .Passed }},
+  "start": {{ json .Start }},
+  "end": {{ json .End }},
+  "requests": {{ .Overall.Requests }},
+  "errors": {{ .Overall.Errors }},
+  "error_rate": {{ .Overall.ErrorRate }},
+  "rps": {{ .Overall.RPS }},
+  "latency": {{ json .Overall.Latency }},
+  "failed_checks": [{{ range $i, $o := .Failed }}{{ if $i }}, {{ end }}{{ json $o.Message }}{{ end }}]
+}
+`
+
+var funcs = template.FuncMap{
+	// json encodes a value, e.g. to quote strings inside JSON payloads
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"ms": func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 2, 64)
+	},
+	"percent": func(v float64) string {
+		return strconv.FormatFloat(v*100, 'f', 2, 64)
+	},
+	"status": func(passed bool) string {
+		if passed {
+			return "passed"
+		}
+		return "FAILED"
+	},
+	"failed": func(outcomes []*thresholds.Outcome) string {
+		if len(outcomes) == 0 {
+			return ""
+		}
+		messages := make([]string, 0, len(outcomes))
+		for _, o := range outcomes {
+			messages = append(messages, o.Message)
+		}
+		return ", failed checks: " + strings.Join(messages, "; ")
+	},
+}
+
+func init() {
+	exporter.Register(&Module{})
+}
+
+// Name ...
+func (mod *Module) Name() string {
+	return "webhook"
+}
+
+// RegisterFlags ...
+func (mod *Module) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&mod.Config.URL, "webhook-url", "", "POST a run summary to this URL")
+	fs.StringVar(&mod.Config.On, "webhook-on", "end", "When to call the webhook: after every run or only when a threshold failed (end|breach)")
+	fs.StringVar(&mod.Config.TemplatePath, "webhook-template", "", "Go text/template file for the webhook payload (default: a JSON summary)")
+	fs.StringVar(&mod.Config.ContentType, "webhook-content-type", "application/json", "Content type of the webhook payload")
+	fs.StringVar(&mod.Config.Headers, "webhook-headers", "", "Additional headers for the webhook request example-> Authorization:Bearer xyz")
+	fs.IntVar(&mod.Config.Retries, "webhook-retries", 3, "Retries of a failed webhook request")
+	fs.DurationVar(&mod.Config.Timeout, "webhook-timeout", 10*time.Second, "Timeout of a webhook request")
+}
+
+// Enabled ...
+func (mod *Module) Enabled() bool {
+	return mod.Config.URL != "" || mod.enabled
+}
+
+// Enable has no default URL, Begin fails without -webhook-url
+func (mod *Module) Enable() {
+	mod.enabled = true
+}
+
+// Init ...
+func (mod *Module) Init(wg *sync.WaitGroup, logger *logging.Logger, debug bool) {
+	mod.WaitGroup = wg
+	mod.Logger = logger
+	mod.Debug = debug
+	mod.client = http.Client{Timeout: mod.Config.Timeout}
+}
+
+// Begin checks the options before the tests start
+func (mod *Module) Begin(run *exporter.Run) error {
+	if mod.Config.URL == "" {
+		return errors.New("-webhook-url is required")
+	}
+	switch mod.Config.On {
+	case "end", "breach":
+	default:
+		return fmt.Errorf("invalid -webhook-on %q (use end or breach)", mod.Config.On)
+	}
+	return nil
+}
+
+// RequestStarted ...
+func (mod *Module) RequestStarted(url string) {}
+
+// RequestDone ...
+func (mod *Module) RequestDone(url string, result *httptest.Result) {}
+
+// Export renders the payload and posts it to the webhook
+func (mod *Module) Export(run *exporter.Run) error {
+	if mod.Config.On == "breach" && !thresholds.Failed(run.Outcomes) {
+		return nil
+	}
+
+	text := DefaultTemplate
+	if mod.Config.TemplatePath != "" {
+		data, err := ioutil.ReadFile(mod.Config.TemplatePath)
+		if err != nil {
+			return err
+		}
+		text = string(data)
+	}
+	tmpl, err := template.New("webhook").Funcs(funcs).Parse(text)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, NewPayload(run)); err != nil {
+		return err
+	}
+
+	mod.Logger.Info(fmt.Sprintf("Calling webhook %s", mod.Config.URL))
+	return mod.post(body.Bytes())
+}
+
+// NewPayload collects the template data of a run
+func NewPayload(run *exporter.Run) *Payload {
+	p := &Payload{
+		ID:       run.ID,
+		Start:    run.Start,
+		End:      run.End,
+		Duration: run.End.Sub(run.Start),
+		Overall:  &stats.Summary{},
+		Passed:   !thresholds.Failed(run.Outcomes),
+		Checks:   run.Outcomes,
+		Config:   make(map[string]string),
+	}
+	if run.Report != nil {
+		p.Overall = run.Report.Overall
+		p.URLs = run.Report.URLs
+	}
+	for _, o := range run.Outcomes {
+		if !o.Passed {
+			p.Failed = append(p.Failed, o)
+		}
+	}
+	// the payload goes to a third party, mask the config also if the run didn't
+	for _, s := range exporter.Mask(run.Config) {
+		p.Config[s.Name] = s.Value
+	}
+	return p
+}
+
+// post sends the payload, retrying on connection errors, 429 and 5xx responses
+func (mod *Module) post(body []byte) error {
+	var err error
+	backoff := time.Second
+	for attempt := 0; attempt <= mod.Config.Retries; attempt++ {
+		if attempt > 0 {
+			if mod.Debug {
+				mod.Logger.Debug(fmt.Sprintf("Webhook failed (%v), retrying in %s", err, backoff))
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		var retry bool
+		retry, err = mod.send(body)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// send makes one webhook request and tells if a failure may be retried
+func (mod *Module) send(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", mod.Config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for _, h := range strings.Split(mod.Config.Headers, ",") {
+		if kv := strings.SplitN(h, ":", 2); len(kv) == 2 {
+			req.Header.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+		}
+	}
+	req.Header.Set("Content-Type", mod.Config.ContentType)
+	resp, err := mod.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return false, nil
+}
@@ -0,0 +1,193 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"http-bomber/exporter"
+	"http-bomber/logging"
+	"http-bomber/stats"
+	"http-bomber/thresholds"
+)
+
+func newModule(config Config) *Module {
+	logger := &logging.Logger{}
+	logger.Init(ioutil.Discard)
+	mod := &Module{Config: config}
+	mod.Init(&sync.WaitGroup{}, logger, false)
+	return mod
+}
+
+func TestEnableWithoutURL(t *testing.T) {
+	mod := newModule(Config{On: "end"})
+	if mod.Enabled() {
+		t.Fatal("enabled without -webhook-url or -export webhook")
+	}
+	mod.Enable()
+	if !mod.Enabled() {
+		t.Fatal("not enabled with -export webhook")
+	}
+	if err := mod.Begin(&exporter.Run{}); err == nil || !strings.Contains(err.Error(), "-webhook-url is required") {
+		t.Errorf("error %v, want the missing URL", err)
+	}
+}
+
+func TestBegin(t *testing.T) {
+	tests := []struct {
+		on      string
+		wantErr bool
+	}{
+		{"end", false},
+		{"breach", false},
+		{"failure", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		mod := newModule(Config{URL: "http://127.0.0.1:9", On: tt.on})
+		err := mod.Begin(&exporter.Run{})
+		if tt.wantErr != (err != nil) {
+			t.Errorf("-webhook-on %q: error %v, want error %v", tt.on, err, tt.wantErr)
+		}
+	}
+}
+
+// receiver is a webhook which fails the first requests
+type receiver struct {
+	failures int
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []string
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, string(body))
+	if len(r.requests) <= r.failures {
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+	}
+}
+
+func testRun(t *testing.T, failed bool) *exporter.Run {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	th, err := thresholds.ParseOne("p95<300ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	overall := &stats.Summary{URL: "all", Requests: 100, Errors: 2, ErrorRate: 0.02, RPS: 10, Latency: stats.Latency{P95: 250 * time.Millisecond}}
+	return &exporter.Run{
+		ID:       "run-1",
+		Start:    start,
+		End:      start.Add(10 * time.Second),
+		Report:   &stats.Report{Overall: overall, URLs: []*stats.Summary{overall}},
+		Outcomes: []*thresholds.Outcome{{Threshold: th, Passed: !failed, Message: "p95<300ms (actual: 250ms)"}},
+		Config:   []exporter.Setting{{Name: "url", Value: "http://example.org"}, {Name: "webhook-headers", Value: "Authorization:Bearer xyz"}},
+	}
+}
+
+func TestExportDefaultPayload(t *testing.T) {
+	r := &receiver{}
+	server := httptest.NewServer(r)
+	defer server.Close()
+	mod := newModule(Config{URL: server.URL, On: "end", ContentType: "application/json", Headers: "Authorization:Bearer xyz, X-Source: ci"})
+	if err := mod.Export(testRun(t, true)); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.requests) != 1 {
+		t.Fatalf("%d requests, want 1", len(r.requests))
+	}
+	req := r.requests[0]
+	if req.Header.Get("Content-Type") != "application/json" || req.Header.Get("Authorization") != "Bearer xyz" || req.Header.Get("X-Source") != "ci" {
+		t.Errorf("headers %v", req.Header)
+	}
+	var payload struct {
+		Text         string   `json:"text"`
+		RunID        string   `json:"run_id"`
+		Passed       bool     `json:"passed"`
+		Requests     int      `json:"requests"`
+		ErrorRate    float64  `json:"error_rate"`
+		FailedChecks []string `json:"failed_checks"`
+	}
+	if err := json.Unmarshal([]byte(r.bodies[0]), &payload); err != nil {
+		t.Fatalf("invalid JSON payload %s: %v", r.bodies[0], err)
+	}
+	wantText := "http-bomber run run-1 FAILED: 100 requests, 2.00% errors, 10.0 req/s, p95 250.00 ms, failed checks: p95<300ms (actual: 250ms)"
+	if payload.Text != wantText || payload.RunID != "run-1" || payload.Passed || payload.Requests != 100 || payload.ErrorRate != 0.02 {
+		t.Errorf("payload %+v, want %q", payload, wantText)
+	}
+	if len(payload.FailedChecks) != 1 || payload.FailedChecks[0] != "p95<300ms (actual: 250ms)" {
+		t.Errorf("failed checks %v", payload.FailedChecks)
+	}
+}
+
+func TestExportOnBreach(t *testing.T) {
+	r := &receiver{}
+	server := httptest.NewServer(r)
+	defer server.Close()
+	mod := newModule(Config{URL: server.URL, On: "breach"})
+	if err := mod.Export(testRun(t, false)); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.requests) != 0 {
+		t.Errorf("%d requests for a passed run, want none", len(r.requests))
+	}
+	if err := mod.Export(testRun(t, true)); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.requests) != 1 {
+		t.Errorf("%d requests for a failed run, want 1", len(r.requests))
+	}
+}
+
+func TestExportTemplateGetsMaskedConfig(t *testing.T) {
+	r := &receiver{}
+	server := httptest.NewServer(r)
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "payload.tmpl")
+	if err := os.WriteFile(path, []byte(`{{ .ID }} {{ index .Config "url" }} {{ index .Config "webhook-headers" }} {{ len .URLs }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mod := newModule(Config{URL: server.URL, On: "end", TemplatePath: path, ContentType: "text/plain"})
+	if err := mod.Export(testRun(t, false)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "run-1 http://example.org " + exporter.Masked + " 1"; r.bodies[0] != want {
+		t.Errorf("payload %q, want %q", r.bodies[0], want)
+	}
+}
+
+func TestExportRetries(t *testing.T) {
+	tests := []struct {
+		failures int
+		retries  int
+		requests int
+		wantErr  bool
+	}{
+		{failures: 1, retries: 1, requests: 2},
+		{failures: 2, retries: 1, requests: 2, wantErr: true},
+		{failures: 1, retries: 0, requests: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		r := &receiver{failures: tt.failures}
+		server := httptest.NewServer(r)
+		mod := newModule(Config{URL: server.URL, On: "end", Retries: tt.retries})
+		err := mod.Export(testRun(t, false))
+		server.Close()
+		if tt.wantErr != (err != nil) || len(r.requests) != tt.requests {
+			t.Errorf("%d failures, %d retries: %d requests (error %v), want %d", tt.failures, tt.retries, len(r.requests), err, tt.requests)
+		}
+		if err != nil && !strings.Contains(err.Error(), "503") {
+			t.Errorf("error %v, want the status", err)
+		}
+	}
+}